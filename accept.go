@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// preferred returns the one of types, media types such as "text/html",
+// which the Accept header accept names with the highest quality, or ""
+// if it names none of them with a quality above zero. Only media ranges
+// naming a type exactly count, not wildcards such as "*/*", which WebDAV
+// clients and command line tools send. Of types with the same quality,
+// the first is returned.
+func preferred(accept string, types ...string) string {
+	best, bestQ := "", 0.0
+	for _, typ := range types {
+		if q := quality(accept, typ); q > bestQ {
+			best, bestQ = typ, q
+		}
+	}
+	return best
+}
+
+// quality returns the quality value, from 0 to 1, of the media range in
+// the Accept header accept which names typ, or 0 if none does. A media
+// range without a q parameter, or with one which does not parse, has a
+// quality of 1.
+func quality(accept, typ string) float64 {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), typ) {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || (param[0] != 'q' && param[0] != 'Q') || param[1] != '=' {
+				continue
+			}
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil && v >= 0 && v <= 1 {
+				q = v
+			}
+		}
+		return q
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// rotatingFile is an io.Writer which appends to the file at path. Once the
+// file reaches maxSize bytes it is renamed to path.1, replacing any previous
+// path.1, and a new file started. If maxSize is zero the file is never
+// rotated by size; reopen may be used to cooperate with external rotation.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openRotatingFile returns a rotatingFile appending to path.
+func openRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	r := rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// reopen closes and reopens the file, for use after it has been moved
+// aside by an external log rotation tool.
+func (r *rotatingFile) reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.f.Close()
+	return r.open()
+}
+
+// rotate moves the current file to path.1 and opens a new one.
+// r.mu must be held.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return errors.WithStack(err)
+	}
+	return r.open()
+}
+
+// open opens the file for appending. r.mu must be held, or r not shared.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// archive serves the served tree as a tar, gzipped tar, or zip file,
+// according to the extension of the request path. Entries are written
+// as the tree is walked and blobs are copied into the archive as they
+// are read, so memory use does not grow with the size of the tree.
+//
+// As in git archive, entries are not prefixed with a directory, and
+// submodules are represented by empty directories.
+type archive struct {
+	served *served
+	filter *pathFilter // if not nil, paths it does not allow are omitted
+	level  int         // the compress/flate level of zip and gzipped tar files
+}
+
+// parseCompression returns the compress/flate level named by s, one of
+// store, fast, default, or best.
+func parseCompression(s string) (int, error) {
+	switch s {
+	case "store":
+		return flate.NoCompression, nil
+	case "fast":
+		return flate.BestSpeed, nil
+	case "default", "":
+		return flate.DefaultCompression, nil
+	case "best":
+		return flate.BestCompression, nil
+	default:
+		return 0, errors.Errorf("unknown compression %q, want store, fast, default, or best", s)
+	}
+}
+
+func (a *archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	commit, root := a.served.get()
+	modTime := commitTime(commit)
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	var write func(io.Writer, *git.Tree, time.Time, *pathFilter) error
+	switch {
+	case strings.HasSuffix(r.URL.Path, ".tar"):
+		w.Header().Set("Content-Type", "application/x-tar")
+		write = writeTar
+	case strings.HasSuffix(r.URL.Path, ".tar.gz"):
+		w.Header().Set("Content-Type", "application/gzip")
+		write = func(w io.Writer, root *git.Tree, modTime time.Time, filter *pathFilter) error {
+			zw, err := gzip.NewWriterLevel(w, a.level)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if err := writeTar(zw, root, modTime, filter); err != nil {
+				return err
+			}
+			return errors.WithStack(zw.Close())
+		}
+	case strings.HasSuffix(r.URL.Path, ".zip"):
+		w.Header().Set("Content-Type", "application/zip")
+		write = func(w io.Writer, root *git.Tree, modTime time.Time, filter *pathFilter) error {
+			return writeZip(w, root, modTime, filter, a.level)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+	if err := write(throttle(w, r), root, modTime, a.filter); err != nil {
+		// the response has started, so the error cannot be reported to
+		// the client other than by not completing it.
+		log.Printf("%+v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeTar writes the contents of root to w as a tar file, omitting paths
+// filter does not allow.
+func writeTar(w io.Writer, root *git.Tree, modTime time.Time, filter *pathFilter) error {
+	tw := tar.NewWriter(w)
+	err := root.Walk(func(p string, e *git.Entry) error {
+		if !filter.allowed(p, e.Mode.IsDir()) {
+			return nil
+		}
+		hdr := tar.Header{
+			Name:    p,
+			Mode:    int64(e.Mode.Perm()),
+			ModTime: modTime,
+		}
+		if e.Mode.IsDir() || e.Mode&os.ModeIrregular != 0 {
+			hdr.Name += "/"
+			hdr.Mode = 0755
+			hdr.Typeflag = tar.TypeDir
+			return errors.WithStack(tw.WriteHeader(&hdr))
+		}
+		b, err := e.Blob()
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		if e.Mode&os.ModeSymlink != 0 {
+			target, err := ioutil.ReadAll(b)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = string(target)
+			return errors.WithStack(tw.WriteHeader(&hdr))
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = b.Size
+		if err := tw.WriteHeader(&hdr); err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = io.Copy(tw, b)
+		return errors.WithStack(err)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(tw.Close())
+}
+
+// writeZip writes the contents of root to w as a zip file, omitting paths
+// filter does not allow. Files are compressed at the compress/flate level,
+// or if it is flate.NoCompression, stored.
+func writeZip(w io.Writer, root *git.Tree, modTime time.Time, filter *pathFilter, level int) error {
+	zw := zip.NewWriter(w)
+	method := zip.Deflate
+	switch level {
+	case flate.NoCompression:
+		method = zip.Store
+	case flate.DefaultCompression:
+		// zip's own compressor.
+	default:
+		// files are written one at a time, so one compressor serves for
+		// all of them.
+		var fw *flate.Writer
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			if fw == nil {
+				var err error
+				fw, err = flate.NewWriter(w, level)
+				return fw, err
+			}
+			fw.Reset(w)
+			return fw, nil
+		})
+	}
+	err := root.Walk(func(p string, e *git.Entry) error {
+		if !filter.allowed(p, e.Mode.IsDir()) {
+			return nil
+		}
+		hdr := zip.FileHeader{
+			Name:     p,
+			Method:   method,
+			Modified: modTime,
+		}
+		if e.Mode.IsDir() || e.Mode&os.ModeIrregular != 0 {
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+			hdr.SetMode(os.ModeDir | 0755)
+			_, err := zw.CreateHeader(&hdr)
+			return errors.WithStack(err)
+		}
+		hdr.SetMode(e.Mode)
+		b, err := e.Blob()
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		fw, err := zw.CreateHeader(&hdr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// the content of a symlink is its target, as zip expects.
+		_, err = io.Copy(fw, b)
+		return errors.WithStack(err)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(zw.Close())
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davecheney/gitdav/internal/git"
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// importTree returns the tree of a commit, in a new repository, holding
+// the files named by paths, whose content is given by content.
+func importTree(t testing.TB, paths []string, content func(p string) []byte) *git.Tree {
+	t.Helper()
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if _, err := gittest.Git(dir, "init", "-q", "--bare"); err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	fmt.Fprintf(&stream, "commit refs/heads/master\ncommitter C O Mitter <committer@example.com> 1112911993 +0000\ndata 0\n")
+	for _, p := range paths {
+		c := content(p)
+		fmt.Fprintf(&stream, "M 100644 inline %s\ndata %d\n", p, len(c))
+		stream.Write(c)
+		stream.WriteString("\n")
+	}
+	cmd := gittest.Command(dir, "fast-import", "--quiet")
+	cmd.Stdin = &stream
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git fast-import: %v: %s", err, out)
+	}
+
+	repo, err := git.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	_, tree, err := resolve(repo, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// wideTree returns a tree of width directories, each holding width
+// files.
+func wideTree(t testing.TB, width int) *git.Tree {
+	var paths []string
+	for i := 0; i < width; i++ {
+		for j := 0; j < width; j++ {
+			paths = append(paths, fmt.Sprintf("d%03d/f%03d.txt", i, j))
+		}
+	}
+	return importTree(t, paths, func(p string) []byte {
+		return []byte(p + "\n")
+	})
+}
+
+// peakHeap is an io.Writer which discards what is written, counting it,
+// and recording the most memory in use on the heap, after a collection,
+// every sampleEvery writes.
+type peakHeap struct {
+	written int64
+	writes  int
+	peak    uint64
+}
+
+const sampleEvery = 256
+
+func (h *peakHeap) Write(p []byte) (int, error) {
+	if h.writes%sampleEvery == 0 {
+		h.sample()
+	}
+	h.writes++
+	h.written += int64(len(p))
+	return len(p), nil
+}
+
+func (h *peakHeap) sample() {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc > h.peak {
+		h.peak = ms.HeapAlloc
+	}
+}
+
+// archiver writes root to w in an archive format.
+type archiver struct {
+	name  string
+	write func(w io.Writer, root *git.Tree) error
+}
+
+// archivers are the archive formats served.
+var archivers = []archiver{
+	{"tar", func(w io.Writer, root *git.Tree) error {
+		return writeTar(w, root, time.Time{}, nil)
+	}},
+	{"zip", func(w io.Writer, root *git.Tree) error {
+		return writeZip(w, root, time.Time{}, nil, flate.DefaultCompression)
+	}},
+}
+
+// heapGrowth returns how much more heap is in use, at most, writing root
+// with write, than before, and the length of what was written.
+func heapGrowth(t testing.TB, root *git.Tree, write func(io.Writer, *git.Tree) error) (uint64, int64) {
+	t.Helper()
+	var h peakHeap
+	h.sample()
+	base := h.peak
+	if err := write(&h, root); err != nil {
+		t.Fatal(err)
+	}
+	h.sample()
+	return h.peak - base, h.written
+}
+
+// TestArchiveMemory checks the heap in use writing an archive does not
+// grow with the number of files in the tree, beyond the entries of the
+// directories being walked, and the central directory a zip file ends
+// with, of less than 100 bytes a file.
+func TestArchiveMemory(t *testing.T) {
+	small, large := wideTree(t, 32), wideTree(t, 64) // 1024 and 4096 files
+	for _, a := range archivers {
+		t.Run(a.name, func(t *testing.T) {
+			s, _ := heapGrowth(t, small, a.write)
+			l, _ := heapGrowth(t, large, a.write)
+			const bound = 256 << 10
+			if l > s+bound {
+				t.Errorf("heap grew by %d bytes writing 1024 files, %d writing 4096", s, l)
+			}
+		})
+	}
+}
+
+func BenchmarkArchive(b *testing.B) {
+	root := wideTree(b, 64)
+	for _, a := range archivers {
+		b.Run(a.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := a.write(ioutil.Discard, root); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// largeFileSize is the length of the file in the tree of largeFileTree.
+const largeFileSize = 16 << 20
+
+// largeFileTree returns a tree holding a single file of largeFileSize
+// random, so incompressible, bytes.
+func largeFileTree(t testing.TB) *git.Tree {
+	content := make([]byte, largeFileSize)
+	rand.New(rand.NewSource(1)).Read(content)
+	return importTree(t, []string{"large.bin"}, func(string) []byte {
+		return content
+	})
+}
+
+// compressedArchivers returns the compressed archive formats served, at
+// each level of -archive-compression.
+func compressedArchivers(t testing.TB) []archiver {
+	var archivers []archiver
+	for _, name := range []string{"store", "fast", "default", "best"} {
+		level, err := parseCompression(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archivers = append(archivers, archiver{"tar.gz/" + name, func(w io.Writer, root *git.Tree) error {
+			zw, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				return err
+			}
+			if err := writeTar(zw, root, time.Time{}, nil); err != nil {
+				return err
+			}
+			return zw.Close()
+		}}, archiver{"zip/" + name, func(w io.Writer, root *git.Tree) error {
+			return writeZip(w, root, time.Time{}, nil, level)
+		}})
+	}
+	return archivers
+}
+
+// TestArchiveLargeFile checks files are copied into archives as they are
+// read, rather than read whole, in every format and at every compression
+// level.
+func TestArchiveLargeFile(t *testing.T) {
+	root := largeFileTree(t)
+	for _, a := range append(archivers, compressedArchivers(t)...) {
+		t.Run(a.name, func(t *testing.T) {
+			const bound = largeFileSize / 4
+			growth, written := heapGrowth(t, root, a.write)
+			if written < largeFileSize {
+				// the file is random, so cannot be compressed.
+				t.Fatalf("wrote %d bytes, want at least %d", written, largeFileSize)
+			}
+			if growth > bound {
+				t.Errorf("heap grew by %d bytes writing a file of %d bytes", growth, largeFileSize)
+			}
+		})
+	}
+}
+
+func BenchmarkArchiveLargeFile(b *testing.B) {
+	root := largeFileTree(b)
+	for _, a := range append(archivers, compressedArchivers(b)...) {
+		b.Run(a.name, func(b *testing.B) {
+			b.SetBytes(largeFileSize)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := a.write(ioutil.Discard, root); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestTarModes checks files extracted from a tar file by tar have the
+// permissions recorded in the tree: executable files 0755, and others
+// 0644.
+func TestTarModes(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not installed")
+	}
+	repo := openTestRepo(t)
+	defer repo.Close()
+	_, root := serveRev(t, repo, "master").get()
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := writeTar(&buf, root, time.Time{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// -p applies the recorded permissions, regardless of the umask.
+	cmd := exec.Command("tar", "-x", "-p", "-f", "-", "-C", dir)
+	cmd.Stdin = &buf
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("tar: %v: %s", err, out)
+	}
+
+	out, err := gittest.Git(testRepo, "ls-tree", "-r", "-z", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := map[string]os.FileMode{"100644": 0644, "100755": 0755}
+	checked := make(map[os.FileMode]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\x00"), "\x00") {
+		i := strings.IndexByte(line, '\t')
+		mode, ok := modes[strings.Fields(line[:i])[0]]
+		if !ok {
+			continue
+		}
+		p := line[i+1:]
+		fi, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			t.Errorf("%q: %v", p, err)
+			continue
+		}
+		if !fi.Mode().IsRegular() || fi.Mode().Perm() != mode {
+			t.Errorf("%q: mode %v, want %v", p, fi.Mode(), mode)
+		}
+		checked[mode] = true
+	}
+	if !checked[0644] || !checked[0755] {
+		t.Fatalf("the tree holds no files of mode 0644 or 0755: %v", checked)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// maxAtCache is the number of times for which at remembers the commit.
+const maxAtCache = 1024
+
+// at serves the tree of the most recent commit at or before a time, in
+// the history of the served commit, at /at/<time>/. The commit found
+// for each time is cached until the served commit changes. As commit
+// times are recorded to the second, times are cached by the second.
+type at struct {
+	served *served
+
+	// files returns a handler for the files of s, whose paths follow
+	// prefix in the URL.
+	files func(prefix string, s *served) http.Handler
+
+	mu    sync.Mutex
+	head  string            // id of the served commit when cache was filled
+	cache map[int64]*served // nil if no commit predates the time
+}
+
+func (a *at) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/at/")
+	ts := p
+	if i := strings.Index(p, "/"); i >= 0 {
+		ts = p[:i]
+	}
+	t, err := parseTime(ts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	head, _ := a.served.get()
+	if head == nil {
+		http.Error(w, "serving a tree, not a commit", http.StatusNotFound)
+		return
+	}
+	s, err := a.lookup(head, t)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if s == nil {
+		http.Error(w, "no commit at or before "+t.Format(time.RFC3339), http.StatusNotFound)
+		return
+	}
+	a.files("/at/"+ts, s).ServeHTTP(w, r)
+}
+
+// lookup returns the commit, and tree, of the most recent commit at or
+// before t in the history of head, or nil if there is none. The history
+// is walked without holding a.mu, so a long walk does not delay requests
+// for other times.
+func (a *at) lookup(head *git.Commit, t time.Time) (*served, error) {
+	a.mu.Lock()
+	s, ok := a.cache[t.Unix()]
+	ok = ok && a.head == head.String()
+	a.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := commitAt(head, t)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.head != head.String() || len(a.cache) >= maxAtCache {
+		a.head, a.cache = head.String(), make(map[int64]*served)
+	}
+	a.cache[t.Unix()] = s
+	return s, nil
+}
+
+// commitAt returns the commit, and tree, of the most recent commit at or
+// before t in the history of head, or nil if there is none.
+func commitAt(head *git.Commit, t time.Time) (*served, error) {
+	log := head.Log()
+	for {
+		c, err := log.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !c.Time.After(t) {
+			tree, err := c.Tree()
+			if err != nil {
+				return nil, err
+			}
+			return &served{commit: c, tree: tree}, nil
+		}
+	}
+}
+
+// parseTime parses the time in an /at/ path; seconds since the Unix
+// epoch, an RFC 3339 time, or a date, 2006-01-02, meaning the end of
+// that day in UTC.
+func parseTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, errors.Errorf("invalid time %q, expected seconds since the epoch, an RFC 3339 time, or a date", s)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// blobs wraps the WebDAV handler, serving GET and HEAD requests for blobs
+// itself so the blob's id is used as its ETag. The size of a blob is known
+// from its object header, so HEAD requests read at most enough of the
+// content to detect its type, and only if that is not known from the file
+// extension. Last-Modified is the time of the commit being served. As the
+// ETag changes whenever the content does, GET requests with an If-Match
+// header naming another ETag, as when the served commit has changed,
+// fail with 412 Precondition Failed.
+// If md5 is not nil, GET requests for the whole blob also set
+// Content-MD5. If shaTrailer, GET requests for the whole blob are sent
+// chunked, followed by the blob's id, computed as it is sent, in the
+// X-Git-Sha trailer. Blobs with a registered BlobTransformer are served
+// transformed, by serveTransformed.
+type blobs struct {
+	fs webdav.FileSystem
+	http.Handler
+	md5        *md5Sums
+	shaTrailer bool
+
+	// noListing leaves GET requests for directories to Handler, rather
+	// than listing them.
+	noListing bool
+
+	// browse lists directories for browsers with the pages of the
+	// browsing UI, and serves a preview of each file requested with the
+	// preview query parameter. GET and HEAD requests for directories
+	// without a trailing slash are redirected to the URL with one.
+	browse bool
+
+	// symlinkRedirect redirects GET and HEAD requests for symlinks to
+	// the files they refer to, see redirectSymlink, rather than serving
+	// the target as the content.
+	symlinkRedirect bool
+
+	// Prefix is removed from the URL path to form the file name, as by
+	// webdav.Handler.
+	Prefix string
+
+	// Logger is called for each request served, as by webdav.Handler.
+	Logger func(*http.Request, error)
+}
+
+func (h *blobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		f, err := h.fs.OpenFile(strings.TrimPrefix(r.URL.Path, h.Prefix), os.O_RDONLY, 0)
+		if os.IsNotExist(err) && r.Header.Get("If-Match") != "" {
+			// If-Match fails when there is no current representation,
+			// as when the file was removed by a change of commit.
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if git.IsTransient(err) {
+			// the WebDAV handler would reply 404 Not Found, though the
+			// file may be read if the request is retried.
+			httpError(w, err)
+			if h.Logger != nil {
+				h.Logger(r, err)
+			}
+			return
+		}
+		if err == nil {
+			defer f.Close()
+			fi, err := f.Stat()
+			if err == nil && fi.IsDir() && h.browse && !strings.HasSuffix(r.URL.Path, "/") {
+				// as file servers do, so relative links in the listing
+				// resolve within the directory. PROPFIND and the other
+				// WebDAV methods are not redirected.
+				u := *r.URL
+				u.Path += "/"
+				http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+				if h.Logger != nil {
+					h.Logger(r, nil)
+				}
+				return
+			}
+			if err == nil && fi.IsDir() && !h.noListing {
+				kind := preferred(r.Header.Get("Accept"), "text/html", "application/json")
+				if kind != "" {
+					name := strings.TrimPrefix(r.URL.Path, h.Prefix)
+					var err error
+					if h.browse && kind == "text/html" {
+						err = serveBrowseListing(w, r, name, f)
+					} else {
+						err = serveListing(w, r, name, f, kind == "application/json")
+					}
+					if err != nil {
+						httpError(w, err)
+					}
+					if h.Logger != nil {
+						h.Logger(r, err)
+					}
+					return
+				}
+			}
+			if _, preview := r.URL.Query()["preview"]; err == nil && !fi.IsDir() && h.browse && preview {
+				err := servePreview(w, r, strings.TrimPrefix(r.URL.Path, h.Prefix), f, fi)
+				if err != nil {
+					httpError(w, err)
+				}
+				if h.Logger != nil {
+					h.Logger(r, err)
+				}
+				return
+			}
+			if b, ok := f.(*blob); ok && h.symlinkRedirect && b.mode&os.ModeSymlink != 0 {
+				err := redirectSymlink(w, r, h.fs, h.Prefix, strings.TrimPrefix(r.URL.Path, h.Prefix), b)
+				if h.Logger != nil {
+					h.Logger(r, err)
+				}
+				return
+			}
+			if b, ok := f.(*blob); ok {
+				if r.Method == "HEAD" {
+					// HEAD reads at most enough of the blob to detect
+					// its type, so the whole blob is not read into the
+					// cache.
+					b.cache = nil
+				}
+				w := throttle(w, r)
+				etag, _ := b.ETag(r.Context())
+				if im := r.Header.Get("If-Match"); im != "" && !matchETag(im, etag) {
+					// checked before Content-MD5 is calculated, though
+					// ServeContent would also check it.
+					http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+					return
+				}
+				name := strings.TrimPrefix(r.URL.Path, h.Prefix)
+				if t := transformerFor(name); t != nil {
+					err := serveTransformed(w, r, t, name, b)
+					if h.Logger != nil {
+						h.Logger(r, err)
+					}
+					return
+				}
+				w.Header().Set("ETag", etag)
+				if h.md5 != nil && r.Method == "GET" && r.Header.Get("Range") == "" {
+					sum, err := h.md5.sum(b)
+					if err != nil {
+						httpError(w, err)
+						return
+					}
+					w.Header().Set("Content-MD5", sum)
+				}
+				if h.shaTrailer && r.Method == "GET" {
+					t := newSHATrailer(w, b.Size)
+					http.ServeContent(t, r, b.name, b.modTime, b)
+					t.finish(b.ID())
+				} else {
+					http.ServeContent(w, r, b.name, b.modTime, b)
+				}
+				if h.Logger != nil {
+					h.Logger(r, nil)
+				}
+				return
+			}
+		}
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// matchETag reports whether the If-Match header value im matches etag,
+// using the strong comparison If-Match requires. im is "*", or a comma
+// separated list of entity tags.
+func matchETag(im, etag string) bool {
+	for _, tag := range strings.Split(im, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
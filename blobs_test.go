@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// serve returns the response of h to a request with method for path,
+// with the given header fields, name then value.
+func serve(h http.Handler, method, path string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIfMatch(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "t0")
+	h := filesHandler(s, blobs{})
+
+	etag := func(path string) string {
+		w := serve(h, "GET", path)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+			t.Fatalf("GET %s: got %d, ETag %q", path, w.Code, w.Header().Get("ETag"))
+		}
+		return w.Header().Get("ETag")
+	}
+	before, empty := etag("/a.txt"), etag("/empty")
+
+	// a live ref moves on, changing a.txt but not empty.
+	next := serveRev(t, repo, "t5")
+	s.set(next.get())
+	after := etag("/a.txt")
+	if after == before {
+		t.Fatalf("ETag of /a.txt unchanged, %s, by a change of its content", after)
+	}
+
+	tests := []struct {
+		path, ifMatch string
+		want          int
+	}{
+		{"/a.txt", before, http.StatusPreconditionFailed},
+		{"/a.txt", after, http.StatusOK},
+		{"/a.txt", before + ", " + after, http.StatusOK},
+		{"/a.txt", "*", http.StatusOK},
+		{"/a.txt", "W/" + after, http.StatusPreconditionFailed},
+		{"/empty", empty, http.StatusOK},
+		{"/missing", "*", http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{"GET", "HEAD"} {
+			if w := serve(h, method, tt.path, "If-Match", tt.ifMatch); w.Code != tt.want {
+				t.Errorf("%s %s, If-Match: %s: got %d, want %d", method, tt.path, tt.ifMatch, w.Code, tt.want)
+			}
+		}
+	}
+}
+
+func TestBrowseRedirect(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+
+	// browsers ask for HTML, WebDAV clients for anything.
+	const browser, client = "text/html", "*/*"
+	tests := []struct {
+		browse       bool
+		method, path string
+		accept       string
+		want         int
+		location     string
+	}{
+		{true, "GET", "/d", browser, http.StatusMovedPermanently, "/d/"},
+		{true, "HEAD", "/d", browser, http.StatusMovedPermanently, "/d/"},
+		{true, "GET", "/d?x=1", browser, http.StatusMovedPermanently, "/d/?x=1"},
+		{true, "GET", "/d/e%20f", browser, http.StatusMovedPermanently, "/d/e%20f/"},
+		{true, "GET", "/d", client, http.StatusMovedPermanently, "/d/"},
+		{true, "GET", "/d/", browser, http.StatusOK, ""},
+		{true, "GET", "/a.txt", browser, http.StatusOK, ""},
+		{true, "PROPFIND", "/d", client, http.StatusMultiStatus, ""},
+		{false, "PROPFIND", "/d", client, http.StatusMultiStatus, ""},
+		{false, "GET", "/d", client, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		h := filesHandler(s, blobs{browse: tt.browse})
+		w := serve(h, tt.method, tt.path, "Accept", tt.accept, "Depth", "1")
+		if w.Code != tt.want || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s, Accept: %s, browse %v: got %d, Location %q, want %d, %q", tt.method, tt.path, tt.accept, tt.browse, w.Code, w.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+}
+
+// TestHead checks HEAD replies with the headers GET does, and no body.
+func TestHead(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	h := filesHandler(s, blobs{})
+
+	for _, path := range []string{"/a.txt", "/empty", "/d/big.txt", "/link", "/d/e%20f/x%FF%20y"} {
+		get, head := serve(h, "GET", path), serve(h, "HEAD", path)
+		if get.Code != http.StatusOK || head.Code != http.StatusOK {
+			t.Errorf("%s: GET %d, HEAD %d, want 200", path, get.Code, head.Code)
+			continue
+		}
+		if n := get.Header().Get("Content-Length"); n != strconv.Itoa(get.Body.Len()) {
+			t.Errorf("GET %s: Content-Length %s, read %d bytes", path, n, get.Body.Len())
+		}
+		for _, key := range []string{"Content-Length", "Content-Type", "ETag", "Last-Modified"} {
+			if g, h := get.Header().Get(key), head.Header().Get(key); g == "" || g != h {
+				t.Errorf("%s: %s: GET %q, HEAD %q", path, key, g, h)
+			}
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD %s: got a body of %d bytes, want none", path, head.Body.Len())
+		}
+	}
+}
+
+// TestMultiRange checks a GET of two ranges of a file is answered with a
+// multipart/byteranges body holding each, whether the second range is
+// after the first, or before it, when the blob is read again.
+func TestMultiRange(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	h := filesHandler(serveRev(t, repo, "master"), blobs{})
+	content, err := gittest.Git(testRepo, "cat-file", "blob", "master:d/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ranges := range [][2][2]int{
+		{{0, 99}, {150000, 150099}},
+		{{150000, 150099}, {10, 19}},
+	} {
+		spec := fmt.Sprintf("bytes=%d-%d,%d-%d", ranges[0][0], ranges[0][1], ranges[1][0], ranges[1][1])
+		w := serve(h, "GET", "/d/big.txt", "Range", spec)
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("Range %s: got %d", spec, w.Code)
+		}
+		typ, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err != nil || typ != "multipart/byteranges" {
+			t.Fatalf("Range %s: got Content-Type %q", spec, w.Header().Get("Content-Type"))
+		}
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		for _, r := range ranges {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("Range %s: %v", spec, err)
+			}
+			want := fmt.Sprintf("bytes %d-%d/%d", r[0], r[1], len(content))
+			if got := part.Header.Get("Content-Range"); got != want {
+				t.Errorf("Range %s: got Content-Range %q, want %q", spec, got, want)
+			}
+			got, err := ioutil.ReadAll(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content[r[0]:r[1]+1] {
+				t.Errorf("Range %s: part %q, want %q", spec, got, content[r[0]:r[1]+1])
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Errorf("Range %s: got %v after two parts, want io.EOF", spec, err)
+		}
+	}
+}
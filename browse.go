@@ -0,0 +1,179 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/webdav"
+)
+
+// maxPreviewSize is the largest file shown inline by the preview page.
+const maxPreviewSize = 256 << 10
+
+// browseStyle is shared by the pages of the browsing UI.
+const browseStyle = `<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+nav { font-size: 1.2em; margin-bottom: 1em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em 0.2em 0; text-align: left; }
+td.num { text-align: right; }
+.mode, pre { font-family: monospace; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; }
+</style>`
+
+// browseTemplates are the pages of the browsing UI: the listing of a
+// directory, and the preview of a file.
+var browseTemplates = template.Must(template.New("crumbs").Parse(`<nav>{{range $i, $c := .Crumbs}}{{if gt $i 1}}/{{end}}<a href="{{$c.URL}}">{{$c.Name}}</a>{{end}}</nav>`))
+
+func init() {
+	template.Must(browseTemplates.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title>
+` + browseStyle + `</head>
+<body>
+{{template "crumbs" .}}
+<table>
+<thead><tr><th>Name</th><th>Mode</th><th>Size</th><th>Modified</th><th></th></tr></thead>
+<tbody>
+{{if ne .Path "/"}}<tr><td><a href="..">..</a></td><td></td><td></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}{{if not .Dir}}?preview{{end}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td class="mode">{{.Mode}}</td><td class="num">{{if not .Dir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td><td>{{if not .Dir}}<a href="{{.URL}}">raw</a>{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+	template.Must(browseTemplates.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title>
+` + browseStyle + `</head>
+<body>
+{{template "crumbs" .}}
+<p><span class="mode">{{.Mode}}</span> {{.Size}} bytes, modified {{.ModTime.UTC.Format "2006-01-02 15:04:05"}} &middot; <a href="{{.Raw}}">raw</a></p>
+{{if .Text}}<pre>{{.Text}}</pre>
+{{else}}<p>{{.Reason}}</p>
+{{end}}</body>
+</html>
+`))
+}
+
+// crumb is an element of the path of a page, linking to its directory.
+type crumb struct {
+	Name string
+	URL  string
+}
+
+// crumbs returns the elements of the path name, the first being the
+// root, each linked relative to the page at name. If dir is set, the
+// page is a directory listing, whose URL ends in a slash.
+func crumbs(name string, dir bool) []crumb {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	var elems []string
+	if name != "" {
+		elems = strings.Split(name, "/")
+	}
+	// the relative URL of the directory holding the page.
+	up := len(elems)
+	if !dir {
+		up--
+	}
+	cs := []crumb{{Name: "/", URL: strings.Repeat("../", up)}}
+	for i, e := range elems {
+		var u string
+		if i < up {
+			u = strings.Repeat("../", up-i-1)
+		} else {
+			// the file previewed.
+			u = (&url.URL{Path: "./" + e}).String() + "?preview"
+		}
+		cs = append(cs, crumb{Name: e, URL: u})
+	}
+	for i := range cs {
+		if cs[i].URL == "" {
+			cs[i].URL = "./"
+		}
+	}
+	return cs
+}
+
+// serveBrowseListing serves the entries of the directory f, requested at
+// r, as a page of the browsing UI, linking files to their previews. The
+// URL of r must end in a slash, see blobs.
+func serveBrowseListing(w http.ResponseWriter, r *http.Request, name string, f webdav.File) error {
+	w.Header().Set("Vary", "Accept")
+	entries, err := listingEntries(f)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return nil
+	}
+	return browseTemplates.ExecuteTemplate(w, "listing", &struct {
+		Path    string
+		Crumbs  []crumb
+		Entries []listingEntry
+	}{
+		Path:    path.Clean("/" + name),
+		Crumbs:  crumbs(name, true),
+		Entries: entries,
+	})
+}
+
+// servePreview serves a page of the browsing UI describing the file f,
+// whose path is name, showing its content if it is text of at most
+// maxPreviewSize bytes, and linking to the file itself.
+func servePreview(w http.ResponseWriter, r *http.Request, name string, f webdav.File, fi os.FileInfo) error {
+	page := struct {
+		Path    string
+		Crumbs  []crumb
+		Mode    os.FileMode
+		Size    int64
+		ModTime time.Time
+		Raw     string
+		Text    string
+		Reason  string
+	}{
+		Path:    path.Clean("/" + name),
+		Crumbs:  crumbs(name, false),
+		Mode:    fi.Mode(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Raw:     (&url.URL{Path: "./" + path.Base(name)}).String(),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return nil
+	}
+	switch {
+	case fi.Size() == 0:
+		page.Reason = "The file is empty."
+	case fi.Size() > maxPreviewSize:
+		page.Reason = "The file is too large to preview."
+	default:
+		buf, err := ioutil.ReadAll(io.LimitReader(f, maxPreviewSize))
+		if err != nil {
+			return err
+		}
+		if isText(buf) {
+			page.Text = string(buf)
+		} else {
+			page.Reason = "The file is not text."
+		}
+	}
+	return browseTemplates.ExecuteTemplate(w, "preview", &page)
+}
+
+// isText reports whether buf appears to be text: valid UTF-8 which
+// content sniffing finds to be text, including HTML and XML, which are
+// shown as source.
+func isText(buf []byte) bool {
+	return utf8.Valid(buf) && strings.HasPrefix(http.DetectContentType(buf), "text/")
+}
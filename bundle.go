@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// bundleMain implements gitdav bundle, which writes a version 2 git
+// bundle holding a commit, and every object reachable from it, to a file.
+// The bundle has a single ref: the ref named by -c, or HEAD if -c is an
+// object id. It may be cloned from, or fetched, like a repository.
+func bundleMain(args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	c := fs.String("c", "", "commit, or ref, to bundle")
+	out := fs.String("o", "", "write the bundle to this file, or - for stdout")
+	strict := fs.Bool("strict", false, "require the path to be the root of the repository, rather than searching the directories above it")
+	fs.Parse(args)
+	if len(fs.Args()) != 1 || *c == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "usage: gitdav bundle -c <commit> -o <file> <repository>")
+		os.Exit(2)
+	}
+	repo, err := openRepository(fs.Args()[0], *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+	sha, err := repo.ResolveRev(*c)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	ref, ok := repo.ResolveRef(*c)
+	if !ok {
+		ref = "HEAD"
+	}
+
+	if *out == "-" {
+		bw := bufio.NewWriter(os.Stdout)
+		if err := writeBundle(bw, repo, ref, sha); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if err := bw.Flush(); err != nil {
+			log.Fatalf("%+v", errors.WithStack(err))
+		}
+		return
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("%+v", errors.WithStack(err))
+	}
+	bw := bufio.NewWriter(f)
+	err = writeBundle(bw, repo, ref, sha)
+	if err == nil {
+		err = errors.WithStack(bw.Flush())
+	}
+	if cerr := f.Close(); err == nil {
+		err = errors.WithStack(cerr)
+	}
+	if err != nil {
+		// do not leave a truncated bundle behind.
+		os.Remove(*out)
+		log.Fatalf("%+v", err)
+	}
+}
+
+// writeBundle writes a version 2 git bundle to w holding the ref, which
+// points to sha, and every object reachable from sha.
+func writeBundle(w io.Writer, repo *git.Repository, ref, sha string) error {
+	ids, err := repo.Reachable([]string{sha}, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "# v2 git bundle\n%s %s\n\n", sha, ref); err != nil {
+		return errors.WithStack(err)
+	}
+	return repo.WritePack(w, ids)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// blobCache is an on disk cache of inflated blob contents, stored in dir
+// in files named for the blob's id. As a blob's id is derived from its
+// content, entries never become stale. Once the cache holds more than
+// maxSize bytes, the least recently used entries are removed. If maxSize
+// is zero the cache is unbounded.
+type blobCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List               // of *cacheEntry, most recently used first
+	entries map[string]*list.Element // blob id to element of lru
+	size    int64
+}
+
+type cacheEntry struct {
+	id   string
+	size int64
+}
+
+// openBlobCache returns a blobCache storing blobs in dir, creating dir if
+// necessary. Blobs already in dir are added to the cache.
+func openBlobCache(dir string, maxSize int64) (*blobCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// files are touched when used, so the oldest were least recently used.
+	sort.Slice(fis, func(i, j int) bool { return fis[i].ModTime().After(fis[j].ModTime()) })
+	c := blobCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	for _, fi := range fis {
+		switch {
+		case strings.HasSuffix(fi.Name(), ".tmp"):
+			// left by an earlier process that did not finish
+			// writing the blob.
+			os.Remove(filepath.Join(dir, fi.Name()))
+		case fi.Mode().IsRegular():
+			c.entries[fi.Name()] = c.lru.PushBack(&cacheEntry{id: fi.Name(), size: fi.Size()})
+			c.size += fi.Size()
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+	return &c, nil
+}
+
+// open returns the cache file holding the contents of b. If b is not
+// cached its contents are read, from the start of b, into the cache.
+func (c *blobCache) open(b *git.Blob) (*os.File, error) {
+	path := filepath.Join(c.dir, b.ID())
+	c.mu.Lock()
+	e, ok := c.entries[b.ID()]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if ok {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		f, err := os.Open(path)
+		if err == nil {
+			return f, nil
+		}
+		// the file has been removed from under us, fetch it again.
+		c.remove(b.ID())
+	}
+
+	if _, err := b.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(c.dir, b.ID()+".*.tmp")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n, err := io.Copy(tmp, b)
+	if err == nil && n != b.Size {
+		err = errors.Errorf("blob %s: read %d bytes, expected %d", b.ID(), n, b.Size)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, errors.WithStack(err)
+	}
+
+	c.mu.Lock()
+	if _, ok := c.entries[b.ID()]; !ok {
+		c.entries[b.ID()] = c.lru.PushFront(&cacheEntry{id: b.ID(), size: n})
+		c.size += n
+		c.evict()
+	}
+	c.mu.Unlock()
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, errors.WithStack(err)
+	}
+	return tmp, nil
+}
+
+// remove removes the entry for the blob id from the cache.
+func (c *blobCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok {
+		c.size -= e.Value.(*cacheEntry).size
+		c.lru.Remove(e)
+		delete(c.entries, id)
+	}
+}
+
+// evict removes the least recently used entries until the cache is no
+// larger than maxSize. Open cache files remain readable after removal.
+// c.mu must be held.
+func (c *blobCache) evict() {
+	for c.maxSize > 0 && c.size > c.maxSize && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		ce := e.Value.(*cacheEntry)
+		if err := os.Remove(filepath.Join(c.dir, ce.id)); err != nil && !os.IsNotExist(err) {
+			log.Printf("%+v", errors.WithStack(err))
+		}
+		c.size -= ce.size
+		c.lru.Remove(e)
+		delete(c.entries, ce.id)
+	}
+}
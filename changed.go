@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// changed serves the paths of the files which differ between two
+// revisions, named by the base and head query parameters, as found by
+// git.Repository.ChangedFiles. head defaults to the commit being served,
+// if there is one.
+type changed struct {
+	repo   *git.Repository
+	served *served     // may be nil
+	filter *pathFilter // if not nil, paths it does not allow are omitted
+}
+
+func (c *changed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	base, head := r.URL.Query().Get("base"), r.URL.Query().Get("head")
+	if head == "" && c.served != nil {
+		if commit, root := c.served.get(); commit != nil {
+			head = commit.String()
+		} else {
+			head = root.ID()
+		}
+	}
+	if base == "" || head == "" {
+		http.Error(w, "base and head are required", http.StatusBadRequest)
+		return
+	}
+	for _, rev := range []string{base, head} {
+		if sha, err := c.repo.ResolveRev(rev); err != nil || !c.repo.Exists(sha) {
+			http.Error(w, "unknown commit", http.StatusNotFound)
+			return
+		}
+	}
+	paths, err := c.repo.ChangedFiles(base, head)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	resp := struct {
+		Base  string   `json:"base"`
+		Head  string   `json:"head"`
+		Paths []string `json:"paths"`
+	}{
+		Base:  base,
+		Head:  head,
+		Paths: make([]string, 0, len(paths)),
+	}
+	for _, p := range paths {
+		if c.filter.allowed(p, false) {
+			resp.Paths = append(resp.Paths, p)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// changes serves the files changed by a commit relative to its first parent.
+type changes struct {
+	served *served
+	filter *pathFilter // if not nil, paths it does not allow are omitted
+}
+
+func (c *changes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	commit, _ := c.served.get()
+	if commit == nil {
+		http.Error(w, "serving a tree, not a commit", http.StatusNotFound)
+		return
+	}
+	diff, err := commit.Diff()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	type change struct {
+		Path   string `json:"path"`
+		Action string `json:"action"`
+		From   string `json:"from,omitempty"`
+		To     string `json:"to,omitempty"`
+	}
+	filter := c.filter
+	resp := make([]change, 0, len(diff))
+	for i := range diff {
+		c := &diff[i]
+		if !filter.allowed(c.Path, false) {
+			continue
+		}
+		resp = append(resp, change{
+			Path:   c.Path,
+			Action: c.Action(),
+			From:   c.From,
+			To:     c.To,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// check reports to w what would be served from repo, the commit or tree
+// in current, or with allRefs, the number of branches and tags. By the
+// time check is called the commit, and its root tree, have been read;
+// check returns an error if the refs cannot be read.
+func check(w io.Writer, repo *git.Repository, current *served, allRefs bool) error {
+	fmt.Fprintln(w, "repository", repo.Root)
+	switch {
+	case current != nil:
+		commit, tree := current.get()
+		if commit == nil {
+			fmt.Fprintln(w, "tree", tree.ID())
+			break
+		}
+		fmt.Fprintln(w, "commit", commit, commit.Summary())
+		fmt.Fprintln(w, "tree", tree.ID())
+	case allRefs:
+		branches, err := repo.Branches()
+		if err != nil {
+			return err
+		}
+		tags, err := repo.Tags()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, len(branches), "branches,", len(tags), "tags")
+	default:
+		fmt.Fprintln(w, "worktree", repo.Root)
+	}
+	return nil
+}
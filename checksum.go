@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// checksums serves the checksums of the object at the requested path.
+//
+// The sha1 field is the git object id, which is the SHA1 of the object
+// header, "blob <size>\x00", followed by the content; it is not the SHA1
+// of the content alone. The sha256 field, returned for blobs when the
+// sha256 query parameter is set, covers the content only, and is
+// calculated on first request then cached by sha256.
+type checksums struct {
+	served *served
+	filter *pathFilter // if not nil, paths it does not allow are not found
+	sha256 *sha256Sums
+}
+
+func (c *checksums) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, root := c.served.get()
+	p := strings.TrimPrefix(r.URL.Path, "/checksums")
+	resp := struct {
+		Path   string `json:"path"`
+		SHA1   string `json:"sha1"`
+		SHA256 string `json:"sha256,omitempty"`
+	}{
+		Path: p,
+		SHA1: root.ID(),
+	}
+	if strings.Trim(p, "/") != "" {
+		e, err := root.Lookup(p)
+		if err == nil && !c.filter.allowed(p, e.Mode.IsDir()) {
+			err = notExist(p)
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		resp.SHA1 = e.ID()
+		if r.URL.Query().Get("sha256") != "" && !e.Mode.IsDir() {
+			sum, err := c.sha256.sum(e)
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+			resp.SHA256 = sum
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// httpError replies to the request with a status appropriate for err.
+// Transient I/O errors, which may succeed if retried, are reported as
+// 503 Service Unavailable rather than 404 Not Found.
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case git.IsNotExist(err):
+		http.Error(w, "not found", http.StatusNotFound)
+	case git.IsTransient(err):
+		log.Printf("%+v", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	default:
+		log.Printf("%+v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// unavailable wraps a handler serving the contents of the served tree,
+// replying 503 Service Unavailable to GET and HEAD requests for paths that
+// cannot be read because of a transient I/O error. The WebDAV handler would
+// otherwise report them as 404 Not Found. Paths that do not exist are
+// replied to with 404 Not Found directly, without the wrapped handler
+// opening them again, unless the request has an If-Match header, which
+// the wrapped handler answers with 412 Precondition Failed.
+type unavailable struct {
+	served *served
+	prefix string // removed from the URL path to form the path in the tree
+	http.Handler
+
+	// Logger is called for requests replied to directly, as by
+	// webdav.Handler.
+	Logger func(*http.Request, error)
+}
+
+func (u *unavailable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, u.prefix)
+	if (r.Method == "GET" || r.Method == "HEAD") && strings.Trim(p, "/") != "" {
+		_, root := u.served.get()
+		ok, _, err := root.Exists(p)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		if !ok && r.Header.Get("If-Match") == "" {
+			err := notExist(p)
+			httpError(w, err)
+			if u.Logger != nil {
+				u.Logger(r, err)
+			}
+			return
+		}
+	}
+	u.Handler.ServeHTTP(w, r)
+}
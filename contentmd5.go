@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"sync"
+)
+
+// md5Sums holds the MD5 of the content of blobs, for the Content-MD5
+// header expected by some older WebDAV clients. The MD5 is calculated on
+// first request then cached by blob id for the life of the process, as a
+// blob's content never changes. It detects accidental corruption only;
+// MD5 offers no protection against deliberate tampering.
+type md5Sums struct {
+	mu   sync.Mutex
+	sums map[string]string // blob id to base64 encoded MD5
+}
+
+// sum returns the base64 encoded MD5 of the content of b, reading b in
+// full if it is not cached, then seeking back to its start.
+func (m *md5Sums) sum(b *blob) (string, error) {
+	m.mu.Lock()
+	sum, ok := m.sums[b.ID()]
+	m.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	h := md5.New()
+	if _, err := io.Copy(h, b); err != nil {
+		return "", err
+	}
+	if _, err := b.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	sum = base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sums == nil {
+		m.sums = make(map[string]string)
+	}
+	m.sums[b.ID()] = sum
+	return sum, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// defaultDaemonAddr is the default address of gitdav daemon, the port
+// registered for the git protocol.
+const defaultDaemonAddr = ":9418"
+
+// requestTimeout bounds the time a git client has to send its request,
+// and the wants and haves which follow, so idle connections are not
+// held open. Only reads are bounded, not the time taken to send the
+// pack.
+const requestTimeout = time.Minute
+
+// daemonMain implements gitdav daemon, which serves a commit, and every
+// object reachable from it, read only over the anonymous git protocol,
+// git://, so it may be cloned or fetched. The repository path in the
+// URL is ignored; every URL serves the commit named by -c.
+func daemonMain(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	c := fs.String("c", "", "commit, or ref, to serve")
+	addr := fs.String("listen", defaultDaemonAddr, "git protocol service address")
+	strict := fs.Bool("strict", false, "require the path to be the root of the repository, rather than searching the directories above it")
+	fs.Parse(args)
+	if len(fs.Args()) != 1 || *c == "" {
+		fmt.Fprintln(os.Stderr, "usage: gitdav daemon -c <commit> [-listen <addr>] <repository>")
+		os.Exit(2)
+	}
+	repo, err := openRepository(fs.Args()[0], *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+	sha, err := repo.ResolveRev(*c)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	refs := servedRefs(repo, *c, sha)
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving %s at commit %s on git://%s/", fs.Args()[0], sha, l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			log.Fatal(err)
+		}
+		go func() {
+			defer conn.Close()
+			n, err := serveGit(conn, repo, refs)
+			if err != nil {
+				log.Printf("%v: %+v", conn.RemoteAddr(), err)
+				return
+			}
+			if n > 0 {
+				log.Printf("%v: sent %d objects", conn.RemoteAddr(), n)
+			}
+		}()
+	}
+}
+
+// serveGit serves a git protocol request read from conn, returning the
+// number of objects sent. Only git-upload-pack, fetching, is supported.
+func serveGit(conn net.Conn, repo *git.Repository, refs []advertisedRef) (int, error) {
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	// the request is "<service> <path>\x00host=<host>\x00", possibly
+	// followed by extra parameters, as version=2, which are ignored; the
+	// client falls back to version 0 of the protocol.
+	req, _, err := readPktLine(r)
+	if err != nil {
+		return 0, err
+	}
+	if i := strings.IndexByte(req, 0); i >= 0 {
+		req = req[:i]
+	}
+	service := strings.Fields(req)
+	if len(service) == 0 || service[0] != "git-upload-pack" {
+		writePktLine(conn, "ERR service not enabled: "+req)
+		return 0, errors.Errorf("unsupported request %q", req)
+	}
+
+	if err := advertiseRefs(w, refs); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	n, err := uploadPack(r, w, repo, refs, false)
+	if err != nil {
+		w.Flush()
+		return 0, err
+	}
+	return n, errors.WithStack(w.Flush())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// description serves the description of the repository, as shown by
+// gitweb, as text. A repository without one, or with the placeholder
+// written by git init, has an empty description.
+type description struct {
+	repo *git.Repository
+}
+
+func (d *description) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	desc, err := d.repo.Description()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if desc != "" {
+		desc += "\n"
+	}
+	w.Write([]byte(desc))
+}
+
+// metadata serves a description of what is being served, for listings:
+// the repository's description and object format, and the ref, commit,
+// and tree served, if a single commit or tree is served.
+type metadata struct {
+	repo   *git.Repository
+	served *served // may be nil
+	ref    string  // the ref named by -c, if any
+}
+
+func (m *metadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	desc, err := m.repo.Description()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	format, err := m.repo.ObjectFormat()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	resp := struct {
+		Description  string `json:"description"`
+		ObjectFormat string `json:"object_format"`
+		Ref          string `json:"ref,omitempty"`
+		Commit       string `json:"commit,omitempty"`
+		Tree         string `json:"tree,omitempty"`
+	}{
+		Description:  desc,
+		ObjectFormat: format,
+		Ref:          m.ref,
+	}
+	if m.served != nil {
+		commit, root := m.served.get()
+		resp.Commit = commitID(commit)
+		resp.Tree = root.ID()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
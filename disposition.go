@@ -0,0 +1,48 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// attachments wraps a handler, adding a Content-Disposition header to GET
+// and HEAD requests for files whose names end in one of exts, so browsers
+// download them rather than display them. Other files are served inline.
+type attachments struct {
+	exts []string
+	http.Handler
+}
+
+// parseExts returns the comma separated list of extensions in s. A leading
+// dot is added to extensions without one.
+func parseExts(s string) []string {
+	var exts []string
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+func (a *attachments) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		name := path.Base(r.URL.Path)
+		for _, ext := range a.exts {
+			// extensions may have more than one part, like .tar.gz, so
+			// path.Ext is not sufficient.
+			if strings.HasSuffix(name, ext) {
+				w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+				break
+			}
+		}
+	}
+	a.Handler.ServeHTTP(w, r)
+}
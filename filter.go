@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pathFilter restricts the paths served to those allowed by a list of
+// glob patterns, and not denied by another. Patterns are matched against
+// the whole slash separated path, relative to the root of the tree, one
+// element at a time using path.Match; a ** element matches any number of
+// path elements, and a pattern ending in a slash matches only
+// directories. A path is denied if it, or any directory above it,
+// matches a deny pattern, so denying a directory denies its contents.
+// If there are allow patterns, a path is allowed only if it, or any
+// directory above it, matches one; directories leading to paths which
+// may be allowed are also shown, so they can be reached. Deny patterns
+// take precedence over allow patterns.
+//
+// A nil *pathFilter allows every path.
+type pathFilter struct {
+	allow, deny [][]string // patterns split into path elements
+}
+
+// newPathFilter returns a pathFilter for the comma separated patterns
+// in allow and deny, or nil if there are none.
+func newPathFilter(allow, deny string) (*pathFilter, error) {
+	var f pathFilter
+	var err error
+	if f.allow, err = parsePatterns(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parsePatterns(deny); err != nil {
+		return nil, err
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// parsePatterns returns the comma separated patterns in s, each split
+// into path elements. A pattern ending in a slash, which matches only
+// directories, ends with an empty element.
+func parsePatterns(s string) ([][]string, error) {
+	var patterns [][]string
+	for _, pattern := range strings.Split(s, ",") {
+		pattern = strings.TrimSpace(pattern)
+		dirOnly := strings.HasSuffix(pattern, "/")
+		pattern = strings.Trim(pattern, "/")
+		if pattern == "" {
+			continue
+		}
+		elems := strings.Split(pattern, "/")
+		for _, elem := range elems {
+			if _, err := path.Match(elem, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+			}
+		}
+		if dirOnly {
+			elems = append(elems, "")
+		}
+		patterns = append(patterns, elems)
+	}
+	return patterns, nil
+}
+
+// allowed reports whether the path p, a directory if isDir, may be
+// served.
+func (f *pathFilter) allowed(p string, isDir bool) bool {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if f == nil || p == "" {
+		return true
+	}
+	elems := strings.Split(p, "/")
+	allowed := len(f.allow) == 0
+	for i := 1; i <= len(elems); i++ {
+		dir := i < len(elems) || isDir
+		for _, pattern := range f.deny {
+			if matchPath(pattern, elems[:i], dir) {
+				return false
+			}
+		}
+		for _, pattern := range f.allow {
+			allowed = allowed || matchPath(pattern, elems[:i], dir)
+		}
+	}
+	if allowed || !isDir {
+		return allowed
+	}
+	for _, pattern := range f.allow {
+		if matchPrefix(pattern, elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath reports whether the path elements in elems, of a directory if
+// isDir, match pattern. A pattern ending in an empty element matches only
+// directories.
+func matchPath(pattern, elems []string, isDir bool) bool {
+	if n := len(pattern); n > 0 && pattern[n-1] == "" {
+		return isDir && matchElems(pattern[:n-1], elems)
+	}
+	return matchElems(pattern, elems)
+}
+
+// matchElems reports whether the path elements in elems match pattern.
+func matchElems(pattern, elems []string) bool {
+	switch {
+	case len(pattern) == 0:
+		return len(elems) == 0
+	case pattern[0] == "**":
+		return matchElems(pattern[1:], elems) || (len(elems) > 0 && matchElems(pattern, elems[1:]))
+	case len(elems) == 0:
+		return false
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && matchElems(pattern[1:], elems[1:])
+}
+
+// matchPrefix reports whether a path below the directory whose path
+// elements are elems may match pattern.
+func matchPrefix(pattern, elems []string) bool {
+	switch {
+	case len(elems) == 0:
+		return len(pattern) > 0
+	case len(pattern) == 0:
+		return false
+	case pattern[0] == "**":
+		return true
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && matchPrefix(pattern[1:], elems[1:])
+}
@@ -0,0 +1,163 @@
+package main
+
+import "testing"
+
+func TestPathFilter(t *testing.T) {
+	type check struct {
+		path  string
+		isDir bool
+		want  bool
+	}
+	tests := []struct {
+		name, allow, deny string
+		checks            []check
+	}{{
+		name: "none",
+		checks: []check{
+			{"a", false, true},
+			{"a/b", true, true},
+		},
+	}, {
+		name:  "allow",
+		allow: "docs",
+		checks: []check{
+			{"docs", true, true},
+			{"docs/a.md", false, true},
+			{"docs/sub/b.md", false, true},
+			{"src", true, false},
+			{"README", false, false},
+		},
+	}, {
+		name: "deny",
+		deny: "secrets",
+		checks: []check{
+			{"secrets", true, false},
+			{"secrets/key", false, false},
+			{"secrets2", true, true},
+			{"a/secrets", true, true},
+		},
+	}, {
+		name:  "deny within allow",
+		allow: "docs",
+		deny:  "docs/private",
+		checks: []check{
+			{"docs", true, true},
+			{"docs/a.md", false, true},
+			{"docs/private", true, false},
+			{"docs/private/b.md", false, false},
+		},
+	}, {
+		name:  "deny overlaps allow",
+		allow: "docs/*.md",
+		deny:  "docs/secret.md",
+		checks: []check{
+			{"docs", true, true},
+			{"docs/a.md", false, true},
+			{"docs/secret.md", false, false},
+			{"docs/a.txt", false, false},
+		},
+	}, {
+		name:  "deny takes precedence",
+		allow: "docs",
+		deny:  "docs",
+		checks: []check{
+			{"docs", true, false},
+			{"docs/a.md", false, false},
+		},
+	}, {
+		name:  "leading directories",
+		allow: "a/b/c",
+		checks: []check{
+			{"a", true, true},
+			{"a/b", true, true},
+			{"a/b/c", false, true},
+			{"a/x", true, false},
+			{"a/b/x", false, false},
+			{"a", false, false},
+		},
+	}, {
+		name: "double star",
+		deny: "**/*.key",
+		checks: []check{
+			{"a.key", false, false},
+			{"a/b/c.key", false, false},
+			{"a/b", true, true},
+			{"a/b/c.txt", false, true},
+		},
+	}, {
+		name:  "double star within",
+		allow: "src/**/test",
+		checks: []check{
+			{"src", true, true},
+			{"src/test", true, true},
+			{"src/a/b/test", true, true},
+			{"src/a/b/test/x.go", false, true},
+			{"src/a/b", true, true},
+			{"src/a/b/x.go", false, false},
+			{"other", true, false},
+		},
+	}, {
+		name:  "double star overlaps deny",
+		allow: "**/*.go",
+		deny:  "vendor",
+		checks: []check{
+			{"main.go", false, true},
+			{"a/b.go", false, true},
+			{"vendor", true, false},
+			{"vendor/c.go", false, false},
+			{"a/b.txt", false, false},
+		},
+	}, {
+		name: "directory only",
+		deny: "build/",
+		checks: []check{
+			{"build", true, false},
+			{"build/out", false, false},
+			{"build", false, true},
+			{"a/build", true, true},
+		},
+	}, {
+		name: "directory only double star",
+		deny: "**/tmp/",
+		checks: []check{
+			{"tmp", true, false},
+			{"a/b/tmp", true, false},
+			{"a/b/tmp/x", false, false},
+			{"a/b/tmp", false, true},
+		},
+	}, {
+		name:  "allow directory only",
+		allow: "docs/",
+		checks: []check{
+			{"docs", true, true},
+			{"docs/a.md", false, true},
+			{"docs", false, false},
+		},
+	}, {
+		name:  "clean",
+		allow: "/a/,b",
+		deny:  " a/x ",
+		checks: []check{
+			{"/a/", true, true},
+			{"a/../b", false, true},
+			{"a/x", false, false},
+			{"", true, true},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newPathFilter(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range tt.checks {
+				if got := f.allowed(c.path, c.isDir); got != c.want {
+					t.Errorf("allowed(%q, %v): got %v, want %v", c.path, c.isDir, got, c.want)
+				}
+			}
+		})
+	}
+	if _, err := newPathFilter("[", ""); err == nil {
+		t.Errorf("newPathFilter(%q): got nil, want error", "[")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// maxFind is the most paths a /find request examines.
+const maxFind = 10000
+
+// find serves the paths in the served tree matching the glob pattern in
+// the pattern query parameter, as found by git.Tree.Find. The results
+// are truncated, and marked so, if more than maxFind paths match.
+type find struct {
+	served *served
+	filter *pathFilter // if not nil, paths it does not allow are omitted
+}
+
+func (f *find) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		http.Error(w, "missing pattern", http.StatusBadRequest)
+		return
+	}
+	_, root := f.served.get()
+	paths, err := root.Find(r.Context(), pattern, maxFind)
+	truncated := err == git.ErrTooManyMatches
+	switch {
+	case truncated:
+	case errors.Cause(err) == context.Canceled:
+		// the client has gone away.
+		return
+	case errors.Cause(err) == path.ErrBadPattern:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		httpError(w, err)
+		return
+	}
+	resp := struct {
+		Pattern   string   `json:"pattern"`
+		Paths     []string `json:"paths"`
+		Truncated bool     `json:"truncated,omitempty"`
+	}{
+		Pattern:   pattern,
+		Paths:     make([]string, 0, len(paths)),
+		Truncated: truncated,
+	}
+	for _, p := range paths {
+		if f.filter.allowed(p, false) {
+			resp.Paths = append(resp.Paths, p)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// fsckMain implements gitdav fsck, which checks the packs of a repository
+// are intact, reading each in full, so damaged storage is found before it
+// is served. It exits with status 1 if a pack is damaged.
+func fsckMain(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "require the path to be the root of the repository, rather than searching the directories above it")
+	fs.Parse(args)
+	if len(fs.Args()) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gitdav fsck <repository>")
+		os.Exit(2)
+	}
+	repo, err := openRepository(fs.Args()[0], *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+	n, err := repo.VerifyPacks()
+	if git.IsCorrupt(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	fmt.Println(n, "packs ok")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestH2C(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	srv := httptest.NewServer(h2c.NewHandler(filesHandler(s, blobs{}), &http2.Server{}))
+	defer srv.Close()
+
+	h2 := &http.Client{Transport: &http2.Transport{
+		// speak HTTP/2 over TCP, without TLS.
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	tests := []struct {
+		name   string
+		client *http.Client
+		proto  int
+	}{
+		{"h2c", h2, 2},
+		{"HTTP/1.1", http.DefaultClient, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.client.Get(srv.URL + "/a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := readBody(t, resp)
+			if resp.StatusCode != http.StatusOK || body != "v5\n" {
+				t.Errorf("GET /a.txt: got %s %q", resp.Status, body)
+			}
+			if resp.ProtoMajor != tt.proto {
+				t.Errorf("GET /a.txt: served over %s", resp.Proto)
+			}
+
+			req, err := http.NewRequest("PROPFIND", srv.URL+"/d/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Depth", "1")
+			resp, err = tt.client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = readBody(t, resp)
+			if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "big.txt") {
+				t.Errorf("PROPFIND /d/: got %s %q", resp.Status, body)
+			}
+		})
+	}
+}
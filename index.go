@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// manifest describes every file and directory in the tree of a commit.
+// It is served as /manifest.json, and written by gitdav index, so that
+// gitdav -index can serve listings without reading the trees again.
+type manifest struct {
+	Commit  string          `json:"commit,omitempty"`
+	Tree    string          `json:"tree"`
+	Entries []manifestEntry `json:"entries"`
+}
+
+type manifestEntry struct {
+	Path string `json:"path"`
+
+	// Mode is the mode of the entry in octal, as git ls-tree lists it:
+	// 040000, 100644, 100755, 120000, or 160000.
+	Mode string `json:"mode"`
+
+	Size int64  `json:"size,omitempty"`
+	SHA  string `json:"sha"`
+
+	// SHA256 is the SHA-256 of the content of a file or symlink, only
+	// when requested.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// mode is Mode as an os.FileMode.
+	mode os.FileMode
+}
+
+// buildManifest returns the manifest of root, the tree of commit. The
+// size of each blob is read from its object header. Paths filter does
+// not allow are omitted. If sums is not nil, the SHA-256 of each blob is
+// included, which requires reading every blob not already in sums.
+func buildManifest(commit *git.Commit, root *git.Tree, filter *pathFilter, sums *sha256Sums) (*manifest, error) {
+	m := manifest{
+		Commit:  commitID(commit),
+		Tree:    root.ID(),
+		Entries: []manifestEntry{},
+	}
+	err := root.Walk(func(p string, e *git.Entry) error {
+		if !filter.allowed(p, e.Mode.IsDir()) {
+			return nil
+		}
+		me := manifestEntry{Path: p, Mode: fmt.Sprintf("%06o", e.GitMode()), SHA: e.ID(), mode: e.Mode}
+		if e.Mode.IsRegular() || e.Mode&os.ModeSymlink != 0 {
+			b, err := e.Blob()
+			if err != nil {
+				return err
+			}
+			me.Size = b.Size
+			b.Close()
+			if sums != nil {
+				if me.SHA256, err = sums.sum(e); err != nil {
+					return err
+				}
+			}
+		}
+		m.Entries = append(m.Entries, me)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// readManifest reads the manifest written by gitdav index to path.
+func readManifest(path string) (*manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	var m manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, errors.Wrapf(err, "could not read index %q", path)
+	}
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		mode, err := strconv.ParseUint(e.Mode, 8, 32)
+		if err != nil {
+			return nil, errors.Errorf("could not read index %q: %s has invalid mode %q", path, e.Path, e.Mode)
+		}
+		e.mode = git.FileMode(uint32(mode))
+	}
+	return &m, nil
+}
+
+// indexMain implements gitdav index, which writes the manifest of a
+// commit to stdout.
+func indexMain(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	c := fs.String("c", "", "commit to index")
+	strict := fs.Bool("strict", false, "require the path to be the root of the repository, rather than searching the directories above it")
+	fs.Parse(args)
+	if len(fs.Args()) != 1 || *c == "" {
+		fmt.Fprintln(os.Stderr, "usage: gitdav index -c <commit> <repository>")
+		os.Exit(2)
+	}
+	repo, err := openRepository(fs.Args()[0], *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+	commit, root, err := resolve(repo, *c)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	m, err := buildManifest(commit, root, nil, nil)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(m); err != nil {
+		log.Fatalf("%+v", errors.WithStack(err))
+	}
+}
+
+// manifestHandler serves the manifest of the served tree as JSON. The
+// manifest is built on first request for each tree, then cached.
+//
+// The hashes query parameter, a comma separated list of git and sha256,
+// selects the hashes of each file included. The git object id is always
+// included. sha256 adds the SHA-256 of the content of each file, which
+// requires reading every file on first request, so is opt in; the sums
+// are cached by sha256.
+type manifestHandler struct {
+	served *served
+	filter *pathFilter
+	sha256 *sha256Sums
+
+	mu       sync.Mutex
+	manifest *manifest
+	hashed   *manifest // with SHA-256s
+}
+
+func (h *manifestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var sums *sha256Sums
+	if hashes := r.URL.Query().Get("hashes"); hashes != "" {
+		for _, hash := range strings.Split(hashes, ",") {
+			switch strings.TrimSpace(hash) {
+			case "git":
+			case "sha256":
+				sums = h.sha256
+			default:
+				http.Error(w, fmt.Sprintf("unknown hash %q", hash), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	cached := &h.manifest
+	if sums != nil {
+		cached = &h.hashed
+	}
+	commit, root := h.served.get()
+	h.mu.Lock()
+	m := *cached
+	h.mu.Unlock()
+	if m == nil || m.Tree != root.ID() {
+		var err error
+		if m, err = buildManifest(commit, root, h.filter, sums); err != nil {
+			httpError(w, err)
+			return
+		}
+		h.mu.Lock()
+		*cached = m
+		h.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// indexDir is a webdav.FileSystem serving a commit whose files and
+// directories are described by a manifest. Listings and sizes come from
+// the manifest, so trees are never read; blobs are read when opened.
+type indexDir struct {
+	repo   *git.Repository
+	commit *git.Commit
+
+	entries  map[string]*manifestEntry
+	children map[string][]*manifestEntry // directory path to its entries
+
+	// noListing hides the contents of directories.
+	noListing bool
+
+	// cache, if not nil, holds the contents of blobs once read.
+	cache *blobCache
+
+	// filter, if not nil, restricts the paths served.
+	filter *pathFilter
+}
+
+// newIndexDir returns an indexDir serving root, the tree of commit, which
+// is described by m. commit is nil if the tree is served directly.
+func newIndexDir(repo *git.Repository, commit *git.Commit, root *git.Tree, m *manifest) (*indexDir, error) {
+	if m.Tree != root.ID() {
+		return nil, errors.Errorf("index is of tree %s, serving tree %s", m.Tree, root.ID())
+	}
+	d := indexDir{
+		repo:     repo,
+		commit:   commit,
+		entries:  make(map[string]*manifestEntry, len(m.Entries)),
+		children: make(map[string][]*manifestEntry),
+	}
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		d.entries[e.Path] = e
+		dir := path.Dir(e.Path)
+		if dir == "." {
+			dir = ""
+		}
+		d.children[dir] = append(d.children[dir], e)
+	}
+	return &d, nil
+}
+
+func (d *indexDir) Mkdir(path string, mode os.FileMode) error { return os.ErrInvalid }
+func (d *indexDir) RemoveAll(name string) error               { return os.ErrInvalid }
+func (d *indexDir) Rename(oldName, newName string) error      { return os.ErrInvalid }
+
+func (d *indexDir) Stat(name string) (os.FileInfo, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	if p == "" {
+		return &fileinfo{name: "/", mode: os.ModeDir | 0644, modTime: commitTime(d.commit)}, nil
+	}
+	e, ok := d.entries[p]
+	if !ok || !d.filter.allowed(p, e.mode.IsDir()) {
+		return nil, notExist(name)
+	}
+	return d.fileinfo(e), nil
+}
+
+func (d *indexDir) OpenFile(name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	if p != "" {
+		e, ok := d.entries[p]
+		switch {
+		case !ok, e.mode&os.ModeIrregular != 0, !d.filter.allowed(p, e.mode.IsDir()):
+			return nil, notExist(name)
+		case !e.mode.IsDir():
+			b, err := d.repo.Blob(e.SHA)
+			if err != nil {
+				return nil, err
+			}
+			return &blob{
+				name:    path.Base(p),
+				commit:  commitID(d.commit),
+				modTime: commitTime(d.commit),
+				mode:    e.mode,
+				Blob:    b,
+				cache:   d.cache,
+			}, nil
+		}
+	}
+	var entries []os.FileInfo
+	if !d.noListing {
+		for _, e := range d.children[p] {
+			if !d.filter.allowed(e.Path, e.mode.IsDir()) {
+				continue
+			}
+			entries = append(entries, d.fileinfo(e))
+		}
+	}
+	return &listing{name: path.Base("/" + p), entries: entries, modTime: commitTime(d.commit)}, nil
+}
+
+func (d *indexDir) fileinfo(e *manifestEntry) os.FileInfo {
+	return &fileinfo{name: path.Base(e.Path), size: e.Size, mode: e.mode, modTime: commitTime(d.commit)}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// infoRefs serves the refs of the repository in the format of git
+// ls-remote, one "<id>\t<ref>" line per ref, as listed by listRefs. This
+// is for scripts. If smart is not nil, requests from git clients, with a
+// service query parameter, are instead served the ref advertisement of
+// git's smart HTTP protocol.
+type infoRefs struct {
+	repo  *git.Repository
+	smart *smartHTTP
+}
+
+func (i *infoRefs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if service := r.URL.Query().Get("service"); service != "" && i.smart != nil {
+		i.smart.advertise(w, r, service)
+		return
+	}
+	refs, err := listRefs(i.repo)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	var buf bytes.Buffer
+	for _, ref := range refs {
+		fmt.Fprintf(&buf, "%s\t%s\n", ref.id, ref.name)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// listRefs returns the refs of the repository: HEAD, if it resolves,
+// then each branch and tag sorted by name. Each annotated tag is
+// followed by the object it peels to, named with a ^{} suffix.
+func listRefs(repo *git.Repository) ([]advertisedRef, error) {
+	var list []advertisedRef
+	if sha, err := repo.Head(); err == nil {
+		list = append(list, advertisedRef{name: "HEAD", id: sha})
+	}
+	refs := make(map[string]string)
+	for prefix, branchesOrTags := range map[string]func() (map[string]string, error){
+		"refs/heads/": repo.Branches,
+		"refs/tags/":  repo.Tags,
+	} {
+		m, err := branchesOrTags()
+		if err != nil {
+			return nil, err
+		}
+		for name, sha := range m {
+			refs[prefix+name] = sha
+		}
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sha := refs[name]
+		list = append(list, advertisedRef{name: name, id: sha})
+		peeled, err := repo.Peel(sha)
+		if err != nil {
+			return nil, err
+		}
+		if peeled != sha {
+			list = append(list, advertisedRef{name: name + "^{}", id: peeled})
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,148 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// minAbbrev is the length of the shortest abbreviated object id
+// resolved, as by git rev-parse.
+const minAbbrev = 4
+
+// prefixStore is implemented by ObjectStores which can list the objects
+// whose ids start with a prefix.
+type prefixStore interface {
+	// withPrefix calls fn with the id of each object whose id starts
+	// with prefix, a lower case hex string. An object may be reported
+	// more than once.
+	withPrefix(prefix string, fn func(sha string)) error
+}
+
+// isAbbrev reports whether s may be an abbreviated object id: at least
+// minAbbrev, and fewer than 40, hex digits.
+func isAbbrev(s string) bool {
+	if len(s) < minAbbrev || len(s) >= 40 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// expandAbbrev returns the id of the object whose id starts with prefix.
+// If there is no such object the error satisfies IsNotExist, and if there
+// is more than one, IsAmbiguous.
+func (r *Repository) expandAbbrev(prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	m, _ := r.objects().(multiStore)
+	found, err := m.withPrefix(prefix)
+	if err == nil && len(found) == 0 && m.reload() {
+		found, err = m.withPrefix(prefix)
+	}
+	if err != nil {
+		return "", err
+	}
+	switch len(found) {
+	case 0:
+		return "", notFound(prefix)
+	case 1:
+		for sha := range found {
+			return sha, nil
+		}
+	}
+	ids := make([]string, 0, len(found))
+	for sha := range found {
+		ids = append(ids, sha)
+	}
+	sort.Strings(ids)
+	return "", errors.Wrapf(ErrAmbiguous, "%s could be any of %s", prefix, strings.Join(ids, ", "))
+}
+
+// withPrefix returns the ids of the objects, in any of m's stores, whose
+// ids start with prefix.
+func (m multiStore) withPrefix(prefix string) (map[string]bool, error) {
+	found := make(map[string]bool)
+	for _, s := range m {
+		ps, ok := s.(prefixStore)
+		if !ok {
+			continue
+		}
+		if err := ps.withPrefix(prefix, func(sha string) { found[sha] = true }); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func (s *looseStore) withPrefix(prefix string, fn func(string)) error {
+	f, err := os.Open(filepath.Join(s.dir, prefix[:2]))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, name := range names {
+		if sha := prefix[:2] + name; isObjectID(sha) && strings.HasPrefix(sha, prefix) {
+			fn(sha)
+		}
+	}
+	return nil
+}
+
+func (s *packStore) withPrefix(prefix string, fn func(string)) error {
+	packs, err := s.load()
+	if err != nil {
+		return err
+	}
+	for _, p := range packs {
+		if err := p.withPrefix(prefix, fn); err != nil {
+			return errors.Wrapf(err, "could not search pack %q", p.path)
+		}
+	}
+	return nil
+}
+
+// withPrefix calls fn with the id of each object in the pack whose id
+// starts with prefix.
+func (p *pack) withPrefix(prefix string, fn func(string)) error {
+	// the ids are sorted, so those with the prefix follow the first id
+	// not less than the prefix padded with zeros.
+	start, err := hex.DecodeString(prefix + strings.Repeat("0", 40-len(prefix)))
+	if err != nil {
+		return errors.Errorf("invalid object id prefix %q", prefix)
+	}
+	lo := 0
+	if start[0] > 0 {
+		lo = int(p.fanout[start[0]-1])
+	}
+	hi := int(p.fanout[start[0]])
+	if lo > hi || hi > len(p.names)/20 {
+		return corrupt("malformed pack index fanout table")
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.names[(lo+i)*20:(lo+i+1)*20], start) >= 0
+	})
+	for ; i < hi; i++ {
+		sha := hex.EncodeToString(p.names[i*20 : (i+1)*20])
+		if !strings.HasPrefix(sha, prefix) {
+			break
+		}
+		fn(sha)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OpenArchive returns a Repository reading the git directory held in the
+// tar or zip file at path, without extracting it. The git directory may
+// be at the root of the archive, as for a bare repository, or below it,
+// as repo/.git; the shallowest directory holding HEAD and objects is
+// used. Tar files must not be compressed, as the objects are read from
+// their offsets in the file.
+//
+// Support is experimental and limited. Only loose objects are read, so
+// archives holding packs are refused. Refs are resolved from the
+// archive's loose refs and packed-refs, by a RefResolver set with
+// SetRefResolver, but not listed, so UseReplaceRefs is not set, and the
+// config is not read. The archive is indexed when opened, so memory
+// grows with the number of files in the git directory, one for each
+// object.
+func OpenArchive(path string) (*Repository, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert path %q to an absolute path", path)
+	}
+	a, err := openGitArchive(abs)
+	if err != nil {
+		return nil, err
+	}
+	// the git directory is reported as the archive, followed by the
+	// directory within it. Nothing exists at that path, so reading the
+	// git directory from disk, as for the config, finds nothing.
+	gitdir := abs + "#" + strings.TrimSuffix(a.dir, "/")
+	r := &Repository{Root: abs, gitdir: gitdir, commondir: gitdir, bare: true}
+	r.SetObjectStore(&archiveStore{a: a})
+	r.SetRefResolver(archiveRefs{a})
+	return r, nil
+}
+
+// gitArchive is a git directory held in a tar or zip file.
+type gitArchive struct {
+	f *os.File
+
+	// dir is the git directory within the archive, empty or ending in a
+	// slash.
+	dir string
+
+	// files opens each file in the git directory, by its path below dir.
+	files map[string]func() (io.ReadCloser, error)
+
+	packedRefs map[string]string
+}
+
+// openGitArchive opens the archive at file, and indexes the files of the
+// git directory within it.
+func openGitArchive(file string) (*gitArchive, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	a := &gitArchive{f: f}
+	var files map[string]func() (io.ReadCloser, error)
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".tar":
+		files, err = indexTar(f)
+	case ".zip":
+		files, err = indexZip(f)
+	default:
+		err = errors.Errorf("unsupported archive format %q, want .tar or .zip", ext)
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "could not read archive %q", file)
+	}
+
+	found := false
+	for name := range files {
+		dir := strings.TrimSuffix(name, "HEAD")
+		if path.Base(name) != "HEAD" || (found && len(dir) >= len(a.dir)) {
+			continue
+		}
+		for other := range files {
+			if strings.HasPrefix(other, dir+"objects/") {
+				a.dir, found = dir, true
+				break
+			}
+		}
+	}
+	if !found {
+		f.Close()
+		return nil, errors.Errorf("archive %q holds no git directory", file)
+	}
+	a.files = make(map[string]func() (io.ReadCloser, error))
+	for name, open := range files {
+		if !strings.HasPrefix(name, a.dir) {
+			continue
+		}
+		name = strings.TrimPrefix(name, a.dir)
+		if strings.HasPrefix(name, "objects/pack/") && strings.HasSuffix(name, ".pack") {
+			f.Close()
+			return nil, errors.Errorf("archive %q holds packs, only loose objects are supported", file)
+		}
+		a.files[name] = open
+	}
+
+	a.packedRefs = make(map[string]string)
+	if buf, err := a.readFile("packed-refs"); err == nil {
+		a.packedRefs, err = parsePackedRefs(strings.NewReader(string(buf)))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else if !IsNotExist(err) {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// indexTar returns a function opening each regular file in the tar file
+// f, by its path. Each file is read from its offset in f.
+func indexTar(f *os.File) (map[string]func() (io.ReadCloser, error), error) {
+	files := make(map[string]func() (io.ReadCloser, error))
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		// the reader has consumed the header, so f is positioned at the
+		// start of the file's content.
+		off, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		size := hdr.Size
+		files[archivePath(hdr.Name)] = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(io.NewSectionReader(f, off, size)), nil
+		}
+	}
+}
+
+// indexZip returns a function opening each file in the zip file f, by its
+// path.
+func indexZip(f *os.File) (map[string]func() (io.ReadCloser, error), error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	files := make(map[string]func() (io.ReadCloser, error))
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		files[archivePath(zf.Name)] = zf.Open
+	}
+	return files, nil
+}
+
+// archivePath returns the slash separated path, relative to the root of
+// the archive, of the archive entry called name.
+func archivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// readFile returns the content of the file name in the git directory.
+func (a *gitArchive) readFile(name string) ([]byte, error) {
+	open, ok := a.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	rc, err := open()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	return buf, errors.Wrapf(err, "could not read %q", name)
+}
+
+// readRef returns the object id the fully qualified ref name points to,
+// following symbolic refs, as Repository.readRef does. The second result
+// is false if there is no such ref.
+func (a *gitArchive) readRef(name string) (string, bool, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		if !validRefName(name) {
+			return "", false, nil
+		}
+		buf, err := a.readFile(name)
+		if IsNotExist(err) {
+			sha, ok := a.packedRefs[name]
+			return sha, ok, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		ref := strings.TrimSpace(string(buf))
+		if !strings.HasPrefix(ref, "ref:") {
+			if !isObjectID(ref) {
+				return "", false, errors.Errorf("ref %q is malformed", name)
+			}
+			return ref, true, nil
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(ref, "ref:"))
+	}
+	return "", false, errors.Errorf("too many levels of symbolic refs resolving %q", name)
+}
+
+// archiveStore is an ObjectStore holding the loose objects of a git
+// directory in an archive.
+type archiveStore struct {
+	a *gitArchive
+}
+
+func (s *archiveStore) Get(sha string) (Header, io.ReadCloser, error) {
+	open, ok := s.a.files[looseObjectPath(sha)]
+	if !ok {
+		return Header{}, nil, notFound(sha)
+	}
+	rc, err := open()
+	if err != nil {
+		return Header{}, nil, errors.WithStack(err)
+	}
+	in, err := newInflater(rc)
+	if err != nil {
+		rc.Close()
+		return Header{}, nil, errors.WithStack(err)
+	}
+	h, err := readHeader(in)
+	if err != nil {
+		in.Close()
+		return Header{}, nil, err
+	}
+	return h, &sizedReader{ReadCloser: in, remaining: h.Length}, nil
+}
+
+func (s *archiveStore) Has(sha string) bool {
+	_, ok := s.a.files[looseObjectPath(sha)]
+	return ok
+}
+
+// Close closes the archive.
+func (s *archiveStore) Close() error {
+	return errors.WithStack(s.a.f.Close())
+}
+
+// looseObjectPath returns the path of the loose object sha below the git
+// directory.
+func looseObjectPath(sha string) string {
+	if len(sha) < 3 {
+		return ""
+	}
+	return "objects/" + sha[:2] + "/" + sha[2:]
+}
+
+// archiveRefs resolves names from the loose refs and packed-refs of a git
+// directory in an archive, in the order ResolveRef tries them.
+type archiveRefs struct {
+	a *gitArchive
+}
+
+func (ar archiveRefs) Resolve(name string) (string, error) {
+	for _, ref := range refNames(name) {
+		sha, ok, err := ar.a.readRef(ref)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return sha, nil
+		}
+	}
+	return "", &os.PathError{Op: "resolve", Path: name, Err: os.ErrNotExist}
+}
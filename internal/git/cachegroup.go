@@ -0,0 +1,133 @@
+package git
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// maxCachedObject is the length of the largest object held by a
+// CacheGroup.
+const maxCachedObject = 64 << 10
+
+// CacheGroup is an in memory cache of the content of trees, commits, and
+// tags, which any number of Repositories may share, as when serving many
+// forks of one project, whose objects are mostly the same. Blobs, and
+// objects larger than 64KB, are not cached. Objects are keyed by the
+// object format of their repository as well as by id, so repositories of
+// different formats never share entries, and an object is only added once
+// its content is found to match its id, so a corrupt repository cannot
+// change what the others in the group read. Once the cache holds more than
+// its maximum size, the least recently used objects are removed.
+type CacheGroup struct {
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List               // of *cachedObject, most recently used first
+	entries map[string]*list.Element // format and id to element of lru
+	size    int64
+}
+
+type cachedObject struct {
+	key     string
+	header  Header
+	content []byte
+}
+
+// NewCacheGroup returns an empty CacheGroup holding at most maxSize bytes
+// of object content.
+func NewCacheGroup(maxSize int64) *CacheGroup {
+	return &CacheGroup{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// SetCacheGroup makes the repository read trees, commits, and tags
+// through g, shared with the other repositories using it. A nil g stops
+// the repository using a group. SetCacheGroup must be called before the
+// repository is used.
+func (r *Repository) SetCacheGroup(g *CacheGroup) error {
+	if g == nil {
+		r.cache, r.cacheFormat = nil, ""
+		return nil
+	}
+	format, err := r.ObjectFormat()
+	if err != nil {
+		return err
+	}
+	r.cache, r.cacheFormat = g, format
+	return nil
+}
+
+// get returns the header and content of the object sha in a repository
+// of the given object format, if it is cached.
+func (g *CacheGroup) get(format, sha string) (Header, []byte, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e, ok := g.entries[format+" "+sha]
+	if !ok {
+		return Header{}, nil, false
+	}
+	g.lru.MoveToFront(e)
+	o := e.Value.(*cachedObject)
+	return o.header, o.content, true
+}
+
+// add caches the content of the object sha, of a repository of the given
+// object format, if it matches sha.
+func (g *CacheGroup) add(format, sha string, h Header, content []byte) {
+	if format != "sha1" {
+		// the content cannot be checked.
+		return
+	}
+	s := sha1.New()
+	fmt.Fprintf(s, "%s %d\x00", h.Kind, h.Length)
+	s.Write(content)
+	if hex.EncodeToString(s.Sum(nil)) != sha {
+		return
+	}
+
+	key := format + " " + sha
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.entries[key]; ok {
+		return
+	}
+	g.entries[key] = g.lru.PushFront(&cachedObject{key: key, header: h, content: content})
+	g.size += int64(len(content))
+	for g.size > g.maxSize && g.lru.Len() > 0 {
+		e := g.lru.Back()
+		o := g.lru.Remove(e).(*cachedObject)
+		delete(g.entries, o.key)
+		g.size -= int64(len(o.content))
+	}
+}
+
+// readCached returns the header and content of the object sha from the
+// repository's CacheGroup, reading it from the object store, and adding it
+// to the group, if it is not already cached.
+func (r *Repository) readCached(sha string) (Header, io.ReadCloser, error) {
+	if h, content, ok := r.cache.get(r.cacheFormat, sha); ok {
+		return h, ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+	h, rc, err := r.objects().Get(sha)
+	if err != nil || h.Kind == "blob" || h.Length > maxCachedObject {
+		return h, rc, err
+	}
+	defer rc.Close()
+	content, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return Header{}, nil, errors.Wrapf(err, "could not read object %s", sha)
+	}
+	r.cache.add(r.cacheFormat, sha, h, content)
+	return h, ioutil.NopCloser(bytes.NewReader(content)), nil
+}
@@ -0,0 +1,104 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// readAll reads every object in objects from r, checking it against git.
+func readAll(t *testing.T, r *Repository, dir string, objects []object) {
+	t.Helper()
+	for _, o := range objects {
+		kind, got, err := readContent(r, o.sha)
+		if err != nil {
+			t.Fatalf("%s: %v", o.sha, err)
+		}
+		if want := gitOutput(t, dir, "cat-file", o.kind, o.sha); kind != o.kind || !bytes.Equal(got, []byte(want)) {
+			t.Errorf("%s: got %s, content differs from git cat-file", o.sha, kind)
+		}
+	}
+}
+
+func TestCacheGroup(t *testing.T) {
+	// the loose and packed fixtures hold the same objects, stored
+	// differently.
+	loose, packed := fixture(t, gittest.Loose), fixture(t, gittest.Packed)
+	objects := allObjects(t, loose)
+	g := NewCacheGroup(1 << 20)
+	var repos []*Repository
+	for _, dir := range []string{loose, packed} {
+		r, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if err := r.SetCacheGroup(g); err != nil {
+			t.Fatal(err)
+		}
+		repos = append(repos, r)
+	}
+
+	readAll(t, repos[0], loose, objects)
+	cached := len(g.entries)
+	want := 0
+	for _, o := range objects {
+		if o.kind != "blob" && o.size <= maxCachedObject {
+			want++
+			if _, _, ok := g.get("sha1", o.sha); !ok {
+				t.Errorf("%s %s not cached", o.kind, o.sha)
+			}
+		}
+	}
+	if cached != want {
+		t.Errorf("%d objects cached, want %d, the trees, commits, and tags", cached, want)
+	}
+
+	// the second repository reads from the group, adding nothing.
+	readAll(t, repos[1], packed, objects)
+	if len(g.entries) != cached {
+		t.Errorf("%d objects cached reading the same objects again, want %d", len(g.entries), cached)
+	}
+}
+
+func TestCacheGroupMaxSize(t *testing.T) {
+	dir := fixture(t, gittest.Loose)
+	const maxSize = 1 << 10
+	g := NewCacheGroup(maxSize)
+	r := openFixture(t, gittest.Loose)
+	defer r.Close()
+	if err := r.SetCacheGroup(g); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, r, dir, allObjects(t, dir))
+	if g.size > maxSize || g.size == 0 || g.lru.Len() != len(g.entries) {
+		t.Errorf("cache holds %d bytes in %d objects, %d in its list, want at most %d bytes", g.size, len(g.entries), g.lru.Len(), maxSize)
+	}
+}
+
+// TestCacheGroupMismatch checks an object whose content does not match
+// its id is read, but not cached for other repositories to read.
+func TestCacheGroupMismatch(t *testing.T) {
+	gitDir := emptyRepo(t, t.TempDir())
+	sha, _ := looseObject("commit", []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904b\n\nreal\n"))
+	_, forged := looseObject("commit", []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904b\n\nforged\n"))
+	writeLoose(t, gitDir, sha, compress(t, forged, zlib.DefaultCompression))
+
+	g := NewCacheGroup(1 << 20)
+	r, err := Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.SetCacheGroup(g); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readContent(r, sha); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := g.get("sha1", sha); ok {
+		t.Errorf("%s cached, though its content does not match its id", sha)
+	}
+}
@@ -0,0 +1,249 @@
+package git
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxIncludeDepth is the deepest nesting of config files followed
+// through include.path, as in git.
+const maxIncludeDepth = 10
+
+// Config holds the values of config variables, keyed by section,
+// subsection if any, and name, as in branch.master.remote. Section and
+// variable names are lower cased as they are case insensitive;
+// subsection names are not. A variable may be set more than once, in
+// which case its values are held in the order they were read.
+type Config map[string][]string
+
+// Get returns the last value of the variable key in section. Sections
+// with a subsection are named section.subsection, as in remote.origin.
+func (c Config) Get(section, key string) (string, bool) {
+	values := c.GetAll(section, key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns every value of the variable key in section.
+func (c Config) GetAll(section, key string) []string {
+	return c[configKey(section, key)]
+}
+
+// Bool returns the value of the boolean variable key in section. The
+// second result is false if the variable is not set, or is not a valid
+// boolean.
+func (c Config) Bool(section, key string) (bool, bool) {
+	v, ok := c.Get(section, key)
+	if !ok {
+		return false, false
+	}
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true, true
+	case "false", "no", "off", "0", "":
+		return false, true
+	}
+	return false, false
+}
+
+// configKey returns the key of the variable name in section.
+func configKey(section, name string) string {
+	if i := strings.Index(section, "."); i >= 0 {
+		return strings.ToLower(section[:i]) + section[i:] + "." + strings.ToLower(name)
+	}
+	return strings.ToLower(section) + "." + strings.ToLower(name)
+}
+
+// Config returns the values in the repository's config file, and the
+// files it includes. A missing config file is not an error.
+func (r *Repository) Config() (Config, error) {
+	return readConfig(filepath.Join(r.commonDir(), "config"))
+}
+
+// worktreeConfig returns the values in the repository's config file,
+// followed by those in the config.worktree file of its git directory if
+// extensions.worktreeConfig is set, so they take precedence, as for the
+// settings git sparse-checkout makes for each working tree.
+func (r *Repository) worktreeConfig() (Config, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	if on, _ := cfg.Bool("extensions", "worktreeConfig"); on {
+		if err := cfg.read(filepath.Join(r.gitDir(), "config.worktree"), 0); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// IgnoreCase reports whether core.ignorecase is set in the repository's
+// config, as it is for repositories created on case insensitive file
+// systems. The config is read once; if it cannot be read, IgnoreCase
+// reports false.
+func (r *Repository) IgnoreCase() bool {
+	r.ignoreCaseOnce.Do(func() {
+		if cfg, err := r.Config(); err == nil {
+			r.ignoreCase, _ = cfg.Bool("core", "ignoreCase")
+		}
+	})
+	return r.ignoreCase
+}
+
+// globalConfig returns the values in the user's global config files,
+// $XDG_CONFIG_HOME/git/config then ~/.gitconfig.
+func globalConfig() (Config, error) {
+	cfg := make(Config)
+	for _, path := range []string{xdgConfigPath("config"), expandHome("~/.gitconfig")} {
+		if path == "" {
+			continue
+		}
+		if err := cfg.read(path, 0); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// xdgConfigPath returns the path of name in git's XDG config directory,
+// $XDG_CONFIG_HOME/git, or ~/.config/git.
+func xdgConfigPath(name string) string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "git", name)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "git", name)
+	}
+	return ""
+}
+
+// expandHome replaces a leading ~/ in path with the user's home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// readConfig returns the values in the config file at path, and the
+// files it includes. A missing file is not an error.
+func readConfig(path string) (Config, error) {
+	cfg := make(Config)
+	if err := cfg.read(path, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// read adds the values in the config file at path to c. The files named
+// by include.path are read in turn, where they are included; relative
+// paths are relative to the including file. Conditional includes are
+// not supported. A missing file is not an error.
+func (c Config) read(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return errors.Errorf("config includes nested too deeply at %q", path)
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	section := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		for strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") && sc.Scan() {
+			// a trailing backslash continues the value on the next line.
+			line = line[:len(line)-1] + sc.Text()
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+			if end < 0 {
+				return errors.Errorf("malformed config section %q in %q", line, path)
+			}
+			section = parseSection(line[1:end])
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" {
+				continue
+			}
+		}
+		name, value := line, "true"
+		if i := strings.Index(line, "="); i >= 0 {
+			name, value = strings.TrimSpace(line[:i]), parseValue(line[i+1:])
+		}
+		key := section + "." + strings.ToLower(name)
+		c[key] = append(c[key], value)
+		if key == "include.path" && value != "" {
+			inc := expandHome(value)
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(filepath.Dir(path), inc)
+			}
+			if err := c.read(inc, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.Wrapf(sc.Err(), "could not read config %q", path)
+}
+
+// parseSection returns the key prefix for the section header s, the text
+// between the brackets of [section "subsection"] or [section.subsection].
+func parseSection(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, " "); i >= 0 {
+		sub := strings.TrimSpace(s[i+1:])
+		sub = strings.TrimSuffix(strings.TrimPrefix(sub, `"`), `"`)
+		sub = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(sub)
+		return strings.ToLower(s[:i]) + "." + sub
+	}
+	if i := strings.Index(s, "."); i >= 0 {
+		// the deprecated [section.subsection] syntax.
+		return strings.ToLower(s[:i]) + "." + strings.ToLower(s[i+1:])
+	}
+	return strings.ToLower(s)
+}
+
+// parseValue returns the value of a config variable, removing comments
+// and quotes and interpreting escape sequences.
+func parseValue(s string) string {
+	var buf []byte
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				buf = append(buf, '\n')
+			case 't':
+				buf = append(buf, '\t')
+			default:
+				buf = append(buf, s[i])
+			}
+		case (c == '#' || c == ';') && !quoted:
+			return strings.TrimSpace(string(buf))
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return strings.TrimSpace(string(buf))
+}
@@ -0,0 +1,249 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// SetMaxDeltaMemory sets the length, in bytes, above which objects
+// reconstructed from deltas in packs, and the bases they are reconstructed
+// from, are held in temporary files rather than in memory. Reading such an
+// object holds at most about twice n bytes in memory. Zero, the default,
+// means there is no limit. SetMaxDeltaMemory may be called at any time,
+// and applies to objects read from then on.
+func (r *Repository) SetMaxDeltaMemory(n int64) {
+	atomic.StoreInt64(&r.maxDeltaMemory, n)
+}
+
+func (r *Repository) deltaLimit() int64 {
+	return atomic.LoadInt64(&r.maxDeltaMemory)
+}
+
+// resolved is the content of an object reconstructed from a pack. It is
+// held in memory unless it is larger than the limit it was created with,
+// when it is held in a temporary file instead, so that reconstructing a
+// large object, and the bases it is a delta of, does not require memory
+// in proportion to its size.
+type resolved struct {
+	buf  []byte
+	f    *os.File // if not nil, holds the content, removed by close
+	size int64    // the expected length of the content
+	n    int64    // the length written so far
+}
+
+// maxPreallocate is the most memory newResolved allocates before content
+// is written, so a length read from a corrupt or hostile pack cannot
+// exhaust memory by itself when there is no limit.
+const maxPreallocate = 64 << 20
+
+// newResolved returns an empty resolved for content of length size, held
+// in a temporary file if size is greater than limit, and limit is not
+// zero.
+func newResolved(size, limit int64) (*resolved, error) {
+	if size < 0 {
+		return nil, errors.Errorf("invalid object length %d", size)
+	}
+	o := &resolved{size: size}
+	if limit <= 0 || size <= limit {
+		n := size
+		if n > maxPreallocate {
+			n = maxPreallocate
+		}
+		o.buf = make([]byte, 0, n)
+		return o, nil
+	}
+	f, err := ioutil.TempFile("", "gitdav-delta-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	o.f = f
+	return o, nil
+}
+
+// readResolved returns a resolved holding the length bytes read from r.
+func readResolved(r io.Reader, length, limit int64) (*resolved, error) {
+	o, err := newResolved(length, limit)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(o, r); err != nil {
+		o.close()
+		return nil, errors.WithStack(err)
+	}
+	if err := o.complete(); err != nil {
+		o.close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *resolved) Write(p []byte) (int, error) {
+	if o.n+int64(len(p)) > o.size {
+		return 0, errors.Errorf("object longer than its length %d", o.size)
+	}
+	o.n += int64(len(p))
+	if o.f != nil {
+		return o.f.Write(p)
+	}
+	o.buf = append(o.buf, p...)
+	return len(p), nil
+}
+
+// complete returns an error if less than the expected length was written.
+func (o *resolved) complete() error {
+	if o.n != o.size {
+		return errors.Errorf("object length %d, expected %d", o.n, o.size)
+	}
+	return nil
+}
+
+func (o *resolved) ReadAt(p []byte, off int64) (int, error) {
+	if o.f != nil {
+		return o.f.ReadAt(p, off)
+	}
+	if off >= int64(len(o.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, o.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// copyTo writes n bytes of the content, from off, to w.
+func (o *resolved) copyTo(w io.Writer, off, n int64) error {
+	if o.f == nil {
+		_, err := w.Write(o.buf[off : off+n])
+		return err
+	}
+	_, err := io.Copy(w, io.NewSectionReader(o.f, off, n))
+	return errors.WithStack(err)
+}
+
+// reader returns an io.ReadCloser of the content, which closes o when
+// it is closed.
+func (o *resolved) reader() io.ReadCloser {
+	if o.f == nil {
+		return ioutil.NopCloser(bytes.NewReader(o.buf))
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(o.f, 0, o.size), closerFunc(o.close)}
+}
+
+// close removes the temporary file holding the content, if any.
+func (o *resolved) close() error {
+	if o.f == nil {
+		return nil
+	}
+	err := o.f.Close()
+	os.Remove(o.f.Name())
+	return errors.WithStack(err)
+}
+
+// closerFunc is an io.Closer calling itself.
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
+
+// applyDelta returns the result of applying the delta read from delta to
+// base. The delta is read as it is applied, so is never held in memory
+// whole. The result is held as by newResolved.
+func applyDelta(base *resolved, delta *bufio.Reader, limit int64) (*resolved, error) {
+	varint := func() (int64, error) {
+		var n int64
+		for shift := uint(0); ; shift += 7 {
+			if shift > 56 {
+				return 0, errors.New("delta length overflows")
+			}
+			c, err := delta.ReadByte()
+			if err != nil {
+				return 0, errors.New("delta truncated")
+			}
+			n |= int64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return n, nil
+			}
+		}
+	}
+	srcLen, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	if srcLen != base.size {
+		return nil, errors.Errorf("delta base length %d, expected %d", base.size, srcLen)
+	}
+	dstLen, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	dst, err := newResolved(dstLen, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := patch(dst, base, delta); err != nil {
+		dst.close()
+		return nil, err
+	}
+	return dst, nil
+}
+
+// patch applies the instructions read from delta, following its header,
+// to base, writing the result to dst.
+func patch(dst, base *resolved, delta *bufio.Reader) error {
+	for {
+		op, err := delta.ReadByte()
+		if err == io.EOF {
+			return dst.complete()
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		switch {
+		case op&0x80 != 0:
+			// copy from base
+			var off, n int64
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				c, err := delta.ReadByte()
+				if err != nil {
+					return errors.New("delta truncated")
+				}
+				if i < 4 {
+					off |= int64(c) << (8 * i)
+				} else {
+					n |= int64(c) << (8 * (i - 4))
+				}
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > base.size {
+				return errors.New("delta copy out of range")
+			}
+			if err := base.copyTo(dst, off, n); err != nil {
+				return err
+			}
+		case op != 0:
+			// insert literal
+			if _, err := io.CopyN(dst, delta, int64(op)); err != nil {
+				if err == io.EOF {
+					return errors.New("delta truncated")
+				}
+				return errors.WithStack(err)
+			}
+		default:
+			return errors.New("invalid delta opcode 0")
+		}
+	}
+}
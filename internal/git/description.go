@@ -0,0 +1,46 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultDescription is the description git init writes, which describes
+// nothing.
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository."
+
+// Description returns the description of the repository, as shown by
+// gitweb, from its description file, with surrounding white space
+// removed. It is empty if there is no description file, or the file
+// holds the placeholder written by git init.
+func (r *Repository) Description() (string, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(r.commonDir(), "description"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	desc := strings.TrimSpace(string(buf))
+	if desc == defaultDescription {
+		return "", nil
+	}
+	return desc, nil
+}
+
+// ObjectFormat returns the hash algorithm naming the repository's
+// objects, from extensions.objectFormat, which is sha1 unless set.
+func (r *Repository) ObjectFormat() (string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+	if format, ok := cfg.Get("extensions", "objectFormat"); ok && format != "" {
+		return strings.ToLower(format), nil
+	}
+	return "sha1", nil
+}
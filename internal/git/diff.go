@@ -0,0 +1,165 @@
+package git
+
+import (
+	"path"
+	"sort"
+)
+
+// Change describes a file that differs between two trees.
+type Change struct {
+	// Path is the slash separated path of the file.
+	Path string
+
+	// From is the id of the file before the change, empty if it was added.
+	From string
+
+	// To is the id of the file after the change, empty if it was deleted.
+	To string
+}
+
+// Action returns "add", "delete", or "modify" describing the change.
+func (c *Change) Action() string {
+	switch {
+	case c.From == "":
+		return "add"
+	case c.To == "":
+		return "delete"
+	default:
+		return "modify"
+	}
+}
+
+// Diff returns the files changed by this commit relative to its first
+// parent. The root commit is compared against the empty tree.
+func (c *Commit) Diff() ([]Change, error) {
+	to, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	from := &Tree{Commit: c} // the empty tree
+	if len(c.Parents) > 0 {
+		parent, err := c.readCommit(c.Parents[0])
+		if err != nil {
+			return nil, err
+		}
+		if from, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	return diffTrees(from, to)
+}
+
+// diffTrees returns the files that differ between from and to, sorted by path.
+func diffTrees(from, to *Tree) ([]Change, error) {
+	var changes []Change
+	if err := diffTree(&changes, "", from, to); err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// diffTree appends the changes between from and to, whose path is prefix,
+// to changes. Subtrees with the same id are not descended into.
+func diffTree(changes *[]Change, prefix string, from, to *Tree) error {
+	old := make(map[string]*Entry, len(from.Entries))
+	for i := range from.Entries {
+		old[from.Entries[i].Name] = &from.Entries[i]
+	}
+	for i := range to.Entries {
+		e := &to.Entries[i]
+		o, ok := old[e.Name]
+		delete(old, e.Name)
+		if ok && o.id == e.id && o.Mode == e.Mode {
+			continue
+		}
+		p := path.Join(prefix, e.Name)
+		var err error
+		switch {
+		case !ok:
+			err = changeAll(changes, p, e, true)
+		case o.Mode.IsDir() && e.Mode.IsDir():
+			var a, b *Tree
+			if a, err = o.Subtree(); err != nil {
+				return err
+			}
+			if b, err = e.Subtree(); err != nil {
+				return err
+			}
+			err = diffTree(changes, p, a, b)
+		case !o.Mode.IsDir() && !e.Mode.IsDir():
+			*changes = append(*changes, Change{Path: p, From: o.id, To: e.id})
+		default:
+			// a file has been replaced by a directory, or vice versa.
+			if err = changeAll(changes, p, o, false); err == nil {
+				err = changeAll(changes, p, e, true)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, o := range old {
+		if err := changeAll(changes, path.Join(prefix, o.Name), o, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeAll appends a change adding, or deleting, e and, if e is a tree,
+// every file below it.
+func changeAll(changes *[]Change, p string, e *Entry, added bool) error {
+	if !e.Mode.IsDir() {
+		c := Change{Path: p, From: e.id}
+		if added {
+			c = Change{Path: p, To: e.id}
+		}
+		*changes = append(*changes, c)
+		return nil
+	}
+	t, err := e.Subtree()
+	if err != nil {
+		return err
+	}
+	for i := range t.Entries {
+		if err := changeAll(changes, path.Join(p, t.Entries[i].Name), &t.Entries[i], added); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffRevs returns the files which differ between the trees of base and
+// head, revisions as accepted by ResolveRev, sorted by path. Subtrees
+// with the same id in both are not read. Renames are not detected; a
+// renamed file is reported as deleted from its old path and added at its
+// new one.
+func (r *Repository) DiffRevs(base, head string) ([]Change, error) {
+	var trees [2]*Tree
+	for i, rev := range []string{base, head} {
+		sha, err := r.ResolveRev(rev + "^{tree}")
+		if err != nil {
+			return nil, err
+		}
+		if trees[i], err = r.Tree(sha); err != nil {
+			return nil, err
+		}
+	}
+	return diffTrees(trees[0], trees[1])
+}
+
+// ChangedFiles returns the paths of the files which differ between the
+// trees of base and head, as found by DiffRevs. A renamed file is
+// reported by both its old and new paths.
+func (r *Repository) ChangedFiles(base, head string) ([]string, error) {
+	changes, err := r.DiffRevs(base, head)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(changes))
+	for i := range changes {
+		paths[i] = changes[i].Path
+	}
+	return paths, nil
+}
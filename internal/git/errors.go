@@ -0,0 +1,164 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// IsNotExist reports whether err, or the error it wraps, indicates that
+// an object or path does not exist.
+func IsNotExist(err error) bool {
+	return os.IsNotExist(errors.Cause(err))
+}
+
+// ErrCorruptPack is the cause of errors reading a pack, or its index,
+// whose content is damaged, as when either is truncated, or they do not
+// belong together.
+var ErrCorruptPack = errors.New("corrupt pack")
+
+// IsCorrupt reports whether err, or the error it wraps, is ErrCorruptPack.
+func IsCorrupt(err error) bool {
+	return errors.Cause(err) == ErrCorruptPack
+}
+
+// corrupt returns an error, satisfying IsCorrupt, describing the damage
+// found in a pack.
+func corrupt(format string, args ...interface{}) error {
+	return errors.Wrapf(ErrCorruptPack, format, args...)
+}
+
+// ErrAmbiguous is the cause of errors resolving an abbreviated object id
+// which is the start of the ids of more than one object.
+var ErrAmbiguous = errors.New("ambiguous object id")
+
+// IsAmbiguous reports whether err, or the error it wraps, is
+// ErrAmbiguous.
+func IsAmbiguous(err error) bool {
+	return errors.Cause(err) == ErrAmbiguous
+}
+
+// IsTransient reports whether err, or the error it wraps, is an I/O error
+// that may succeed if retried, such as the EIO and ESTALE errors returned
+// by network file systems. Transient errors do not indicate the object
+// is missing.
+func IsTransient(err error) bool {
+	err = errors.Cause(err)
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	switch err {
+	case syscall.EIO, syscall.ESTALE, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT:
+		return true
+	default:
+		return false
+	}
+}
+
+// ObjectError records an error reading or parsing a git object, and the
+// id and kind of the object. Kind is the kind of object expected, or
+// "object" if any kind was.
+type ObjectError struct {
+	Kind string
+	SHA  string
+	Err  error
+}
+
+func (e *ObjectError) Error() string {
+	return "failed reading " + e.Kind + " " + e.SHA + ": " + e.Err.Error()
+}
+
+// Cause returns the error that caused the object to be unreadable, so
+// errors.Cause, and hence IsNotExist and IsTransient, see through it.
+func (e *ObjectError) Cause() error { return e.Err }
+
+// Unwrap returns the error that caused the object to be unreadable.
+func (e *ObjectError) Unwrap() error { return e.Err }
+
+// Format formats the error as errors.Wrap does; with %+v, the cause is
+// printed with its stack trace, followed by the object.
+func (e *ObjectError) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			fmt.Fprintf(s, "%+v\n", e.Err)
+			io.WriteString(s, "failed reading "+e.Kind+" "+e.SHA)
+			return
+		}
+		fallthrough
+	case 's', 'q':
+		io.WriteString(s, e.Error())
+	}
+}
+
+// objectError returns err wrapped in an ObjectError for the object sha of
+// the given kind. It returns nil if err is nil, and err unchanged if it
+// already describes sha.
+func objectError(kind, sha string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if oe, ok := err.(*ObjectError); ok && oe.SHA == sha {
+		return err
+	}
+	return &ObjectError{Kind: kind, SHA: sha, Err: err}
+}
+
+// MissingTreeError records that the tree of a commit is missing from the
+// repository, as when the repository is corrupt or only partly fetched,
+// although the commit itself could be read.
+type MissingTreeError struct {
+	Commit string
+	Tree   string
+	Err    error
+}
+
+func (e *MissingTreeError) Error() string {
+	return "commit " + e.Commit + " references missing tree " + e.Tree
+}
+
+// Cause returns the error reading the tree, so errors.Cause, and hence
+// IsNotExist, see through it.
+func (e *MissingTreeError) Cause() error { return e.Err }
+
+// Unwrap returns the error reading the tree.
+func (e *MissingTreeError) Unwrap() error { return e.Err }
+
+// Format formats the error as errors.Wrap does; with %+v, the cause is
+// printed with its stack trace, followed by the commit and tree.
+func (e *MissingTreeError) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			fmt.Fprintf(s, "%+v\n", e.Err)
+			io.WriteString(s, e.Error())
+			return
+		}
+		fallthrough
+	case 's', 'q':
+		io.WriteString(s, e.Error())
+	}
+}
+
+// IsMissingTree reports whether err, or an error it wraps, is a
+// *MissingTreeError, distinguishing a commit whose tree is missing from
+// a commit which is itself missing.
+func IsMissingTree(err error) bool {
+	for err != nil {
+		if _, ok := err.(*MissingTreeError); ok {
+			return true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package git
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooManyMatches is returned by Find when more paths match than the
+// maximum requested.
+var ErrTooManyMatches = errors.New("too many matches")
+
+// Find returns the slash separated paths, relative to this tree, of the
+// entries below it matching pattern, in the order Walk visits them.
+// pattern is matched one path element at a time using path.Match, and a
+// ** element matches any number of path elements, so **/*.go matches
+// every Go file. Subtrees which cannot hold a match are not read. An
+// invalid pattern is reported by an error whose cause is
+// path.ErrBadPattern.
+//
+// If max is greater than zero and more than max entries match, Find
+// returns the first max paths and ErrTooManyMatches. Find stops, and
+// returns the error of ctx, if ctx is done before the search is.
+func (t *Tree) Find(ctx context.Context, pattern string, max int) ([]string, error) {
+	pattern = strings.Trim(pattern, "/")
+	if pattern == "" {
+		return nil, errors.Wrap(path.ErrBadPattern, "empty pattern")
+	}
+	elems := strings.Split(pattern, "/")
+	for _, elem := range elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+	}
+	var paths []string
+	err := t.find(ctx, elems, nil, func(p string) error {
+		if max > 0 && len(paths) == max {
+			return ErrTooManyMatches
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	return paths, err
+}
+
+func (t *Tree) find(ctx context.Context, pattern, dir []string, fn func(string) error) error {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		elems := append(dir[:len(dir):len(dir)], e.Name)
+		if matchGlob(pattern, elems) {
+			if err := fn(strings.Join(elems, "/")); err != nil {
+				return err
+			}
+		}
+		if !e.Mode.IsDir() || !matchGlobPrefix(pattern, elems) {
+			continue
+		}
+		sub, err := e.Subtree()
+		if err != nil {
+			return err
+		}
+		if err := sub.find(ctx, pattern, elems, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the path elements in elems match pattern.
+func matchGlob(pattern, elems []string) bool {
+	switch {
+	case len(pattern) == 0:
+		return len(elems) == 0
+	case pattern[0] == "**":
+		return matchGlob(pattern[1:], elems) || (len(elems) > 0 && matchGlob(pattern, elems[1:]))
+	case len(elems) == 0:
+		return false
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && matchGlob(pattern[1:], elems[1:])
+}
+
+// matchGlobPrefix reports whether a path below the directory whose path
+// elements are elems may match pattern.
+func matchGlobPrefix(pattern, elems []string) bool {
+	switch {
+	case len(elems) == 0:
+		return len(pattern) > 0
+	case len(pattern) == 0:
+		return false
+	case pattern[0] == "**":
+		return true
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && matchGlobPrefix(pattern[1:], elems[1:])
+}
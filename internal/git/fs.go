@@ -0,0 +1,201 @@
+package git
+
+import (
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// TreeFS returns the contents of the tree t as an fs.FS, which also
+// implements fs.ReadDirFS and fs.StatFS. Files may be seeked, so the
+// result can be served with http.FS. Modification times are the time of
+// t's commit. Submodules are listed, but cannot be opened. Entries whose
+// names are not UTF-8, which paths in an fs.FS must be, are not listed.
+func TreeFS(t *Tree) fs.FS {
+	return &treeFS{root: t}
+}
+
+type treeFS struct {
+	root *Tree
+}
+
+func (f *treeFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return &fsDir{fs: f, tree: f.root, info: f.dirInfo(".")}, nil
+	}
+	e, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case e.Mode.IsDir():
+		t, err := e.Subtree()
+		if err != nil {
+			return nil, err
+		}
+		return &fsDir{fs: f, tree: t, info: f.entryInfo(e, 0)}, nil
+	case e.Mode&fs.ModeIrregular != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	default:
+		b, err := e.Blob()
+		if err != nil {
+			return nil, err
+		}
+		return &fsFile{Blob: b, info: f.entryInfo(e, b.Size)}, nil
+	}
+}
+
+func (f *treeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	t := f.root
+	if name != "." {
+		e, err := f.lookup("readdir", name)
+		if err != nil {
+			return nil, err
+		}
+		if !e.Mode.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+		if t, err = e.Subtree(); err != nil {
+			return nil, err
+		}
+	}
+	return f.readDir(t), nil
+}
+
+func (f *treeFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return f.dirInfo("."), nil
+	}
+	e, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.info(e)
+}
+
+// lookup returns the Entry for name, reporting errors as an fs.PathError
+// for op.
+func (f *treeFS) lookup(op, name string) (*Entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, err := f.root.Lookup(name)
+	if IsNotExist(err) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, err
+}
+
+// readDir returns the entries of t, sorted by name.
+func (f *treeFS) readDir(t *Tree) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(t.Entries))
+	for i := range t.Entries {
+		if !utf8.ValidString(t.Entries[i].Name) {
+			continue
+		}
+		entries = append(entries, &fsDirEntry{fs: f, e: &t.Entries[i]})
+	}
+	// git orders the entries of a tree as if directory names ended in a
+	// slash, but fs.ReadDirFS requires them sorted by name.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// info returns the fs.FileInfo of e, reading the size of a blob from its
+// object header.
+func (f *treeFS) info(e *Entry) (fs.FileInfo, error) {
+	if !e.Mode.IsRegular() && e.Mode&fs.ModeSymlink == 0 {
+		return f.entryInfo(e, 0), nil
+	}
+	b, err := e.Blob()
+	if err != nil {
+		return nil, err
+	}
+	b.Close()
+	return f.entryInfo(e, b.Size), nil
+}
+
+func (f *treeFS) entryInfo(e *Entry, size int64) *fileInfo {
+	return &fileInfo{name: e.Name, size: size, mode: e.Mode, modTime: f.root.Time}
+}
+
+func (f *treeFS) dirInfo(name string) *fileInfo {
+	return &fileInfo{name: name, mode: fs.ModeDir | 0755, modTime: f.root.Time}
+}
+
+// fileInfo is the fs.FileInfo of an entry in a tree.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// fsDirEntry is the fs.DirEntry of an entry in a tree. The size of a
+// blob is only read if Info is called.
+type fsDirEntry struct {
+	fs *treeFS
+	e  *Entry
+}
+
+func (d *fsDirEntry) Name() string               { return d.e.Name }
+func (d *fsDirEntry) IsDir() bool                { return d.e.Mode.IsDir() }
+func (d *fsDirEntry) Type() fs.FileMode          { return d.e.Mode.Type() }
+func (d *fsDirEntry) Info() (fs.FileInfo, error) { return d.fs.info(d.e) }
+
+// fsFile is an open blob.
+type fsFile struct {
+	*Blob
+	info *fileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// fsDir is an open tree.
+type fsDir struct {
+	fs      *treeFS
+	tree    *Tree
+	info    *fileInfo
+	entries []fs.DirEntry // nil until the first call to ReadDir
+	off     int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile. If n > 0 it returns at most n
+// entries, and io.EOF once there are none left. Otherwise it returns all
+// the remaining entries.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = d.fs.readDir(d.tree)
+	}
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+	return rest[:n], nil
+}
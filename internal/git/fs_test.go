@@ -0,0 +1,63 @@
+package git
+
+import (
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf8"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// TestTreeFS checks the tree of a commit is walked by fs.WalkDir as git
+// lists it, and behaves as an fs.FS should.
+func TestTreeFS(t *testing.T) {
+	dir := fixture(t, gittest.Packed)
+	r := openFixture(t, gittest.Packed)
+	defer r.Close()
+	sha, err := r.ResolveRev("master^{commit}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Commit(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := c.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := TreeFS(root)
+
+	// every path git lists is walked, in order.
+	var got []string
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != "." {
+			got = append(got, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, p := range strings.Split(strings.TrimSuffix(gitOutput(t, dir, "ls-tree", "-r", "-t", "-z", "--name-only", "master"), "\x00"), "\x00") {
+		// io/fs cannot name paths which are not UTF-8.
+		if utf8.ValidString(p) {
+			want = append(want, p)
+		}
+	}
+	sort.Strings(want)
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("WalkDir: got %q, want %q", got, want)
+	}
+
+	if err := fstest.TestFS(fsys, "a.txt", "empty", "d/big.txt", "link", "with space.txt"); err != nil {
+		t.Error(err)
+	}
+}
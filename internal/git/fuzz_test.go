@@ -0,0 +1,80 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The fuzz targets below check only that the parsers return an error,
+// rather than panicking or reading out of bounds, on malformed input, and
+// that what they accept is well formed. Inputs which once crashed them are
+// kept in testdata/fuzz, and run as regression tests by go test.
+
+func FuzzScanTreeEntry(f *testing.F) {
+	f.Add([]byte("100644 a\x00aaaaaaaaaaaaaaaaaaaa"), true)
+	f.Add([]byte("100644 a\x00aaaa"), false)
+	f.Add([]byte("\x00"), true)
+	f.Fuzz(func(t *testing.T, data []byte, atEOF bool) {
+		advance, token, err := scanTreeEntry(data, atEOF)
+		if err != nil {
+			return
+		}
+		if advance < 0 || advance > len(data) || !bytes.Equal(token, data[:advance]) {
+			t.Fatalf("scanTreeEntry(%q, %v): advance %d, token %q", data, atEOF, advance, token)
+		}
+		if advance > 0 && (advance < 21 || token[advance-21] != 0) {
+			t.Fatalf("scanTreeEntry(%q, %v): token %q is not a record", data, atEOF, token)
+		}
+	})
+}
+
+func FuzzParseTree(f *testing.F) {
+	f.Add([]byte("100644 a\x00aaaaaaaaaaaaaaaaaaaa40000 d\x00bbbbbbbbbbbbbbbbbbbb"))
+	f.Add([]byte("120000 link\x00cccccccccccccccccccc160000 sub\x00dddddddddddddddddddd"))
+	f.Add([]byte("100644 \x00"))
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		tree, err := (&Tree{}).parseTree(buf)
+		if err != nil {
+			return
+		}
+		for _, e := range tree.Entries {
+			if e.Name == "" || strings.IndexByte(e.Name, 0) >= 0 || len(e.id) != 40 {
+				t.Fatalf("parseTree(%q): malformed entry %q %s", buf, e.Name, e.id)
+			}
+		}
+	})
+}
+
+func FuzzParseCommit(f *testing.F) {
+	f.Add([]byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904b\nparent 4b825dc642cb6eb9a060e54bf8d69288fbe4904b\nauthor A <a@example.com> 1112911993 +0000\ncommitter C <c@example.com> 1112911993 -0700\n\nsummary\n\nbody\n"))
+	f.Add([]byte("committer >\n"))
+	f.Add([]byte("committer A <a> 99999999999999999999 +0100\n"))
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		(&Commit{}).parseCommit(bytes.NewReader(buf), int64(len(buf)))
+	})
+}
+
+func FuzzReadHeader(f *testing.F) {
+	f.Add([]byte("blob 12\x00content"))
+	f.Add([]byte("tree 0\x00"))
+	f.Add([]byte("commit 9223372036854775807\x00"))
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		r := bytes.NewReader(buf)
+		h, err := readHeader(r)
+		if err != nil {
+			return
+		}
+		switch h.Kind {
+		case "blob", "tree", "commit", "tag":
+		default:
+			t.Fatalf("readHeader(%q): kind %q", buf, h.Kind)
+		}
+		if h.Length < 0 {
+			t.Fatalf("readHeader(%q): negative length %d", buf, h.Length)
+		}
+		if n := len(buf) - r.Len(); buf[n-1] != 0 || bytes.IndexByte(buf[:n-1], 0) >= 0 {
+			t.Fatalf("readHeader(%q): read %d bytes, not up to the first NUL", buf, n)
+		}
+	})
+}
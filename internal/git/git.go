@@ -5,11 +5,19 @@ import (
 	"bufio"
 	"bytes"
 	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -19,12 +27,56 @@ type Repository struct {
 
 	// Root is the base path to the repository
 	Root string
+
+	// UseReplaceRefs, if set, as it is by default, reads the object
+	// named by refs/replace/<id> whenever the object id is read, as git
+	// does for history grafted with git replace. Objects are copied into
+	// packs and read by ReadRaw as they are stored, without replacement.
+	UseReplaceRefs bool
+
+	gitdir    string // HEAD and per worktree refs, see gitDir
+	commondir string // objects and shared refs, see commonDir
+	bare      bool   // no working tree, see Bare
+
+	dirsOnce sync.Once
+	dirs     []string
+
+	storeOnce sync.Once
+	store     ObjectStore
+
+	ignoreCaseOnce sync.Once
+	ignoreCase     bool
+
+	treesMu sync.RWMutex
+	trees   map[string]*Tree // parsed trees by id, once enabled by Preload
+
+	maxDeltaMemory int64 // accessed atomically, see SetMaxDeltaMemory
+
+	refResolver atomic.Value // holds a refResolver, see SetRefResolver
+
+	warnMu sync.Mutex
+	warn   func(format string, args ...interface{}) // see SetWarnFunc
+	warned map[string]bool                          // keys of warnings reported
+
+	replaceOnce sync.Once
+	replace     map[string]string // replaced ids to their replacements, see replacement
+	replaceErr  error
+
+	cache       *CacheGroup // if not nil, see SetCacheGroup
+	cacheFormat string      // the object format, keying objects in cache
+
+	closed bool
 }
 
 // Open returns a Repository representing the git repository
 // that contains path. Open walks up the directory heirarchy
 // until it finds a path with a .git, or it hits the root of
-// the file system.
+// the file system. The .git may be a directory, or a file naming
+// the git directory of a linked worktree. The Root of the
+// Repository is the directory where the .git was found, unless
+// core.worktree names another. A git directory found on the way,
+// such as one kept apart from its working tree, is opened as by
+// OpenGitDir.
 func Open(p string) (*Repository, error) {
 	path, err := filepath.Abs(p)
 	if err != nil {
@@ -32,17 +84,8 @@ func Open(p string) (*Repository, error) {
 	}
 
 	for path != string(filepath.Separator) {
-		gitdir := filepath.Join(path, ".git")
-		if fi, err := os.Stat(gitdir); err != nil {
-			if !os.IsNotExist(err) {
-				return nil, errors.WithStack(err)
-			}
-		} else {
-			if fi.IsDir() {
-				return &Repository{
-					Root: path,
-				}, nil
-			}
+		if r, ok, err := openAt(path); ok || err != nil {
+			return r, err
 		}
 		path = filepath.Dir(path)
 	}
@@ -50,6 +93,66 @@ func Open(p string) (*Repository, error) {
 	return nil, errors.Errorf("could not locate git repository for path %q", path)
 }
 
+// OpenStrict is like Open, but path must itself be the root of the
+// repository, the directory holding the .git, or a git directory; the
+// directories above it are not searched.
+func OpenStrict(p string) (*Repository, error) {
+	path, err := filepath.Abs(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert path %q to an absolute path", p)
+	}
+	r, ok, err := openAt(path)
+	if !ok && err == nil {
+		err = errors.Errorf("%q is not the root of a git repository", path)
+	}
+	return r, err
+}
+
+// openAt opens the repository whose root is path, reporting false if
+// path has no .git. If path is itself a git directory, the repository is
+// opened as by OpenGitDir.
+func openAt(path string) (*Repository, bool, error) {
+	gitdir := filepath.Join(path, ".git")
+	fi, err := os.Stat(gitdir)
+	switch {
+	case os.IsNotExist(err):
+		if isGitDir(path) {
+			r, err := OpenGitDir(path)
+			return r, true, err
+		}
+		return nil, false, nil
+	case err != nil:
+		return nil, false, errors.WithStack(err)
+	case fi.IsDir():
+		r, err := openGitDir(path, gitdir)
+		return r, true, err
+	case fi.Mode().IsRegular():
+		// a linked worktree, .git names the real git directory.
+		dir, err := readGitFile(gitdir)
+		if err != nil {
+			return nil, true, err
+		}
+		r, err := openGitDir(path, dir)
+		return r, true, err
+	}
+	return nil, false, nil
+}
+
+// Close releases the resources held by the repository, including open
+// pack files. The Repository, and any Commit, Tree, or Blob read from it,
+// must not be used after Close.
+func (r *Repository) Close() error {
+	var err error
+	if c, ok := r.store.(io.Closer); ok {
+		err = c.Close()
+	}
+	r.store = nil
+	r.dirs = nil
+	r.trees = nil
+	r.closed = true
+	return err
+}
+
 // Tree represents a tree object.
 type Tree struct {
 	*Commit
@@ -64,6 +167,279 @@ type Tree struct {
 type Blob struct {
 	Size int64
 	io.ReadCloser
+
+	// id is the SHA1 of this blob
+	id string
+
+	r   *Repository
+	pos int64 // offset of the next Read, as set by Seek
+	off int64 // offset of the next Read from ReadCloser
+
+	// at holds the state of ReadAt, which is independent of that of
+	// Read and Seek.
+	at struct {
+		sync.Mutex
+		buf []byte        // the whole content, if at most maxReadAtBuffer bytes
+		rc  io.ReadCloser // otherwise, the content from off
+		off int64
+	}
+}
+
+// maxReadAtBuffer is the size of the largest blob whose content ReadAt
+// holds in memory.
+const maxReadAtBuffer = 1 << 20
+
+// ID returns the SHA1 of this blob.
+func (b *Blob) ID() string { return b.id }
+
+// Read reads from the blob's content at the offset set by Seek.
+func (b *Blob) Read(p []byte) (int, error) {
+	if b.pos != b.off {
+		if err := b.reposition(); err != nil {
+			return 0, objectError("blob", b.id, err)
+		}
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.pos += int64(n)
+	b.off += int64(n)
+	if err != nil && err != io.EOF {
+		err = objectError("blob", b.id, err)
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker. As blobs are compressed seeking is emulated;
+// the next Read discards content to seek forwards, or reads the blob again
+// from the start to seek backwards. Seeking relative to the end costs
+// nothing as the size of the blob is known.
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += b.Size
+	default:
+		return 0, errors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.Errorf("negative position %d", offset)
+	}
+	b.pos = offset
+	return offset, nil
+}
+
+// reposition moves the ReadCloser to the offset set by Seek.
+func (b *Blob) reposition() error {
+	if b.pos < b.off {
+		_, rc, err := b.r.readObject(b.id)
+		if err != nil {
+			return err
+		}
+		b.ReadCloser.Close()
+		b.ReadCloser = rc
+		b.off = 0
+	}
+	n, err := io.CopyN(ioutil.Discard, b.ReadCloser, b.pos-b.off)
+	b.off += n
+	if err == io.EOF {
+		// seeking beyond the end is permitted, the next Read
+		// will return io.EOF.
+		err = nil
+	}
+	return errors.WithStack(err)
+}
+
+// ReadAt implements io.ReaderAt, reading from the blob's content at off
+// without moving the offset of Read and Seek. Calls may be made
+// concurrently, but are served one at a time.
+//
+// As blobs are compressed, random access is emulated. The content of a
+// blob of at most 1MB is read in full by the first call and kept until the
+// blob is closed, so later calls cost a copy. Larger blobs are read by a
+// second reader, positioned after the last byte read, so calls at
+// increasing offsets, as when serving several ranges in order, read the
+// content once; a call at an offset before that reads the blob again from
+// the start.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("negative offset %d", off)
+	}
+	b.at.Lock()
+	defer b.at.Unlock()
+	if b.Size <= maxReadAtBuffer {
+		if b.at.buf == nil {
+			buf, err := b.readAll()
+			if err != nil {
+				return 0, objectError("blob", b.id, err)
+			}
+			b.at.buf = buf
+		}
+		if off >= int64(len(b.at.buf)) {
+			return 0, io.EOF
+		}
+		n := copy(p, b.at.buf[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	if b.at.rc == nil || off < b.at.off {
+		_, rc, err := b.r.readObject(b.id)
+		if err != nil {
+			return 0, objectError("blob", b.id, err)
+		}
+		if b.at.rc != nil {
+			b.at.rc.Close()
+		}
+		b.at.rc, b.at.off = rc, 0
+	}
+	skipped, err := io.CopyN(ioutil.Discard, b.at.rc, off-b.at.off)
+	b.at.off += skipped
+	if err == io.EOF {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, objectError("blob", b.id, err)
+	}
+	n, err := io.ReadFull(b.at.rc, p)
+	b.at.off += int64(n)
+	switch err {
+	case nil:
+		return n, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return n, io.EOF
+	default:
+		return n, objectError("blob", b.id, err)
+	}
+}
+
+// readAll returns the whole content of the blob, read by a new reader.
+func (b *Blob) readAll() ([]byte, error) {
+	_, rc, err := b.r.readObject(b.id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	buf := make([]byte, b.Size)
+	_, err = io.ReadFull(rc, buf)
+	return buf, errors.WithStack(err)
+}
+
+// Close closes the blob, and releases the state of ReadAt.
+func (b *Blob) Close() error {
+	b.at.Lock()
+	if b.at.rc != nil {
+		b.at.rc.Close()
+		b.at.rc = nil
+	}
+	b.at.buf = nil
+	b.at.Unlock()
+	return b.ReadCloser.Close()
+}
+
+// ID returns the SHA1 of this tree.
+func (t *Tree) ID() string { return t.id }
+
+// Lookup returns the Entry for the slash separated path p, relative to this tree.
+// Trees below this one are scanned only as far as the matching entry, rather
+// than being parsed in full. The parent Tree of the returned Entry may
+// therefore have no Entries; use the Entry's Blob and Subtree methods to
+// read the object it refers to.
+//
+// If the repository has core.ignorecase set, a name with no exact match
+// matches an entry whose name differs only in case.
+func (t *Tree) Lookup(p string) (*Entry, error) {
+	names := strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/")
+	fold := t.IgnoreCase()
+	e := t.entry(names[0], fold)
+	for _, name := range names[1:] {
+		if e == nil || !e.Mode.IsDir() {
+			// a path below a file, symlink, or submodule does not
+			// exist, and the object need not be read to know it.
+			e = nil
+			break
+		}
+		var err error
+		e, err = t.scanTree(e.id, name, fold)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if e == nil {
+		return nil, &os.PathError{
+			Op:   "open",
+			Path: p,
+			Err:  os.ErrNotExist,
+		}
+	}
+	return e, nil
+}
+
+// Exists reports whether the slash separated path p, relative to this
+// tree, exists, and if so the mode of its entry. The mode distinguishes
+// directories, which have os.ModeDir set, from files, symlinks, and
+// submodules. The path is looked up as by Lookup, so only the trees
+// holding it are read, and no blob is inflated. The empty path, and "/",
+// name this tree. A path which does not exist is not an error; err is
+// only set if a tree could not be read.
+func (t *Tree) Exists(p string) (ok bool, mode os.FileMode, err error) {
+	if strings.Trim(p, "/") == "" {
+		return true, os.ModeDir | 0755, nil
+	}
+	e, err := t.Lookup(p)
+	switch {
+	case IsNotExist(err):
+		return false, 0, nil
+	case err != nil:
+		return false, 0, err
+	}
+	return true, e.Mode, nil
+}
+
+// Walk calls fn for each entry below this tree, with the entry's slash
+// separated path relative to this tree. Entries are visited in tree order,
+// and a directory is visited before the entries it contains. Walk stops at,
+// and returns, the first error returned by fn or from reading a subtree.
+func (t *Tree) Walk(fn func(p string, e *Entry) error) error {
+	return t.walk("", fn)
+}
+
+func (t *Tree) walk(prefix string, fn func(string, *Entry) error) error {
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		p := path.Join(prefix, e.Name)
+		if err := fn(p, e); err != nil {
+			return err
+		}
+		if !e.Mode.IsDir() {
+			continue
+		}
+		sub, err := e.Subtree()
+		if err != nil {
+			return err
+		}
+		if err := sub.walk(p, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry returns the Entry called name in this tree, or nil if
+// there is no such entry.
+func (t *Tree) entry(name string, fold bool) *Entry {
+	var match *Entry
+	for i := range t.Entries {
+		switch e := &t.Entries[i]; {
+		case name == e.Name:
+			return e
+		case fold && match == nil && strings.EqualFold(name, e.Name):
+			match = e
+		}
+	}
+	return match
 }
 
 // Blob is a convenience method for returning a git blob object that is a child of the current tree.
@@ -95,17 +471,20 @@ func (t *Tree) Tree(name string) (*Tree, error) {
 }
 
 // readBlob returns a git blob object.
-func (t *Tree) readBlob(sha string) (*Blob, error) {
-	h, rc, err := t.readObject(sha)
+func (r *Repository) readBlob(sha string) (*Blob, error) {
+	h, rc, err := r.readObject(sha)
 	if err != nil {
-		return nil, err
+		return nil, objectError("blob", sha, err)
 	}
-	if h.kind != "blob" {
-		return nil, errors.Errorf("expected blob, got %q", h.kind)
+	if h.Kind != "blob" {
+		rc.Close()
+		return nil, objectError("blob", sha, errors.Errorf("expected blob, got %q", h.Kind))
 	}
 	return &Blob{
-		Size:       h.length,
+		Size:       h.Length,
 		ReadCloser: rc,
+		id:         sha,
+		r:          r,
 	}, nil
 }
 
@@ -117,13 +496,53 @@ type Entry struct {
 	id   string
 }
 
+// ID returns the SHA1 of the object this entry refers to.
+func (e *Entry) ID() string { return e.id }
+
+// Blob returns the git blob object this entry refers to.
+func (e *Entry) Blob() (*Blob, error) { return e.readBlob(e.id) }
+
+// Subtree returns the git tree object this entry refers to.
+func (e *Entry) Subtree() (*Tree, error) { return e.readTree(e.id) }
+
+// GitMode returns the mode of the entry in the canonical form git lists
+// it, as by git ls-tree: 040000 for a tree, 100644 or 100755 for a file,
+// 120000 for a symlink, or 160000 for a submodule.
+func (e *Entry) GitMode() uint32 {
+	switch {
+	case e.Mode.IsDir():
+		return 0040000
+	case e.Mode&os.ModeSymlink != 0:
+		return 0120000
+	case e.Mode&os.ModeIrregular != 0:
+		return 0160000
+	case e.Mode&0111 != 0:
+		return 0100755
+	default:
+		return 0100644
+	}
+}
+
+// Kind returns the kind of object the entry refers to: tree, blob, or
+// for a submodule, commit.
+func (e *Entry) Kind() string {
+	switch {
+	case e.Mode.IsDir():
+		return "tree"
+	case e.Mode&os.ModeIrregular != 0:
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
 func scanTreeEntry(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
 	}
 
-	recordLength := bytes.IndexByte(data, '\x00') + 21
-	if recordLength <= len(data) {
+	i := bytes.IndexByte(data, '\x00')
+	if recordLength := i + 21; i >= 0 && recordLength <= len(data) {
 		return recordLength, data[:recordLength], nil
 	}
 
@@ -133,36 +552,155 @@ func scanTreeEntry(data []byte, atEOF bool) (advance int, token []byte, err erro
 	return 0, nil, nil
 }
 
-// parseTree parses a tree object from the supplied io.Reader.
-func (t *Tree) parseTree(r io.Reader) (*Tree, error) {
-	sc := bufio.NewScanner(r)
-	sc.Split(scanTreeEntry)
-	for sc.Scan() {
-		buf := sc.Bytes()
-		buf, sha := buf[:len(buf)-21], buf[len(buf)-20:]
-		var name string
-		var mode os.FileMode
-		if _, err := fmt.Fscanf(bytes.NewReader(buf), "%d %s", &mode, &name); err != nil {
-			return nil, errors.Wrap(err, "could not read tree entry")
-		}
+// treeBuffers holds buffers for reuse by readTree, which reads each tree
+// object whole before parsing it.
+var treeBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
 
-		// TODO(dfc)
-		// if the blob is _not_ present on disk (ie, it's in a pack file)
-		// then do not return it in the entries set.
-		// Obviously we need to implement pack support, but yolo
-		path := filepath.Join(t.Root, ".git", "objects", string(sha)[0:2], string(sha)[2:])
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			//	continue
+// maxPooledTree is the capacity of the largest buffer kept in
+// treeBuffers.
+const maxPooledTree = 1 << 20
+
+// parseTree parses the entries of a tree object from its content, buf.
+// The entries do not refer to buf, which may be reused. Duplicate names
+// are an error. Entries out of the order git writes them in, sorted by
+// name, with the names of trees followed by a slash, are kept in the
+// order found, with a warning, see SetWarnFunc.
+func (t *Tree) parseTree(buf []byte) (*Tree, error) {
+	seen := make(map[string]bool)
+	var prev string // the sort key of the previous entry
+	for len(buf) > 0 {
+		n, _, err := scanTreeEntry(buf, true)
+		if err != nil {
+			return nil, err
+		}
+		name, mode, sha, err := parseEntry(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[n:]
+		if seen[name] {
+			// git never writes duplicate names, so the tree is malformed.
+			return nil, errors.Errorf("duplicate entry %q", name)
 		}
+		seen[name] = true
+		key := name
+		if mode.IsDir() {
+			key += "/"
+		}
+		if key < prev && t.Commit != nil && t.Repository != nil {
+			t.warnf("unordered tree "+t.id, "tree %s: entry %q is out of order, after %q", t.id, name, strings.TrimSuffix(prev, "/"))
+		}
+		prev = key
 
 		t.Entries = append(t.Entries, Entry{
 			Tree: t,
 			Name: name,
 			Mode: mode,
-			id:   fmt.Sprintf("%x", string(sha)),
+			id:   sha,
 		})
 	}
-	return t, sc.Err()
+	return t, nil
+}
+
+// minEntryLength is the length of the shortest tree entry record, a one
+// digit mode, a space, a one byte name, a NUL, and a 20 byte id.
+const minEntryLength = 1 + 1 + 1 + 1 + 20
+
+// parseEntry parses a single tree entry record, "<mode> <name>\x00"
+// followed by a 20 byte id, returning its name, mode, and id.
+func parseEntry(buf []byte) (string, os.FileMode, string, error) {
+	if len(buf) < minEntryLength || buf[len(buf)-21] != 0 {
+		// scanTreeEntry returns no shorter record, but parseEntry must
+		// not rely on its caller to slice safely.
+		return "", 0, "", errors.Errorf("malformed tree entry %q", buf)
+	}
+	buf, sha := buf[:len(buf)-21], buf[len(buf)-20:]
+	i := bytes.IndexByte(buf, ' ')
+	if i < 1 || i == len(buf)-1 {
+		return "", 0, "", errors.Errorf("could not read tree entry %q", buf)
+	}
+	mode, err := strconv.ParseUint(string(buf[:i]), 8, 32)
+	if err != nil {
+		return "", 0, "", errors.Wrap(err, "could not read tree entry")
+	}
+	return string(buf[i+1:]), FileMode(uint32(mode)), fmt.Sprintf("%x", sha), nil
+}
+
+// FileMode converts the octal mode of a git tree entry, as returned by
+// Entry.GitMode, to an os.FileMode, as the Mode of an Entry. Submodules,
+// which refer to a commit in another repository, are reported as
+// irregular files.
+func FileMode(mode uint32) os.FileMode {
+	switch mode & 0170000 {
+	case 0040000:
+		return os.ModeDir | 0755
+	case 0120000:
+		return os.ModeSymlink | 0777
+	case 0160000:
+		return os.ModeIrregular
+	default:
+		if mode&0111 != 0 {
+			return 0755
+		}
+		return 0644
+	}
+}
+
+// scanTree returns the entry called name in the tree object sha, or nil
+// if there is no such entry, or sha is not a tree. The object is read
+// only as far as the matching entry. If fold is set and there is no
+// exact match, the first entry whose name matches ignoring case is
+// returned.
+func (c *Commit) scanTree(sha, name string, fold bool) (*Entry, error) {
+	if t := c.cachedTree(sha); t != nil {
+		e := t.entry(name, fold)
+		if e == nil {
+			return nil, nil
+		}
+		// as by readTree, the entry's parent refers to c.
+		match := *e
+		match.Tree = &Tree{Commit: c, id: sha}
+		return &match, nil
+	}
+	h, rc, err := c.readObject(sha)
+	if err != nil {
+		return nil, objectError("tree", sha, err)
+	}
+	defer rc.Close()
+	if h.Kind != "tree" {
+		return nil, nil
+	}
+	sc := bufio.NewScanner(rc)
+	// no record is longer than the object, which may be longer than the
+	// default limit of a bufio.Scanner.
+	sc.Buffer(nil, int(h.Length)+1)
+	sc.Split(scanTreeEntry)
+	var match *Entry
+	for sc.Scan() {
+		n, mode, id, err := parseEntry(sc.Bytes())
+		if err != nil {
+			return nil, objectError("tree", sha, err)
+		}
+		if n != name && (!fold || match != nil || !strings.EqualFold(n, name)) {
+			continue
+		}
+		e := &Entry{
+			Tree: &Tree{
+				Commit: c,
+				id:     sha,
+			},
+			Name: n,
+			Mode: mode,
+			id:   id,
+		}
+		if n == name {
+			return e, nil
+		}
+		match = e
+	}
+	return match, objectError("tree", sha, sc.Err())
 }
 
 // Commit represents a commit object.
@@ -174,13 +712,35 @@ type Commit struct {
 
 	// id is the SHA1 of this commit
 	id string
+
+	// Parents are the ids of this commit's parents, first parent first.
+	Parents []string
+
+	// Time is the time the commit was made, as recorded by the committer.
+	Time time.Time
+
+	// Message is the commit message.
+	Message string
+}
+
+// Summary returns the first line of the commit message.
+func (c *Commit) Summary() string {
+	if i := strings.Index(c.Message, "\n"); i >= 0 {
+		return c.Message[:i]
+	}
+	return c.Message
 }
 
 func (c *Commit) String() string { return c.id }
 
-// Tree returns the Tree object for this commit.
+// Tree returns the Tree object for this commit. If the tree is missing
+// from the repository, the error is a *MissingTreeError.
 func (c *Commit) Tree() (*Tree, error) {
-	return c.readTree(c.tree)
+	t, err := c.readTree(c.tree)
+	if IsNotExist(err) {
+		return nil, &MissingTreeError{Commit: c.id, Tree: c.tree, Err: err}
+	}
+	return t, err
 }
 
 // Commit returns a Commit matching the supplied id.
@@ -188,29 +748,111 @@ func (r *Repository) Commit(sha string) (*Commit, error) {
 	return r.readCommit(sha)
 }
 
+// Blob returns a Blob matching the supplied id.
+func (r *Repository) Blob(sha string) (*Blob, error) {
+	return r.readBlob(sha)
+}
+
+// Tree returns a Tree matching the supplied id. The tree is not read via
+// a commit, so its Commit has an empty id, no parents, and a zero Time.
+func (r *Repository) Tree(sha string) (*Tree, error) {
+	c := Commit{Repository: r}
+	return c.readTree(sha)
+}
+
+// BlobTree returns a Tree holding only the blob sha, called name, so a
+// blob may be served where a tree is expected, as when an annotated tag
+// points at a blob. The tree is not in the repository, but its id is the
+// one git would give it. As with Tree, its Commit has an empty id.
+func (r *Repository) BlobTree(name, sha string) (*Tree, error) {
+	if name == "" || strings.ContainsAny(name, "/\x00") {
+		return nil, errors.Errorf("invalid file name %q", name)
+	}
+	id, err := hex.DecodeString(sha)
+	if err != nil || len(id) != 20 {
+		return nil, errors.Errorf("invalid object id %q", sha)
+	}
+	kind, err := r.Type(sha)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "blob" {
+		return nil, objectError("blob", sha, errors.Errorf("expected blob, got %q", kind))
+	}
+	entry := append([]byte("100644 "+name+"\x00"), id...)
+	h := sha1.New()
+	fmt.Fprintf(h, "tree %d\x00", len(entry))
+	h.Write(entry)
+	t := &Tree{
+		Commit: &Commit{Repository: r},
+		id:     hex.EncodeToString(h.Sum(nil)),
+	}
+	t.Entries = []Entry{{Tree: t, Name: name, Mode: 0644, id: sha}}
+	return t, nil
+}
+
+// Type returns the type of the object matching the supplied id; commit,
+// tree, blob, or tag. Only the object's header is read.
+func (r *Repository) Type(sha string) (string, error) {
+	h, rc, err := r.readObject(sha)
+	if err != nil {
+		return "", objectError("object", sha, err)
+	}
+	rc.Close()
+	return h.Kind, nil
+}
+
+// ObjectReader returns the kind, commit, tree, blob, or tag, the length,
+// and the content of the object sha, wherever it is stored: loose, in a
+// pack, or in an alternate object directory. Deltified objects are
+// reconstructed, subject to SetMaxDeltaMemory. The content is read as it
+// is inflated, so objects of any size may be streamed.
+//
+// The caller must close rc, and may do so before reading it all. rc
+// returns an error if the object is shorter or longer than its recorded
+// length, or is corrupt. rc must not be used once the Repository is
+// closed.
+func (r *Repository) ObjectReader(sha string) (kind string, size int64, rc io.ReadCloser, err error) {
+	h, rc, err := r.readObject(strings.ToLower(sha))
+	if err != nil {
+		return "", 0, nil, objectError("object", sha, err)
+	}
+	return h.Kind, h.Length, rc, nil
+}
+
 // readCommit reads a commit object.
 func (r *Repository) readCommit(sha string) (*Commit, error) {
 	h, rc, err := r.readObject(sha)
 	if err != nil {
-		return nil, err
+		return nil, objectError("commit", sha, err)
 	}
 	defer rc.Close()
-	if h.kind != "commit" {
-		return nil, errors.Errorf("expected commit, got %q", h.kind)
+	if h.Kind != "commit" {
+		return nil, objectError("commit", sha, errors.Errorf("expected commit, got %q", h.Kind))
 	}
 	c := Commit{
 		Repository: r,
 		id:         sha,
 	}
-
-	return c.parseCommit(rc)
+	if _, err := c.parseCommit(rc, h.Length); err != nil {
+		return nil, objectError("commit", sha, err)
+	}
+	return &c, nil
 }
 
-// parseCommit parses a commit object from the supplied io.Reader.
-func (c *Commit) parseCommit(r io.Reader) (*Commit, error) {
+// parseCommit parses a commit object of length bytes from the supplied
+// io.Reader.
+func (c *Commit) parseCommit(r io.Reader, length int64) (*Commit, error) {
 	sc := bufio.NewScanner(r)
+	// no line is longer than the object, and a message may hold lines
+	// longer than the default limit of a bufio.Scanner.
+	sc.Buffer(nil, int(length)+1)
 	for sc.Scan() {
 		s := sc.Text()
+		if s == "" {
+			// end of headers, the message follows.
+			break
+		}
 		i := strings.Index(s, " ")
 		if i < 0 {
 			// ignore this line
@@ -219,61 +861,232 @@ func (c *Commit) parseCommit(r io.Reader) (*Commit, error) {
 		switch s[:i] {
 		case "tree":
 			c.tree = strings.TrimSpace(s[len("tree "):])
+		case "parent":
+			c.Parents = append(c.Parents, strings.TrimSpace(s[len("parent "):]))
+		case "committer":
+			c.Time = parseSignatureTime(s[len("committer "):])
 		}
 	}
+	var msg []string
+	for sc.Scan() {
+		msg = append(msg, sc.Text())
+	}
+	if len(msg) > 0 {
+		c.Message = strings.Join(msg, "\n") + "\n"
+	}
 	return c, sc.Err()
 }
 
-// header is a git header.
-type header struct {
-	kind   string
-	length int64
+// parseSignatureTime returns the time recorded in the identity line of a
+// commit or tag, "Name <email> <unix seconds> <+hhmm>", or the zero time
+// if it cannot be parsed.
+func parseSignatureTime(s string) time.Time {
+	fields := strings.Fields(s[strings.LastIndex(s, ">")+1:])
+	if len(fields) != 2 {
+		return time.Time{}
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	tz, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return time.Unix(secs, 0).UTC()
+	}
+	if tz < 0 {
+		tz = -(-tz/100*3600 + -tz%100*60)
+	} else {
+		tz = tz/100*3600 + tz%100*60
+	}
+	return time.Unix(secs, 0).In(time.FixedZone(fields[1], tz))
 }
 
-// readObject returns a header and an io.ReadCloser for a git object.
-func (r *Repository) readObject(sha string) (header, io.ReadCloser, error) {
-	path := filepath.Join(r.Root, ".git", "objects", sha[0:2], sha[2:])
-	f, err := os.Open(path)
-	if err != nil {
-		return header{}, nil, errors.WithStack(err)
+// Header describes a git object: its kind, one of blob, tree, commit,
+// or tag, and the length of its content.
+type Header struct {
+	Kind   string
+	Length int64
+}
+
+// readObject returns a header and an io.ReadCloser for a git object, or
+// for its replacement if it has one, see UseReplaceRefs. Loose objects
+// are preferred to packed objects.
+func (r *Repository) readObject(sha string) (Header, io.ReadCloser, error) {
+	if r.closed {
+		return Header{}, nil, errors.New("repository closed")
+	}
+	if !isObjectID(sha) {
+		return Header{}, nil, errors.Errorf("invalid object id %q", sha)
 	}
-	fr, err := zlib.NewReader(f)
+	id, err := r.replacement(sha)
 	if err != nil {
-		return header{}, nil, errors.WithStack(err)
+		return Header{}, nil, err
+	}
+	return r.readStored(id)
+}
+
+// readStored reads the object sha as it is stored, without following
+// replace refs.
+func (r *Repository) readStored(sha string) (Header, io.ReadCloser, error) {
+	if r.closed {
+		return Header{}, nil, errors.New("repository closed")
+	}
+	if !isObjectID(sha) {
+		return Header{}, nil, errors.Errorf("invalid object id %q", sha)
+	}
+	if r.cache != nil {
+		return r.readCached(sha)
+	}
+	return r.objects().Get(sha)
+}
+
+// Get implements ObjectStore, the Repository being the composite of its
+// object stores. Deltas name their bases by the ids they are stored
+// under, so replace refs are not followed.
+func (r *Repository) Get(sha string) (Header, io.ReadCloser, error) {
+	return r.readStored(sha)
+}
+
+// Has implements ObjectStore.
+func (r *Repository) Has(sha string) bool {
+	return r.Exists(sha)
+}
+
+// maxHeaderLength is the longest loose object header accepted, enough
+// for the longest kind and a 64 bit length.
+const maxHeaderLength = 32
+
+// readHeader reads a loose object header, "<kind> <length>\x00", from r.
+// r is read a byte at a time so no more than the header is consumed.
+func readHeader(r io.Reader) (Header, error) {
+	var buf [maxHeaderLength]byte
+	for n := range buf {
+		if _, err := io.ReadFull(r, buf[n:n+1]); err != nil {
+			return Header{}, errors.Wrap(err, "cannot parse header")
+		}
+		if buf[n] == 0 {
+			return parseHeader(buf[:n])
+		}
 	}
+	return Header{}, errors.Errorf("cannot parse header: no NUL in %q", buf[:])
+}
 
+// parseHeader parses a loose object header without its trailing NUL.
+func parseHeader(buf []byte) (Header, error) {
+	i := bytes.IndexByte(buf, ' ')
+	if i < 0 || i == len(buf)-1 {
+		return Header{}, errors.Errorf("cannot parse header %q", buf)
+	}
 	var kind string
+	switch string(buf[:i]) {
+	case "blob":
+		kind = "blob"
+	case "tree":
+		kind = "tree"
+	case "commit":
+		kind = "commit"
+	case "tag":
+		kind = "tag"
+	default:
+		return Header{}, errors.Errorf("cannot parse header %q: unknown object kind", buf)
+	}
 	var length int64
-	if _, err := fmt.Fscanf(fr, "%s %d\u0000", &kind, &length); err != nil {
-		return header{}, nil, errors.Wrap(err, "cannot parse header")
+	for _, c := range buf[i+1:] {
+		if c < '0' || c > '9' || length > (1<<63-1-int64(c-'0'))/10 {
+			return Header{}, errors.Errorf("cannot parse header %q: invalid length", buf)
+		}
+		length = length*10 + int64(c-'0')
+	}
+	return Header{
+		Kind:   kind,
+		Length: length,
+	}, nil
+}
+
+// zlibReaders holds zlib readers for reuse by newInflater.
+var zlibReaders sync.Pool
+
+// inflater is an io.ReadCloser that decompresses a loose object file. When
+// closed, it closes the file and returns its zlib reader to zlibReaders.
+type inflater struct {
+	zr io.ReadCloser
+	f  io.ReadCloser
+}
+
+// newInflater returns an inflater reading from f, reusing a pooled zlib
+// reader if one is available.
+func newInflater(f io.ReadCloser) (*inflater, error) {
+	if zr, ok := zlibReaders.Get().(io.ReadCloser); ok {
+		if err := zr.(zlib.Resetter).Reset(f, nil); err != nil {
+			return nil, err
+		}
+		return &inflater{zr: zr, f: f}, nil
+	}
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &inflater{zr: zr, f: f}, nil
+}
+
+func (in *inflater) Read(p []byte) (int, error) {
+	if in.zr == nil {
+		return 0, os.ErrClosed
 	}
+	return in.zr.Read(p)
+}
 
-	return header{
-			kind:   kind,
-			length: length,
-		}, struct {
-			io.Reader
-			io.Closer
-		}{
-			fr, // TODO(use a limit reader to clamp body size to length)
-			f,
-		}, nil
+func (in *inflater) Close() error {
+	if in.zr == nil {
+		return os.ErrClosed
+	}
+	in.zr.Close()
+	zlibReaders.Put(in.zr)
+	in.zr = nil
+	return in.f.Close()
 }
 
 // readTree reads a tree object.
 func (c *Commit) readTree(sha string) (*Tree, error) {
+	if cached := c.cachedTree(sha); cached != nil {
+		// the entries of the copy refer to it, and so to c, not to the
+		// cached tree and the commit it was first read through.
+		t := &Tree{Commit: c, id: cached.id, Entries: make([]Entry, len(cached.Entries))}
+		copy(t.Entries, cached.Entries)
+		for i := range t.Entries {
+			t.Entries[i].Tree = t
+		}
+		return t, nil
+	}
 	h, rc, err := c.readObject(sha)
 	if err != nil {
-		return nil, err
+		return nil, objectError("tree", sha, err)
 	}
 	defer rc.Close()
-	if h.kind != "tree" {
-		return nil, errors.Errorf("expected tree, got %q", h.kind)
+	if h.Kind != "tree" {
+		return nil, objectError("tree", sha, errors.Errorf("expected tree, got %q", h.Kind))
+	}
+	buf := treeBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		// do not keep the buffer of an unusually large tree.
+		if buf.Cap() <= maxPooledTree {
+			treeBuffers.Put(buf)
+		}
+	}()
+	buf.Reset()
+	if h.Length <= maxPooledTree {
+		buf.Grow(int(h.Length))
+	}
+	if _, err := buf.ReadFrom(rc); err != nil {
+		return nil, objectError("tree", sha, err)
 	}
 	t := Tree{
 		Commit: c,
 		id:     sha,
 	}
-
-	return t.parseTree(rc)
+	if _, err := t.parseTree(buf.Bytes()); err != nil {
+		return nil, objectError("tree", sha, err)
+	}
+	c.cacheTree(&t)
+	return &t, nil
 }
@@ -0,0 +1,825 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// fixtures are the repositories built by TestMain, by layout, or nil if
+// git is not installed.
+var fixtures map[string]string
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if !gittest.Installed() {
+		return m.Run()
+	}
+	dir, err := ioutil.TempDir("", "gitdav-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	fixtures = make(map[string]string)
+	for _, layout := range gittest.Layouts {
+		work, err := gittest.New(dir, layout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fixtures[layout] = work
+	}
+	return m.Run()
+}
+
+// fixture returns the path of the repository built by TestMain with the
+// given layout, skipping the test if git is not installed.
+func fixture(t testing.TB, layout string) string {
+	t.Helper()
+	if fixtures == nil {
+		t.Skip("git is not installed")
+	}
+	return fixtures[layout]
+}
+
+// openFixture opens the repository built by TestMain with the given
+// layout.
+func openFixture(t testing.TB, layout string) *Repository {
+	t.Helper()
+	r, err := Open(fixture(t, layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// gitOutput returns the output of git run with args in dir.
+func gitOutput(t testing.TB, dir string, args ...string) string {
+	t.Helper()
+	out, err := gittest.Git(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// object is an object of a fixture, as git cat-file describes it.
+type object struct {
+	sha, kind string
+	size      int64
+}
+
+// allObjects returns every object in the repository at dir.
+func allObjects(t testing.TB, dir string) []object {
+	t.Helper()
+	var objects []object
+	out := gitOutput(t, dir, "cat-file", "--batch-all-objects", "--batch-check=%(objectname) %(objecttype) %(objectsize)")
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		f := strings.Fields(line)
+		size, err := strconv.ParseInt(f[2], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, object{sha: f[0], kind: f[1], size: size})
+	}
+	return objects
+}
+
+func TestObjects(t *testing.T) {
+	for _, layout := range gittest.Layouts {
+		t.Run(layout, func(t *testing.T) {
+			dir := fixture(t, layout)
+			r := openFixture(t, layout)
+			defer r.Close()
+			for _, o := range allObjects(t, dir) {
+				kind, size, rc, err := r.ObjectReader(o.sha)
+				if err != nil {
+					t.Errorf("%s: %v", o.sha, err)
+					continue
+				}
+				got, err := ioutil.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Errorf("%s: %v", o.sha, err)
+					continue
+				}
+				if kind != o.kind || size != o.size {
+					t.Errorf("%s: got %s %d, want %s %d", o.sha, kind, size, o.kind, o.size)
+				}
+				if want := gitOutput(t, dir, "cat-file", o.kind, o.sha); !bytes.Equal(got, []byte(want)) {
+					t.Errorf("%s: content differs from git cat-file", o.sha)
+				}
+				if size, err := r.objectSize(o.sha); err != nil || size != o.size {
+					t.Errorf("%s: objectSize: got %d, %v, want %d", o.sha, size, err, o.size)
+				}
+			}
+		})
+	}
+}
+
+func TestTrees(t *testing.T) {
+	for _, layout := range gittest.Layouts {
+		t.Run(layout, func(t *testing.T) {
+			dir := fixture(t, layout)
+			r := openFixture(t, layout)
+			defer r.Close()
+			for _, o := range allObjects(t, dir) {
+				if o.kind != "tree" {
+					continue
+				}
+				tree, err := r.Tree(o.sha)
+				if err != nil {
+					t.Errorf("%s: %v", o.sha, err)
+					continue
+				}
+				var got strings.Builder
+				for i := range tree.Entries {
+					e := &tree.Entries[i]
+					fmt.Fprintf(&got, "%06o %s %s\t%s\x00", e.GitMode(), e.Kind(), e.ID(), e.Name)
+				}
+				if want := gitOutput(t, dir, "ls-tree", "-z", o.sha); got.String() != want {
+					t.Errorf("%s: got\n%q\nwant\n%q", o.sha, got.String(), want)
+				}
+			}
+		})
+	}
+}
+
+// treeObject returns the content of a tree object holding entries, each
+// an octal mode, a name, and an object id, in the order given.
+func treeObject(entries ...[3]string) []byte {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		id, err := hex.DecodeString(e[2])
+		if err != nil || len(id) != 20 {
+			panic("invalid object id " + e[2])
+		}
+		fmt.Fprintf(&buf, "%s %s\x00", e[0], e[1])
+		buf.Write(id)
+	}
+	return buf.Bytes()
+}
+
+// fakeCommit adds a commit of tree to s, and returns it, read from a
+// repository whose objects are those of s.
+func fakeCommit(t testing.TB, s mapStore, tree string) *Commit {
+	t.Helper()
+	r, err := Open(emptyRepo(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	r.SetObjectStore(s)
+	sha := s.add("commit", []byte("tree "+tree+"\n"+
+		"author A U Thor <author@example.com> 1112911993 +0000\n"+
+		"committer C O Mitter <committer@example.com> 1112911993 +0000\n\ncommit\n"))
+	c, err := r.Commit(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestLongEntryName checks a tree entry longer than the default limit of
+// a bufio.Scanner, 64KB, is read, both when the tree is parsed and when
+// it is scanned for the entry. git limits names only to what the object
+// can hold, though file systems limit them further.
+func TestLongEntryName(t *testing.T) {
+	s := make(mapStore)
+	blob := s.add("blob", []byte("long\n"))
+	long := strings.Repeat("n", 70<<10)
+	sub := s.add("tree", treeObject([3]string{"100644", long, blob}))
+	root := s.add("tree", treeObject([3]string{"40000", "sub", sub}))
+	c := fakeCommit(t, s, root)
+
+	tree, err := c.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := tree.Lookup("sub/" + long)
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if e.ID() != blob || e.Name != long {
+		t.Errorf("Lookup: got %s, a name of %d bytes, want %s, %d bytes", e.ID(), len(e.Name), blob, len(long))
+	}
+	st, err := tree.Tree("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Entries) != 1 || st.Entries[0].Name != long || st.Entries[0].ID() != blob {
+		t.Errorf("Tree(sub): got %d entries, want one of %d bytes", len(st.Entries), len(long))
+	}
+}
+
+// TestLargeTrees checks trees larger than the default limit of a
+// bufio.Scanner, 64KB, and than the buffers kept for reading trees, are
+// read whole, both when scanned for an entry and when parsed, as are
+// blobs larger than 64KB, and that a smaller tree read after them, from
+// a reused buffer, is not mixed up with them.
+func TestLargeTrees(t *testing.T) {
+	for _, n := range []int{3000, 40000} { // about 100KB and 1.3MB
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			s := make(mapStore)
+			big := bytes.Repeat([]byte("0123456789abcdef"), 5<<10)
+			blob := s.add("blob", big)
+			entries := make([][3]string, n)
+			for i := range entries {
+				entries[i] = [3]string{"100644", fmt.Sprintf("file%06d.txt", i), blob}
+			}
+			sub := s.add("tree", treeObject(entries...))
+			if size := len(s[sub].content); size <= 64<<10 {
+				t.Fatalf("tree of %d bytes, want more than 64KB", size)
+			}
+			small := s.add("tree", treeObject([3]string{"100644", "x", blob}))
+			root := s.add("tree", treeObject([3]string{"40000", "small", small}, [3]string{"40000", "sub", sub}))
+			c := fakeCommit(t, s, root)
+
+			tree, err := c.Tree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{entries[0][1], entries[n/2][1], entries[n-1][1]} {
+				e, err := tree.Lookup("sub/" + name)
+				if err != nil {
+					t.Fatalf("Lookup: %v", err)
+				}
+				if e.ID() != blob {
+					t.Errorf("Lookup(%q): got %s, want %s", name, e.ID(), blob)
+				}
+			}
+			st, err := tree.Tree("sub")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(st.Entries) != n || st.Entries[n-1].Name != entries[n-1][1] {
+				t.Errorf("Tree(sub): got %d entries, want %d", len(st.Entries), n)
+			}
+			if st, err = tree.Tree("small"); err != nil {
+				t.Fatal(err)
+			}
+			if len(st.Entries) != 1 || st.Entries[0].Name != "x" {
+				t.Errorf("Tree(small): got %d entries, want x", len(st.Entries))
+			}
+
+			e, err := tree.Lookup("sub/" + entries[n-1][1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := e.Blob()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			got, err := ioutil.ReadAll(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, big) {
+				t.Errorf("Blob: read %d bytes, want %d", len(got), len(big))
+			}
+		})
+	}
+}
+
+// TestBlobRandomAccess checks reading two ranges of a blob, in order and
+// then in reverse, by Seek and by ReadAt, reads the content at each,
+// whether the blob is small enough to be kept in memory by ReadAt or not.
+func TestBlobRandomAccess(t *testing.T) {
+	for _, size := range []int{64 << 10, maxReadAtBuffer + 64<<10} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			content := make([]byte, size)
+			for i := range content {
+				content[i] = byte(i * 7 / 3)
+			}
+			s := make(mapStore)
+			sha := s.add("blob", content)
+			b, err := fakeCommit(t, s, s.add("tree", nil)).Blob(sha)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			offsets := []int64{100, int64(size) - 1000, int64(size) - 1000, 100}
+			for i, off := range offsets {
+				var got [500]byte
+				if _, err := b.Seek(off, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.ReadFull(b, got[:]); err != nil {
+					t.Fatalf("Read at %d, after %v: %v", off, offsets[:i], err)
+				}
+				if !bytes.Equal(got[:], content[off:off+500]) {
+					t.Errorf("Read at %d, after %v: wrong content", off, offsets[:i])
+				}
+				if n, err := b.ReadAt(got[:], off); n != len(got) || err != nil {
+					t.Fatalf("ReadAt(%d), after %v: read %d bytes, %v", off, offsets[:i], n, err)
+				}
+				if !bytes.Equal(got[:], content[off:off+500]) {
+					t.Errorf("ReadAt(%d), after %v: wrong content", off, offsets[:i])
+				}
+			}
+			var got [1000]byte
+			if n, err := b.ReadAt(got[:], int64(size)-500); n != 500 || err != io.EOF {
+				t.Errorf("ReadAt past the end: read %d bytes, %v, want 500, io.EOF", n, err)
+			}
+		})
+	}
+}
+
+// TestEntryNames checks tree entry names are read byte for byte, up to
+// the NUL, however many spaces they hold, wherever, and whether or not
+// they are UTF-8.
+func TestEntryNames(t *testing.T) {
+	names := []string{
+		"a b",
+		"a  b",
+		" leading",
+		"trailing ",
+		"100644 mode",
+		"tab\tname",
+		"new\nline",
+		"ünïcödé",
+		"\xff\xfe",
+		"%d %s",
+	}
+	sort.Strings(names)
+	s := make(mapStore)
+	ids := make(map[string]string)
+	var entries [][3]string
+	for _, name := range names {
+		ids[name] = s.add("blob", []byte(name))
+		entries = append(entries, [3]string{"100644", name, ids[name]})
+	}
+	sub := s.add("tree", treeObject(entries...))
+	root := s.add("tree", treeObject([3]string{"40000", "sub", sub}))
+	tree, err := fakeCommit(t, s, root).Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tree parsed whole.
+	st, err := tree.Tree("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range st.Entries {
+		got = append(got, e.Name)
+		if e.ID() != ids[e.Name] {
+			t.Errorf("%q: got %s, want %s", e.Name, e.ID(), ids[e.Name])
+		}
+	}
+	if strings.Join(got, "\x00") != strings.Join(names, "\x00") {
+		t.Errorf("Entries: got %q, want %q", got, names)
+	}
+
+	// the tree scanned for each entry.
+	for _, name := range names {
+		e, err := tree.Lookup("sub/" + name)
+		if err != nil {
+			t.Errorf("Lookup(%q): %v", name, err)
+			continue
+		}
+		if e.Name != name || e.ID() != ids[name] {
+			t.Errorf("Lookup(%q): got %q %s, want %s", name, e.Name, e.ID(), ids[name])
+		}
+	}
+}
+
+// TestMalformedTrees checks trees with duplicate names, which git never
+// writes, are refused, and trees with entries out of order are read, with
+// a warning.
+func TestMalformedTrees(t *testing.T) {
+	gitDir := emptyRepo(t, t.TempDir())
+	write := func(kind string, content []byte) string {
+		sha, raw := looseObject(kind, content)
+		writeLoose(t, gitDir, sha, compress(t, raw, zlib.DefaultCompression))
+		return sha
+	}
+	blob := write("blob", []byte("x\n"))
+	sub := write("tree", treeObject([3]string{"100644", "x", blob}))
+
+	tests := []struct {
+		name    string
+		entries [][3]string
+		err     string   // a substring of the error, or "" for none
+		order   []string // the names of the entries read
+		warning bool
+	}{
+		{"ordered", [][3]string{{"100644", "a.txt", blob}, {"40000", "a", sub}, {"100644", "b", blob}}, "", []string{"a.txt", "a", "b"}, false},
+		{"duplicate", [][3]string{{"100644", "a", blob}, {"100755", "a", blob}}, `duplicate entry "a"`, nil, false},
+		{"duplicate tree", [][3]string{{"100644", "a", blob}, {"40000", "a", sub}}, `duplicate entry "a"`, nil, false},
+		{"unordered", [][3]string{{"100644", "b", blob}, {"100644", "a", blob}}, "", []string{"b", "a"}, true},
+		{"unordered tree", [][3]string{{"40000", "a", sub}, {"100644", "a.txt", blob}}, "", []string{"a", "a.txt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sha := write("tree", treeObject(tt.entries...))
+			r, err := Open(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			var warnings []string
+			r.SetWarnFunc(func(format string, args ...interface{}) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			})
+			for i := 0; i < 2; i++ {
+				tree, err := r.Tree(sha)
+				if tt.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.err) {
+						t.Fatalf("Tree: got %v, want %q", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, e := range tree.Entries {
+					names = append(names, e.Name)
+				}
+				if strings.Join(names, "/") != strings.Join(tt.order, "/") {
+					t.Errorf("Entries: got %q, want %q", names, tt.order)
+				}
+				for _, name := range tt.order {
+					if _, err := tree.Lookup(name); err != nil {
+						t.Errorf("Lookup(%q): %v", name, err)
+					}
+				}
+			}
+			// a tree read twice is warned about once.
+			if got := len(warnings); tt.warning && (got != 1 || !strings.Contains(warnings[0], sha)) || !tt.warning && got != 0 {
+				t.Errorf("got warnings %q, want %v", warnings, tt.warning)
+			}
+		})
+	}
+}
+
+// TestObjectErrors checks errors reading objects which are missing, of
+// the wrong kind, or corrupt name the object, with and without its stack.
+func TestObjectErrors(t *testing.T) {
+	gitDir := emptyRepo(t, t.TempDir())
+	write := func(kind string, content []byte) string {
+		sha, raw := looseObject(kind, content)
+		writeLoose(t, gitDir, sha, compress(t, raw, zlib.DefaultCompression))
+		return sha
+	}
+	missing, _ := looseObject("blob", []byte("missing\n"))
+	missingTree, _ := looseObject("tree", treeObject([3]string{"100644", "x", missing}))
+	// a blob whose zlib checksum is wrong is only found to be corrupt once
+	// it has been read to the end.
+	corrupt, raw := looseObject("blob", []byte(strings.Repeat("corrupt\n", 100)))
+	file := compress(t, raw, zlib.DefaultCompression)
+	file[len(file)-1]++
+	writeLoose(t, gitDir, corrupt, file)
+	garbled, raw := looseObject("blob", []byte("garbled\n"))
+	writeLoose(t, gitDir, garbled, raw)
+	blob := write("blob", []byte("x\n"))
+	tree := write("tree", treeObject(
+		[3]string{"100644", "corrupt", corrupt},
+		[3]string{"40000", "dir", missingTree},
+		[3]string{"100644", "missing", missing},
+	))
+
+	r, err := Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	root, err := r.Tree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBlob := func(b *Blob, err error) error {
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		_, err = ioutil.ReadAll(b)
+		return err
+	}
+
+	tests := []struct {
+		name string
+		sha  string // the object the error must name
+		err  error
+	}{
+		{"missing blob", missing, readBlob(r.Blob(missing))},
+		{"missing tree", missing, func() error { _, err := r.Tree(missing); return err }()},
+		{"missing commit", missing, func() error { _, err := r.Commit(missing); return err }()},
+		{"blob not commit", blob, func() error { _, err := r.Commit(blob); return err }()},
+		{"tree not blob", tree, readBlob(r.Blob(tree))},
+		{"missing entry", missing, readBlob(root.Blob("missing"))},
+		{"missing subtree", missingTree, func() error { _, err := root.Lookup("dir/x"); return err }()},
+		{"corrupt blob", corrupt, readBlob(root.Blob("corrupt"))},
+		{"garbled blob", garbled, readBlob(r.Blob(garbled))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("got no error")
+			}
+			if !strings.Contains(tt.err.Error(), tt.sha) {
+				t.Errorf("error %q does not name %s", tt.err, tt.sha)
+			}
+			if s := fmt.Sprintf("%+v", tt.err); !strings.Contains(s, tt.sha) {
+				t.Errorf("error %q does not name %s", s, tt.sha)
+			}
+		})
+	}
+}
+
+// TestMissingTree checks the tree of a commit missing from the repository
+// is reported as a *MissingTreeError naming the commit and the tree,
+// which satisfies IsNotExist, while a missing subtree, or a root tree
+// which is not a tree, is not reported as a missing tree.
+func TestMissingTree(t *testing.T) {
+	s := make(mapStore)
+	missing, _ := looseObject("tree", treeObject([3]string{"100644", "x", strings.Repeat("0", 40)}))
+	c := fakeCommit(t, s, missing)
+	_, err := c.Tree()
+	mte, ok := err.(*MissingTreeError)
+	if !ok || !IsMissingTree(err) || !IsNotExist(err) {
+		t.Fatalf("Tree: got %v, want a *MissingTreeError satisfying IsNotExist", err)
+	}
+	if mte.Commit != c.String() || mte.Tree != missing {
+		t.Errorf("Tree: got commit %s, tree %s, want %s, %s", mte.Commit, mte.Tree, c, missing)
+	}
+	for _, format := range []string{"%v", "%+v"} {
+		if msg := fmt.Sprintf(format, err); !strings.Contains(msg, c.String()) || !strings.Contains(msg, missing) {
+			t.Errorf("Tree: error %q does not name the commit and tree", msg)
+		}
+	}
+
+	root := s.add("tree", treeObject([3]string{"40000", "sub", missing}))
+	tree, err := fakeCommit(t, s, root).Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Lookup("sub/x"); IsMissingTree(err) || !IsNotExist(err) {
+		t.Errorf("Lookup of a missing subtree: got %v, want not found, not a missing tree", err)
+	}
+	blob := s.add("blob", []byte("not a tree\n"))
+	if _, err := fakeCommit(t, s, blob).Tree(); err == nil || IsMissingTree(err) {
+		t.Errorf("Tree of a blob: got %v, want an error other than a missing tree", err)
+	}
+}
+
+// TestCachedTreeCommit checks a tree kept by Preload, read again through
+// another commit, refers to that commit, as do its entries.
+func TestCachedTreeCommit(t *testing.T) {
+	r := openFixture(t, gittest.Packed)
+	defer r.Close()
+	read := func(rev string) (*Commit, *Tree) {
+		t.Helper()
+		sha, err := r.ResolveRev(rev + "^{commit}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := r.Commit(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := c.Tree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, root
+	}
+	_, root1 := read("t1")
+	if _, err := root1.Preload(); err != nil {
+		t.Fatal(err)
+	}
+	c2, root2 := read("t2")
+
+	// d/e f is the same tree in both commits.
+	subtree := func(root *Tree) *Tree {
+		t.Helper()
+		e, err := root.Lookup("d/e f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub, err := e.Subtree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+	sub1, sub2 := subtree(root1), subtree(root2)
+	if sub1.ID() != sub2.ID() {
+		t.Fatalf("d/e f: got trees %s and %s, want the same tree", sub1.ID(), sub2.ID())
+	}
+	if sub2.Commit != c2 {
+		t.Errorf("d/e f: got commit %s, want %s", sub2.Commit, c2)
+	}
+	for i := range sub2.Entries {
+		if e := &sub2.Entries[i]; e.Tree != sub2 || e.Commit != c2 {
+			t.Errorf("%q: got commit %s, want %s", "d/e f/"+e.Name, e.Commit, c2)
+		}
+	}
+	name := "d/e f/x\xff y"
+	e, err := root2.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Commit != c2 {
+		t.Errorf("Lookup(%q): got commit %s, want %s", name, e.Commit, c2)
+	}
+	if e.Tree.ID() != sub2.ID() {
+		t.Errorf("Lookup(%q): got parent %s, want %s", name, e.Tree.ID(), sub2.ID())
+	}
+}
+
+func TestCommits(t *testing.T) {
+	for _, layout := range gittest.Layouts {
+		t.Run(layout, func(t *testing.T) {
+			dir := fixture(t, layout)
+			r := openFixture(t, layout)
+			defer r.Close()
+			for _, o := range allObjects(t, dir) {
+				if o.kind != "commit" {
+					continue
+				}
+				c, err := r.Commit(o.sha)
+				if err != nil {
+					t.Errorf("%s: %v", o.sha, err)
+					continue
+				}
+				got := strings.Join(append([]string{c.tree}, c.Parents...), " ")
+				if want := strings.TrimSpace(gitOutput(t, dir, "show", "-s", "--format=%T %P", o.sha)); got != want {
+					t.Errorf("%s: tree and parents: got %q, want %q", o.sha, got, want)
+				}
+				if want := gitOutput(t, dir, "show", "-s", "--format=%B", o.sha); strings.TrimSpace(c.Message) != strings.TrimSpace(want) {
+					t.Errorf("%s: message: got %q, want %q", o.sha, c.Message, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRefs(t *testing.T) {
+	for _, layout := range gittest.Layouts {
+		t.Run(layout, func(t *testing.T) {
+			dir := fixture(t, layout)
+			r := openFixture(t, layout)
+			defer r.Close()
+			// show-ref -d lists each ref, and each annotated tag peeled,
+			// as name^{}, which is also how ResolveRev names it.
+			out := gitOutput(t, dir, "show-ref", "-d", "--head")
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				f := strings.Fields(line)
+				want, name := f[0], f[1]
+				if got, err := r.ResolveRev(name); err != nil || got != want {
+					t.Errorf("ResolveRev(%q): got %s, %v, want %s", name, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Header
+		err  bool
+	}{
+		{in: "blob 0", want: Header{Kind: "blob", Length: 0}},
+		{in: "tree 123", want: Header{Kind: "tree", Length: 123}},
+		{in: "commit 9223372036854775807", want: Header{Kind: "commit", Length: 1<<63 - 1}},
+		{in: "tag 7", want: Header{Kind: "tag", Length: 7}},
+		{in: "", err: true},
+		{in: "blob", err: true},
+		{in: "blob ", err: true},
+		{in: " 12", err: true},
+		{in: "blob -1", err: true},
+		{in: "blob 1x", err: true},
+		{in: "blob  1", err: true},
+		{in: "blob 9223372036854775808", err: true},
+		{in: "bolb 12", err: true},
+		{in: "Blob 12", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHeader([]byte(tt.in))
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseHeader(%q): got %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseHeader(%q): got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	r := strings.NewReader("blob 5\x00hello")
+	h, err := readHeader(r)
+	if err != nil || h != (Header{Kind: "blob", Length: 5}) {
+		t.Fatalf("got %v, %v", h, err)
+	}
+	if rest, _ := ioutil.ReadAll(r); string(rest) != "hello" {
+		t.Errorf("readHeader read into the content, leaving %q", rest)
+	}
+	for _, in := range []string{"", "blob 5", "blob 5hello", strings.Repeat("1", maxHeaderLength+1) + "\x00"} {
+		if h, err := readHeader(strings.NewReader(in)); err == nil {
+			t.Errorf("readHeader(%q): got %v, want error", in, h)
+		}
+	}
+}
+
+func BenchmarkParseHeader(b *testing.B) {
+	buf := []byte("commit 1234")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseHeader(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadSmallObjects reads every tree and commit of the loose
+// fixture, the objects read browsing a repository, most of which are a
+// few hundred bytes, where the cost of reading the header and setting up
+// decompression dominates.
+func BenchmarkReadSmallObjects(b *testing.B) {
+	dir := fixture(b, gittest.Loose)
+	r := openFixture(b, gittest.Loose)
+	defer r.Close()
+	var ids []string
+	for _, o := range allObjects(b, dir) {
+		if o.kind == "tree" || o.kind == "commit" {
+			ids = append(ids, o.sha)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, sha := range ids {
+			_, rc, err := r.readObject(sha)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+				b.Fatal(err)
+			}
+			rc.Close()
+		}
+	}
+}
+
+// BenchmarkLookup looks up the last entry of a tree of thousands of
+// files, below the root, by Lookup, which scans the tree only as far as
+// the entry, and by parsing the tree whole.
+func BenchmarkLookup(b *testing.B) {
+	const n = 5000
+	s := make(mapStore)
+	blob := s.add("blob", []byte("file\n"))
+	var entries [][3]string
+	for i := 0; i < n; i++ {
+		entries = append(entries, [3]string{"100644", fmt.Sprintf("file%05d.txt", i), blob})
+	}
+	sub := s.add("tree", treeObject(entries...))
+	root := s.add("tree", treeObject([3]string{"40000", "d", sub}))
+	tree, err := fakeCommit(b, s, root).Tree()
+	if err != nil {
+		b.Fatal(err)
+	}
+	last := entries[n-1][1]
+
+	b.Run("Lookup", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := tree.Lookup("d/" + last); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			d, err := tree.Tree("d")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if d.entry(last, false) == nil {
+				b.Fatalf("%s not found", last)
+			}
+		}
+	})
+}
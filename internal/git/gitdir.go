@@ -0,0 +1,165 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OpenGitDir returns a Repository whose git directory is gitdir, as for a
+// git directory kept apart from its working tree. The Root of the
+// Repository is the working tree named by core.worktree in the config of
+// gitdir, a relative path being relative to gitdir. Without core.worktree
+// the Root is the directory holding gitdir if it is called .git, and
+// otherwise the repository is bare, with gitdir as its Root.
+func OpenGitDir(gitdir string) (*Repository, error) {
+	dir, err := filepath.Abs(gitdir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not convert path %q to an absolute path", gitdir)
+	}
+	if !isGitDir(dir) {
+		return nil, errors.Errorf("%q is not a git directory", dir)
+	}
+	root := dir
+	if filepath.Base(dir) == ".git" {
+		root = filepath.Dir(dir)
+	}
+	r, err := openGitDir(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	if r.Root == dir && filepath.Base(dir) != ".git" {
+		r.bare = true
+	}
+	return r, nil
+}
+
+// isGitDir reports whether dir looks like a git directory, holding HEAD,
+// objects, and refs, or a commondir file in their place.
+func isGitDir(dir string) bool {
+	if fi, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil || fi.IsDir() {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(dir, "commondir")); err == nil {
+		return true
+	}
+	for _, name := range []string{"objects", "refs"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// openGitDir returns a Repository whose working tree is root and whose
+// git directory is gitdir. If gitdir contains a commondir file, as the
+// git directory of a linked worktree does, objects and shared refs are
+// read from the directory it names. Otherwise, if core.worktree is set in
+// its config, as for a submodule, or a git directory separated from its
+// working tree, that names the working tree instead of root.
+func openGitDir(root, gitdir string) (*Repository, error) {
+	r := Repository{
+		Root:           root,
+		gitdir:         gitdir,
+		UseReplaceRefs: true,
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(gitdir, "commondir"))
+	switch {
+	case os.IsNotExist(err):
+		r.commondir = gitdir
+		cfg, err := r.Config()
+		if err != nil {
+			return nil, err
+		}
+		if dir, ok := cfg.Get("core", "worktree"); ok && dir != "" {
+			dir = expandHome(dir)
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(gitdir, dir)
+			}
+			r.Root = filepath.Clean(dir)
+		}
+	case err != nil:
+		return nil, errors.WithStack(err)
+	default:
+		dir := strings.TrimSpace(string(buf))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(gitdir, dir)
+		}
+		r.commondir = filepath.Clean(dir)
+	}
+	return &r, nil
+}
+
+// readGitFile returns the git directory named by a .git file, as used by
+// linked worktrees and submodules. The file contains "gitdir: <path>"
+// where a relative path is relative to the directory holding the file.
+func readGitFile(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	line := strings.TrimSpace(string(buf))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", errors.Errorf("%q is not a git file", path)
+	}
+	dir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// gitDir returns the repository's git directory, which holds HEAD and
+// other per worktree refs.
+func (r *Repository) gitDir() string {
+	if r.gitdir == "" {
+		return filepath.Join(r.Root, ".git")
+	}
+	return r.gitdir
+}
+
+// GitDir returns the repository's git directory, usually the .git
+// directory in its Root.
+func (r *Repository) GitDir() string { return r.gitDir() }
+
+// Bare reports whether the repository has no working tree, as when it was
+// opened by OpenGitDir from a directory not called .git, and without
+// core.worktree set.
+func (r *Repository) Bare() bool { return r.bare }
+
+// commonDir returns the directory holding the repository's objects,
+// shared refs, and configuration. For linked worktrees this is the main
+// worktree's git directory; otherwise it is the git directory.
+func (r *Repository) commonDir() string {
+	if r.commondir == "" {
+		return r.gitDir()
+	}
+	return r.commondir
+}
+
+// refPath returns the path of the loose ref name.
+func (r *Repository) refPath(name string) string {
+	return filepath.Join(r.refDir(name), filepath.FromSlash(name))
+}
+
+// refDir returns the git directory holding the ref name. HEAD and the
+// other pseudo refs, and refs under refs/worktree, refs/bisect, and
+// refs/rewritten belong to the worktree; all other refs are shared.
+func (r *Repository) refDir(name string) string {
+	if worktreeRef(name) {
+		return r.gitDir()
+	}
+	return r.commonDir()
+}
+
+// worktreeRef reports whether the ref name belongs to a worktree, rather
+// than being shared by all the worktrees of the repository.
+func worktreeRef(name string) bool {
+	return !strings.HasPrefix(name, "refs/") ||
+		strings.HasPrefix(name, "refs/worktree/") ||
+		strings.HasPrefix(name, "refs/bisect/") ||
+		strings.HasPrefix(name, "refs/rewritten/")
+}
@@ -0,0 +1,81 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// TestSeparateGitDir checks a git directory kept apart from its working
+// tree, as by git init --separate-git-dir, is found from the working
+// tree, and opened itself, with the working tree named by core.worktree,
+// as git finds them.
+func TestSeparateGitDir(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	work, gitDir := filepath.Join(dir, "work"), filepath.Join(dir, "repo.git")
+	gitOutput(t, dir, "init", "-q", "--separate-git-dir", gitDir, work)
+	if err := os.MkdirAll(filepath.Join(work, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(work, "sub", "a.txt"), []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, work, "add", ".")
+	gitOutput(t, work, "commit", "-q", "-m", "separate")
+	head := strings.TrimSpace(gitOutput(t, work, "rev-parse", "HEAD"))
+
+	check := func(t *testing.T, r *Repository, err error, root string, bare bool) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if r.Root != root || r.GitDir() != gitDir || r.Bare() != bare {
+			t.Errorf("got Root %s, GitDir %s, Bare %v, want %s, %s, %v", r.Root, r.GitDir(), r.Bare(), root, gitDir, bare)
+		}
+		if sha, err := r.ResolveRev("HEAD"); err != nil || sha != head {
+			t.Errorf("ResolveRev(HEAD): got %s, %v, want %s", sha, err, head)
+		}
+	}
+	t.Run("work", func(t *testing.T) {
+		r, err := Open(work)
+		check(t, r, err, work, false)
+	})
+	t.Run("subdirectory", func(t *testing.T) {
+		r, err := Open(filepath.Join(work, "sub"))
+		check(t, r, err, work, false)
+	})
+	t.Run("gitdir", func(t *testing.T) {
+		// with no core.worktree, nothing names the working tree.
+		r, err := OpenGitDir(gitDir)
+		check(t, r, err, gitDir, true)
+	})
+
+	// core.worktree is relative to the git directory.
+	gitOutput(t, work, "config", "core.worktree", "../work")
+	if got := strings.TrimSpace(gitOutput(t, dir, "--git-dir", gitDir, "rev-parse", "--show-toplevel")); got != work {
+		t.Fatalf("git rev-parse --show-toplevel: got %s, want %s", got, work)
+	}
+	t.Run("core.worktree OpenGitDir", func(t *testing.T) {
+		r, err := OpenGitDir(gitDir)
+		check(t, r, err, work, false)
+	})
+	t.Run("core.worktree Open", func(t *testing.T) {
+		r, err := Open(gitDir)
+		check(t, r, err, work, false)
+	})
+	t.Run("core.worktree OpenStrict", func(t *testing.T) {
+		r, err := OpenStrict(gitDir)
+		check(t, r, err, work, false)
+	})
+}
@@ -0,0 +1,262 @@
+package git
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Excludes reports which paths in a repository's working tree git
+// ignores. Patterns are read, in increasing order of precedence, from the
+// file named by core.excludesFile, $GIT_DIR/info/exclude, and the
+// .gitignore file of each directory from the root of the working tree
+// down to the path's parent. As in git, the last matching pattern
+// decides, and a path below an ignored directory is ignored whatever
+// patterns match it.
+//
+// .gitignore files are reread when they change.
+type Excludes struct {
+	root string
+	base []ignorePattern
+
+	mu     sync.Mutex
+	ignore map[string]*ignoreFile // directory to its .gitignore
+}
+
+type ignoreFile struct {
+	modTime  time.Time
+	size     int64
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	dir    string // directory of the file holding the pattern, "" for the root
+	re     *regexp.Regexp
+	negate bool
+	dirs   bool // the pattern only matches directories
+}
+
+// Excludes returns the Excludes of the repository's working tree.
+func (r *Repository) Excludes() (*Excludes, error) {
+	x := Excludes{
+		root:   r.Root,
+		ignore: make(map[string]*ignoreFile),
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	excludesFile, ok := cfg.Get("core", "excludesFile")
+	if !ok {
+		global, err := globalConfig()
+		if err != nil {
+			return nil, err
+		}
+		excludesFile, ok = global.Get("core", "excludesFile")
+	}
+	path := xdgConfigPath("ignore")
+	if ok {
+		path = expandHome(excludesFile)
+	}
+	for _, path := range []string{path, filepath.Join(r.gitDir(), "info", "exclude")} {
+		if path == "" {
+			continue
+		}
+		patterns, err := readIgnoreFile(path, "")
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return nil, err
+		}
+		x.base = append(x.base, patterns...)
+	}
+	return &x, nil
+}
+
+// Ignored reports whether the slash separated path p, relative to the
+// root of the working tree, is ignored. isDir reports whether p is a
+// directory. Any .git directory is ignored.
+func (x *Excludes) Ignored(p string, isDir bool) (bool, error) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return false, nil
+	}
+	names := strings.Split(p, "/")
+	var patterns []ignorePattern
+	patterns = append(patterns, x.base...)
+	for i, name := range names {
+		if name == ".git" {
+			return true, nil
+		}
+		dir := strings.Join(names[:i], "/")
+		more, err := x.patterns(dir)
+		if err != nil {
+			return false, err
+		}
+		patterns = append(patterns, more...)
+		last := i == len(names)-1
+		if match(patterns, strings.Join(names[:i+1], "/"), !last || isDir) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// match reports whether the last of patterns to match p excludes it.
+func match(patterns []ignorePattern, p string, isDir bool) bool {
+	matched, negate := lastMatch(patterns, p, isDir)
+	return matched && !negate
+}
+
+// lastMatch reports whether any of patterns matches p, and if so, whether
+// the last to match is negated.
+func lastMatch(patterns []ignorePattern, p string, isDir bool) (bool, bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		pat := &patterns[i]
+		if pat.dirs && !isDir {
+			continue
+		}
+		rel := p
+		if pat.dir != "" {
+			if !strings.HasPrefix(p, pat.dir+"/") {
+				continue
+			}
+			rel = p[len(pat.dir)+1:]
+		}
+		if pat.re.MatchString(rel) {
+			return true, pat.negate
+		}
+	}
+	return false, false
+}
+
+// patterns returns the patterns of the .gitignore file in dir, rereading
+// it if it has changed.
+func (x *Excludes) patterns(dir string) ([]ignorePattern, error) {
+	path := filepath.Join(x.root, filepath.FromSlash(dir), ".gitignore")
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	x.mu.Lock()
+	f, ok := x.ignore[dir]
+	x.mu.Unlock()
+	if ok && f.modTime.Equal(fi.ModTime()) && f.size == fi.Size() {
+		return f.patterns, nil
+	}
+	patterns, err := readIgnoreFile(path, dir)
+	if err != nil {
+		return nil, err
+	}
+	x.mu.Lock()
+	x.ignore[dir] = &ignoreFile{modTime: fi.ModTime(), size: fi.Size(), patterns: patterns}
+	x.mu.Unlock()
+	return patterns, nil
+}
+
+// readIgnoreFile returns the patterns in the gitignore format file at
+// path, which apply to paths below dir.
+func readIgnoreFile(path, dir string) ([]ignorePattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	var patterns []ignorePattern
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if pat, ok := parseIgnorePattern(sc.Text(), dir); ok {
+			patterns = append(patterns, pat)
+		}
+	}
+	return patterns, errors.Wrapf(sc.Err(), "could not read %q", path)
+}
+
+// parseIgnorePattern parses a line of a gitignore format file. It
+// returns false for blank lines, comments, and invalid patterns.
+func parseIgnorePattern(line, dir string) (ignorePattern, bool) {
+	pat := ignorePattern{dir: dir}
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored unless escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	switch {
+	case line == "", line[0] == '#':
+		return pat, false
+	case line[0] == '!':
+		pat.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\#`), strings.HasPrefix(line, `\!`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pat.dirs = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return pat, false
+	}
+	// a pattern with a slash, other than a trailing one, is relative to
+	// dir; otherwise it may match at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := globRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return pat, false
+	}
+	pat.re = re
+	return pat, true
+}
+
+// globRegexp returns a regular expression equivalent to the gitignore
+// glob, where * and ? do not match a slash, and ** matches any number of
+// directories when it forms a whole path element.
+func globRegexp(glob string) string {
+	var buf strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			buf.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && i+2 == len(glob) && (i == 0 || glob[i-1] == '/'):
+			buf.WriteString(".*")
+			i++
+		case c == '*':
+			buf.WriteString("[^/]*")
+		case c == '?':
+			buf.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := strings.Index(glob[i+1:], "]")
+			if end < 0 {
+				buf.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String()
+}
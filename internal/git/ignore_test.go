@@ -0,0 +1,91 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// TestExcludes checks the precedence of the sources of exclude patterns,
+// core.excludesFile, then info/exclude, then each .gitignore from the
+// root down, the last to match deciding, against git check-ignore.
+func TestExcludes(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	gitOutput(t, dir, "init", "-q", work)
+	gitOutput(t, work, "config", "core.excludesFile", filepath.Join(dir, "global"))
+	files := map[string]string{
+		filepath.Join(dir, "global"):                   "*.log\n*.tmp\nsecret*\n",
+		filepath.Join(work, ".git", "info", "exclude"): "!important.log\nlocal/\n",
+		filepath.Join(work, ".gitignore"):              "!*.tmp\n!/secret-ok.txt\n!local/keep\n",
+		filepath.Join(work, "sub", ".gitignore"):       "*.tmp\n!debug.log\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Open(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	x, err := r.Excludes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a.log", false, true},              // core.excludesFile
+		{"important.log", false, false},     // negated by info/exclude
+		{"sub/important.log", false, false}, // negated at any depth
+		{"x.tmp", false, false},             // negated by .gitignore
+		{"sub/x.tmp", false, true},          // ignored again by sub/.gitignore
+		{"debug.log", false, true},
+		{"sub/debug.log", false, false}, // negated by sub/.gitignore
+		{"secret.txt", false, true},
+		{"secret-ok.txt", false, false},    // negated, anchored to the root
+		{"sub/secret-ok.txt", false, true}, // so not negated below it
+		{"local", true, true},              // a directory pattern
+		{"local", false, false},            // which a file does not match
+		{"local/keep", false, true},        // below an ignored directory
+		{"sub", true, false},
+		{".git", true, true},
+		{".git/config", false, true},
+	}
+	for _, tt := range tests {
+		got, err := x.Ignored(tt.path, tt.isDir)
+		if err != nil || got != tt.want {
+			t.Errorf("Ignored(%q, %v): got %v, %v, want %v", tt.path, tt.isDir, got, err, tt.want)
+		}
+		if filepath.Base(tt.path) == ".git" || filepath.Dir(tt.path) == ".git" {
+			// git check-ignore refuses paths within .git.
+			continue
+		}
+		p := tt.path
+		if tt.isDir {
+			p += "/"
+		}
+		err = gittest.Command(work, "check-ignore", "-q", "--no-index", p).Run()
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			t.Fatal(err)
+		}
+		if git := err == nil; git != tt.want {
+			t.Errorf("git check-ignore %s: got %v, want %v", p, git, tt.want)
+		}
+	}
+}
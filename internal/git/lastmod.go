@@ -0,0 +1,71 @@
+package git
+
+import (
+	"path"
+	"time"
+)
+
+// LastModified returns, for each file and directory in the tree of this
+// commit, the time of the most recent commit to change it, as found by
+// git log --first-parent -1 -- <path>. Paths are slash separated, and the
+// root of the tree is "". A directory changes when any path below it
+// does.
+//
+// The history is walked once, following first parents, and compared with
+// Diff, until every path has been attributed to a commit, so the cost is
+// that of diffing back to the oldest commit to change a path still in the
+// tree, which may be the root commit. Changes merged from other branches
+// are attributed to the merge. Paths older than the history available, as
+// in a shallow clone, are given the time of the oldest commit read.
+func (c *Commit) LastModified() (map[string]time.Time, error) {
+	root, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	pending := map[string]bool{"": true}
+	err = root.Walk(func(p string, _ *Entry) error {
+		pending[p] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	times := make(map[string]time.Time, len(pending))
+	cur := c
+	for len(pending) > 0 {
+		changes, err := cur.Diff()
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range changes {
+			for p := ch.Path; ; p = path.Dir(p) {
+				if p == "." {
+					p = ""
+				}
+				if pending[p] {
+					times[p] = cur.Time
+					delete(pending, p)
+				}
+				if p == "" {
+					break
+				}
+			}
+		}
+		if len(cur.Parents) == 0 {
+			break
+		}
+		parent, err := cur.readCommit(cur.Parents[0])
+		if IsNotExist(err) {
+			// a shallow clone, the history ends here.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cur = parent
+	}
+	for p := range pending {
+		times[p] = cur.Time
+	}
+	return times, nil
+}
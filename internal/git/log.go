@@ -0,0 +1,63 @@
+package git
+
+import (
+	"container/heap"
+	"io"
+)
+
+// Log walks the history of a commit, visiting the commit and its
+// ancestors newest first by committer time, like git log. Each commit is
+// visited once. Parents missing from the repository, as in a shallow
+// clone, are not visited.
+type Log struct {
+	queue commitQueue
+	seen  map[string]bool
+}
+
+// Log returns a Log of the history of this commit.
+func (c *Commit) Log() *Log {
+	l := Log{
+		seen: map[string]bool{c.id: true},
+	}
+	heap.Push(&l.queue, c)
+	return &l
+}
+
+// Next returns the next commit in the history, or io.EOF when all the
+// commits have been visited.
+func (l *Log) Next() (*Commit, error) {
+	if l.queue.Len() == 0 {
+		return nil, io.EOF
+	}
+	c := heap.Pop(&l.queue).(*Commit)
+	for _, sha := range c.Parents {
+		if l.seen[sha] {
+			continue
+		}
+		l.seen[sha] = true
+		p, err := c.Commit(sha)
+		if IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		heap.Push(&l.queue, p)
+	}
+	return c, nil
+}
+
+// commitQueue is a heap of commits, newest first.
+type commitQueue []*Commit
+
+func (q commitQueue) Len() int            { return len(q) }
+func (q commitQueue) Less(i, j int) bool  { return q[i].Time.After(q[j].Time) }
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(*Commit)) }
+
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
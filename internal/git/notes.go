@@ -0,0 +1,82 @@
+package git
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultNotesRef is the ref holding notes, unless core.notesRef names
+// another.
+const defaultNotesRef = "refs/notes/commits"
+
+// Notes returns the note attached to the object sha, usually a commit, as
+// git notes show would print it. If there is no note, or the repository
+// has no notes, Notes returns the empty string.
+//
+// Notes are read from refs/notes/commits, or the ref core.notesRef names.
+// The tree of its commit holds a blob for each object noted, named by the
+// object's id. Trees with many notes split the ids into directories named
+// by their leading pairs of digits, for example ab/cdef..., to any depth,
+// so each level is searched for the remaining digits, then the next pair.
+func (r *Repository) Notes(sha string) (string, error) {
+	if !isObjectID(sha) {
+		return "", errors.Errorf("invalid object id %q", sha)
+	}
+	ref, err := r.notesRef()
+	if err != nil {
+		return "", err
+	}
+	if !r.RefExists(ref) {
+		return "", nil
+	}
+	id, err := r.readRef(ref)
+	if err != nil {
+		return "", err
+	}
+	c, err := r.Commit(id)
+	if err != nil {
+		return "", err
+	}
+	t, err := c.Tree()
+	if err != nil {
+		return "", err
+	}
+	for name := strings.ToLower(sha); len(name) > 0; name = name[2:] {
+		if e := t.entry(name, false); e != nil && e.Mode.IsRegular() {
+			b, err := e.Blob()
+			if err != nil {
+				return "", err
+			}
+			defer b.Close()
+			buf, err := ioutil.ReadAll(b)
+			return string(buf), errors.Wrapf(err, "could not read note %s", e.id)
+		}
+		e := t.entry(name[:2], false)
+		if e == nil || !e.Mode.IsDir() {
+			break
+		}
+		if t, err = e.Subtree(); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// notesRef returns the fully qualified name of the ref holding notes.
+// As in git, core.notesRef must name a ref below refs/notes/.
+func (r *Repository) notesRef() (string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+	ref, ok := cfg.Get("core", "notesRef")
+	if !ok || ref == "" {
+		return defaultNotesRef, nil
+	}
+	if !strings.HasPrefix(ref, "refs/notes/") {
+		return "", errors.Errorf("core.notesRef %q is not below refs/notes/", ref)
+	}
+	return ref, nil
+}
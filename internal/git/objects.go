@@ -0,0 +1,62 @@
+package git
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// objectDirs returns the directories searched for objects, primary first.
+//
+// Like git, the primary object directory is taken from GIT_OBJECT_DIRECTORY
+// if set, otherwise it is the objects directory of the common git directory.
+// It is followed by the directories in GIT_ALTERNATE_OBJECT_DIRECTORIES, then
+// those listed in the primary directory's info/alternates file.
+func (r *Repository) objectDirs() []string {
+	r.dirsOnce.Do(func() {
+		primary := filepath.Join(r.commonDir(), "objects")
+		if dir := os.Getenv("GIT_OBJECT_DIRECTORY"); dir != "" {
+			primary = absPath(dir)
+		}
+		r.dirs = append(r.dirs, primary)
+		for _, dir := range filepath.SplitList(os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES")) {
+			if dir != "" {
+				r.dirs = append(r.dirs, absPath(dir))
+			}
+		}
+		r.dirs = append(r.dirs, alternates(primary)...)
+	})
+	return r.dirs
+}
+
+// alternates returns the object directories listed in dir's info/alternates
+// file. Relative paths are relative to dir.
+func alternates(dir string) []string {
+	f, err := os.Open(filepath.Join(dir, "info", "alternates"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var dirs []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(dir, line)
+		}
+		dirs = append(dirs, filepath.Clean(line))
+	}
+	return dirs
+}
+
+// absPath returns p as an absolute path, or p unchanged if that fails.
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,354 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// maxDeltaDepth is the longest chain of deltas that will be followed
+// when reconstructing an object from a pack. It guards against cycles
+// in REF_DELTA objects in corrupt packs.
+const maxDeltaDepth = 4096
+
+// pack object types.
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var kinds = map[byte]string{
+	objCommit: "commit",
+	objTree:   "tree",
+	objBlob:   "blob",
+	objTag:    "tag",
+}
+
+// pack represents a packfile and its version 2 index.
+type pack struct {
+	// path is the path to the .pack file.
+	path string
+
+	fanout   [256]uint32
+	names    []byte // sorted object ids, 20 bytes each
+	crcs     []byte // CRC-32 of each object's entry, 4 bytes each
+	offsets  []byte // 31 bit offsets, or indexes into large, 4 bytes each
+	large    []byte // 64 bit offsets, 8 bytes each
+	checksum []byte // SHA-1 of the pack, its last 20 bytes
+
+	once sync.Once
+	f    *os.File // the pack file, opened on first use
+	size int64    // the length of the pack file
+	err  error
+}
+
+// openPack reads the index of the pack at path, which should
+// be the path of the .idx file.
+func openPack(path string) (*pack, error) {
+	idx, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	p := pack{
+		path: strings.TrimSuffix(path, ".idx") + ".pack",
+	}
+	if err := p.parseIndex(idx); err != nil {
+		return nil, errors.Wrapf(err, "could not read pack index %q", path)
+	}
+	return &p, nil
+}
+
+// parseIndex parses a version 2 pack index, checking the index against
+// the checksum at its end.
+func (p *pack) parseIndex(idx []byte) error {
+	const header = 8
+	const trailer = 2 * 20 // pack checksum, index checksum
+	if len(idx) < header || !bytes.Equal(idx[:4], []byte("\377tOc")) {
+		// version 1 indexes have no magic, they start with the fanout table.
+		return errors.New("unsupported pack index version 1")
+	}
+	if v := binary.BigEndian.Uint32(idx[4:8]); v != 2 {
+		return errors.Errorf("unsupported pack index version %d", v)
+	}
+	if len(idx) < header+len(p.fanout)*4+trailer {
+		return corrupt("pack index truncated")
+	}
+	if sum := sha1.Sum(idx[:len(idx)-20]); !bytes.Equal(sum[:], idx[len(idx)-20:]) {
+		return corrupt("pack index checksum mismatch")
+	}
+	p.checksum = idx[len(idx)-trailer : len(idx)-20]
+	buf := idx[header:]
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(buf[i*4:])
+	}
+	buf = buf[len(p.fanout)*4 : len(buf)-trailer]
+
+	n := int(p.fanout[255])
+	if len(buf) < n*(20+4+4) {
+		return corrupt("pack index truncated")
+	}
+	p.names, buf = buf[:n*20], buf[n*20:]
+	p.crcs, buf = buf[:n*4], buf[n*4:]
+	p.offsets, buf = buf[:n*4], buf[n*4:]
+	if len(buf)%8 != 0 {
+		return corrupt("malformed pack index large offset table")
+	}
+	p.large = buf
+	return nil
+}
+
+// find returns the offset of the object id in the pack.
+func (p *pack) find(id []byte) (int64, bool, error) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	hi := int(p.fanout[id[0]])
+	if lo > hi || hi > len(p.names)/20 {
+		return 0, false, corrupt("malformed pack index fanout table")
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.names[(lo+i)*20:(lo+i+1)*20], id) >= 0
+	})
+	if i == hi || !bytes.Equal(p.names[i*20:(i+1)*20], id) {
+		return 0, false, nil
+	}
+	off, err := p.offset(i)
+	return off, err == nil, err
+}
+
+// offset returns the offset of the i'th object in the index.
+func (p *pack) offset(i int) (int64, error) {
+	off := binary.BigEndian.Uint32(p.offsets[i*4:])
+	if off&0x80000000 == 0 {
+		return int64(off), nil
+	}
+
+	// the offset is an index into the large offset table, used
+	// for objects more than 2GB into the pack.
+	j := int(off & 0x7fffffff)
+	if (j+1)*8 > len(p.large) {
+		return 0, corrupt("pack index large offset %d out of range", j)
+	}
+	return int64(binary.BigEndian.Uint64(p.large[j*8:])), nil
+}
+
+// open returns the pack file, opening it and checking it on first use.
+// The file remains open until the pack is closed.
+func (p *pack) open() (*os.File, error) {
+	p.once.Do(func() {
+		f, err := os.Open(p.path)
+		if err != nil {
+			p.err = errors.WithStack(err)
+			return
+		}
+		if p.err = p.check(f); p.err != nil {
+			f.Close()
+			return
+		}
+		p.f = f
+	})
+	return p.f, p.err
+}
+
+// check checks the header of the pack file f, and that its length, its
+// checksum, and the number of objects it holds, agree with the index.
+// The content is not read; see verify.
+func (p *pack) check(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	p.size = fi.Size()
+	const header = 12 // PACK, version, number of objects
+	if p.size < header+20 {
+		return corrupt("pack %q truncated", p.path)
+	}
+	var hdr [header]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return errors.Wrapf(err, "could not read pack header %q", p.path)
+	}
+	if !bytes.Equal(hdr[:4], []byte("PACK")) {
+		return corrupt("%q is not a pack", p.path)
+	}
+	if v := binary.BigEndian.Uint32(hdr[4:]); v != 2 && v != 3 {
+		return errors.Errorf("unsupported pack version %d", v)
+	}
+	if n := binary.BigEndian.Uint32(hdr[8:]); int(n) != len(p.names)/20 {
+		return corrupt("pack %q holds %d objects, its index %d", p.path, n, len(p.names)/20)
+	}
+	var sum [20]byte
+	if _, err := f.ReadAt(sum[:], p.size-20); err != nil {
+		return errors.Wrapf(err, "could not read pack checksum %q", p.path)
+	}
+	if !bytes.Equal(sum[:], p.checksum) {
+		return corrupt("pack %q does not match its index, it may be truncated or replaced", p.path)
+	}
+	return nil
+}
+
+// close closes the pack file, if it was opened.
+func (p *pack) close() error {
+	if p.f == nil {
+		return nil
+	}
+	return p.f.Close()
+}
+
+// readObject returns a header and an io.ReadCloser for the object at
+// offset off in the pack. Deltified objects larger than limit bytes are
+// reconstructed in temporary files, as by undelta.
+func (p *pack) readObject(store ObjectStore, off int64, limit int64) (Header, io.ReadCloser, error) {
+	f, err := p.open()
+	if err != nil {
+		return Header{}, nil, err
+	}
+	typ, length, br, err := p.entry(f, off)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	if kind, ok := kinds[typ]; ok {
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return Header{}, nil, errors.WithStack(err)
+		}
+		return Header{
+			Kind:   kind,
+			Length: length,
+		}, &sizedReader{ReadCloser: zr, remaining: length}, nil
+	}
+	kind, o, err := p.undelta(store, f, off, 0, limit)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	return Header{
+		Kind:   kind,
+		Length: o.size,
+	}, o.reader(), nil
+}
+
+// entry reads the pack entry header at off returning the type, the
+// inflated length, and a reader positioned at the start of the entry's
+// data. For deltified entries the data starts with the base offset or
+// base object id.
+func (p *pack) entry(f *os.File, off int64) (byte, int64, *bufio.Reader, error) {
+	if off < 12 || off >= p.size-20 {
+		return 0, 0, nil, corrupt("pack entry offset %d out of range", off)
+	}
+	br := bufio.NewReader(io.NewSectionReader(f, off, p.size-20-off))
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, 0, nil, errors.Wrapf(err, "could not read pack entry at offset %d", off)
+	}
+	typ := (c >> 4) & 7
+	length := int64(c & 0x0f)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if shift > 56 {
+			return 0, 0, nil, corrupt("pack entry length at offset %d overflows", off)
+		}
+		if c, err = br.ReadByte(); err != nil {
+			return 0, 0, nil, errors.Wrapf(err, "could not read pack entry at offset %d", off)
+		}
+		length |= int64(c&0x7f) << shift
+	}
+	return typ, length, br, nil
+}
+
+// undelta reconstructs the object at off, returning its kind and content.
+// REF_DELTA bases not found in the pack are read from store. Objects, and
+// bases, larger than limit bytes are reconstructed in temporary files
+// rather than in memory, unless limit is zero. The caller must close the
+// content returned.
+func (p *pack) undelta(store ObjectStore, f *os.File, off int64, depth int, limit int64) (string, *resolved, error) {
+	if depth > maxDeltaDepth {
+		return "", nil, corrupt("delta chain at offset %d too long", off)
+	}
+	typ, length, br, err := p.entry(f, off)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var kind string
+	var base *resolved
+	switch typ {
+	case objOfsDelta:
+		c, err := br.ReadByte()
+		if err != nil {
+			return "", nil, errors.WithStack(err)
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return "", nil, errors.WithStack(err)
+			}
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		if rel <= 0 || rel > off {
+			return "", nil, corrupt("invalid delta base offset %d at offset %d", rel, off)
+		}
+		kind, base, err = p.undelta(store, f, off-rel, depth+1, limit)
+		if err != nil {
+			return "", nil, err
+		}
+	case objRefDelta:
+		var id [20]byte
+		if _, err := io.ReadFull(br, id[:]); err != nil {
+			return "", nil, errors.WithStack(err)
+		}
+		if boff, ok, err := p.find(id[:]); err != nil {
+			return "", nil, err
+		} else if ok {
+			kind, base, err = p.undelta(store, f, boff, depth+1, limit)
+			if err != nil {
+				return "", nil, err
+			}
+		} else {
+			h, rc, err := store.Get(hex.EncodeToString(id[:]))
+			if err != nil {
+				return "", nil, err
+			}
+			kind = h.Kind
+			base, err = readResolved(rc, h.Length, limit)
+			rc.Close()
+			if err != nil {
+				return "", nil, err
+			}
+		}
+	default:
+		var ok bool
+		if kind, ok = kinds[typ]; !ok {
+			return "", nil, corrupt("unknown pack object type %d at offset %d", typ, off)
+		}
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		if base != nil {
+			base.close()
+		}
+		return "", nil, errors.WithStack(err)
+	}
+	defer zr.Close()
+	if base == nil {
+		o, err := readResolved(&sizedReader{ReadCloser: zr, remaining: length}, length, limit)
+		return kind, o, errors.Wrapf(err, "could not inflate pack entry at offset %d", off)
+	}
+	defer base.close()
+	o, err := applyDelta(base, bufio.NewReader(&sizedReader{ReadCloser: zr, remaining: length}), limit)
+	return kind, o, errors.Wrapf(err, "could not apply delta at offset %d", off)
+}
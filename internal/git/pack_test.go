@@ -0,0 +1,330 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// makeIndex returns a version 2 pack index of objects with the given ids,
+// which must be sorted, at the given offsets, storing offsets of 2GB or
+// more in the large offset table as git does.
+func makeIndex(ids [][]byte, offsets []int64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\377tOc")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, id := range ids {
+		for i := int(id[0]); i < len(fanout); i++ {
+			fanout[i]++
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+	for _, id := range ids {
+		buf.Write(id)
+	}
+	buf.Write(make([]byte, 4*len(ids))) // CRCs
+	var large []uint64
+	for _, off := range offsets {
+		if off < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(off))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, uint64(off))
+	}
+	binary.Write(&buf, binary.BigEndian, large)
+	buf.Write(make([]byte, 20)) // pack checksum
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+func TestPackIndexLargeOffsets(t *testing.T) {
+	ids := [][]byte{
+		bytes.Repeat([]byte{0x01}, 20),
+		bytes.Repeat([]byte{0x80}, 20),
+		bytes.Repeat([]byte{0x81}, 20),
+		bytes.Repeat([]byte{0xff}, 20),
+	}
+	offsets := []int64{12, 3 << 30, 0x7fffffff, 1 << 40}
+	var p pack
+	if err := p.parseIndex(makeIndex(ids, offsets)); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		off, ok, err := p.find(id)
+		if err != nil || !ok || off != offsets[i] {
+			t.Errorf("find(%x): got %d, %v, %v, want %d", id, off, ok, err, offsets[i])
+		}
+	}
+	if _, ok, err := p.find(bytes.Repeat([]byte{0x02}, 20)); ok || err != nil {
+		t.Errorf("find of a missing object: got %v, %v", ok, err)
+	}
+
+	// an offset referring past the end of the large offset table.
+	binary.BigEndian.PutUint32(p.offsets[4:], 0x80000000|2)
+	if _, err := p.offset(1); !IsCorrupt(err) {
+		t.Errorf("offset out of the large offset table: got %v, want corrupt", err)
+	}
+}
+
+func TestPackIndexVersions(t *testing.T) {
+	v2 := makeIndex([][]byte{bytes.Repeat([]byte{0x01}, 20)}, []int64{12})
+	v3 := append([]byte(nil), v2...)
+	binary.BigEndian.PutUint32(v3[4:], 3)
+	corrupted := append([]byte(nil), v2...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		idx  []byte
+		err  string // a substring of the error, or "" for none
+	}{
+		{"version 2", v2, ""},
+		{"version 1", v2[8:], "unsupported pack index version 1"},
+		{"version 3", v3, "unsupported pack index version 3"},
+		{"truncated", v2[:100], "truncated"},
+		{"checksum", corrupted, "checksum mismatch"},
+	}
+	for _, tt := range tests {
+		var p pack
+		err := p.parseIndex(tt.idx)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// TestPackVersion3 checks objects are read from a pack whose header gives
+// version 3, which differs from version 2 only in allowing objects of
+// other formats, by changing the version of the packs of a fixture.
+func TestPackVersion3(t *testing.T) {
+	src := fixture(t, gittest.Packed)
+	dir, err := ioutil.TempDir("", "pack-v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitOutput(t, dir, "clone", "-q", "--bare", "--no-local", src, "repo.git")
+	gitDir := filepath.Join(dir, "repo.git")
+	packs, err := filepath.Glob(filepath.Join(gitDir, "objects", "pack", "*.pack"))
+	if err != nil || len(packs) == 0 {
+		t.Fatalf("no packs: %v", err)
+	}
+	for _, path := range packs {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteAt([]byte{0, 0, 0, 3}, 4)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, o := range allObjects(t, src) {
+		kind, size, rc, err := r.ObjectReader(o.sha)
+		if err != nil {
+			t.Errorf("%s: %v", o.sha, err)
+			continue
+		}
+		n, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || kind != o.kind || size != o.size || int64(len(n)) != o.size {
+			t.Errorf("%s: got %s %d, read %d, %v, want %s %d", o.sha, kind, size, len(n), err, o.kind, o.size)
+		}
+	}
+}
+
+// addPack adds a pack holding only a blob of the given content to the
+// repository at gitDir, returning the blob's id and the path of the pack.
+func addPack(t *testing.T, gitDir, content string) (string, string) {
+	t.Helper()
+	cmd := gittest.Command(gitDir, "hash-object", "-w", "--stdin")
+	cmd.Stdin = strings.NewReader(content)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha := strings.TrimSpace(string(out))
+	cmd = gittest.Command(gitDir, "pack-objects", "-q", "objects/pack/pack")
+	cmd.Stdin = strings.NewReader(sha + "\n")
+	if out, err = cmd.Output(); err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, gitDir, "prune-packed")
+	return sha, filepath.Join(gitDir, "objects", "pack", "pack-"+strings.TrimSpace(string(out))+".pack")
+}
+
+// TestTruncatedPack checks a pack, or pack index, truncated as by a full
+// disk, is reported as corrupt, while the objects of the other packs are
+// still read.
+func TestTruncatedPack(t *testing.T) {
+	src := fixture(t, gittest.Packed)
+	for _, ext := range []string{".idx", ".pack"} {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+			gitOutput(t, dir, "clone", "-q", "--bare", "--no-local", src, "repo.git")
+			gitDir := filepath.Join(dir, "repo.git")
+
+			// a second pack, holding one blob, to truncate.
+			extra, pack := addPack(t, gitDir, "extra\n")
+			path := strings.TrimSuffix(pack, ".pack") + ext
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, fi.Size()/2); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := Open(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			for _, o := range allObjects(t, src) {
+				if _, content, err := readContent(r, o.sha); err != nil || int64(len(content)) != o.size {
+					t.Errorf("%s: read %d bytes, %v, want %d", o.sha, len(content), err, o.size)
+				}
+			}
+			if _, content, err := readContent(r, extra); err == nil {
+				t.Errorf("%s: read %q, want an error", extra, content)
+			}
+			if n, err := r.VerifyPacks(); !IsCorrupt(err) {
+				t.Errorf("VerifyPacks: got %d, %v, want an error satisfying IsCorrupt", n, err)
+			}
+		})
+	}
+}
+
+// TestPackRescan checks the pack directory is scanned again for an object
+// which is not found only if it has been modified since it was last
+// scanned.
+func TestPackRescan(t *testing.T) {
+	src := fixture(t, gittest.Packed)
+	dir := t.TempDir()
+	gitOutput(t, dir, "clone", "-q", "--bare", "--no-local", src, "repo.git")
+	gitDir := filepath.Join(dir, "repo.git")
+	packDir := filepath.Join(gitDir, "objects", "pack")
+	// long enough ago that a scan cannot have missed a later change.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(packDir, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Has(head) {
+		t.Fatalf("Has(%s): got false, want true", head)
+	}
+
+	// a pack added without modifying the directory is not found.
+	extra, _ := addPack(t, gitDir, "extra\n")
+	if err := os.Chtimes(packDir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if r.Has(extra) {
+		t.Errorf("Has(%s), pack directory unmodified: got true, want false", extra)
+	}
+	now := time.Now()
+	if err := os.Chtimes(packDir, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Has(extra) {
+		t.Errorf("Has(%s), pack directory modified: got false, want true", extra)
+	}
+	if _, content, err := readContent(r, extra); err != nil || string(content) != "extra\n" {
+		t.Errorf("%s: read %q, %v, want %q", extra, content, err, "extra\n")
+	}
+}
+
+// BenchmarkReadDeltaBlob reads the version of the large file of the delta
+// fixture at the end of the longest chain of deltas, with the
+// reconstructed objects held in memory, and in temporary files.
+func BenchmarkReadDeltaBlob(b *testing.B) {
+	dir := fixture(b, gittest.Delta)
+	depth := make(map[string]int)
+	packs, err := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "*.idx"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, idx := range packs {
+		for _, line := range strings.Split(gitOutput(b, dir, "verify-pack", "-v", idx), "\n") {
+			// deltified objects are listed with their depth and base.
+			if f := strings.Fields(line); len(f) == 7 {
+				depth[f[0]], _ = strconv.Atoi(f[5])
+			}
+		}
+	}
+	var sha string
+	for i := 0; i < gittest.Commits; i++ {
+		id := strings.TrimSpace(gitOutput(b, dir, "rev-parse", fmt.Sprintf("t%d:d/big.txt", i)))
+		if sha == "" || depth[id] > depth[sha] {
+			sha = id
+		}
+	}
+	if depth[sha] == 0 {
+		b.Fatal("the large file is not deltified")
+	}
+
+	for _, bb := range []struct {
+		name  string
+		limit int64
+	}{
+		{"memory", 0},
+		{"file", 64 << 10},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			r := openFixture(b, gittest.Delta)
+			defer r.Close()
+			r.SetMaxDeltaMemory(bb.limit)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				blob, err := r.Blob(sha)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(ioutil.Discard, blob)
+				blob.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(n)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package git
+
+import (
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// objTypes maps the kind of an object to its pack object type.
+var objTypes = map[string]byte{
+	"commit": objCommit,
+	"tree":   objTree,
+	"blob":   objBlob,
+	"tag":    objTag,
+}
+
+// Reachable returns the ids of the objects reachable from want, being
+// the objects themselves, and the tags, commits, trees, and blobs they
+// refer to in turn, excluding any reachable from have. Objects in have
+// which are not in the repository are ignored, as a client may hold
+// objects the repository does not. Commits come before the trees and
+// blobs they refer to. Submodule commits, which belong to another
+// repository, are not included.
+func (r *Repository) Reachable(want, have []string) ([]string, error) {
+	exclude := make(map[string]bool)
+	if err := r.walkObjects(have, exclude, true, nil); err != nil {
+		return nil, err
+	}
+	var ids []string
+	err := r.walkObjects(want, exclude, false, func(sha string) {
+		ids = append(ids, sha)
+	})
+	return ids, err
+}
+
+// walkObjects calls fn, if not nil, for each object reachable from roots
+// which is not in seen, adding it to seen. If lenient is set, missing
+// objects are skipped rather than being an error. Objects are read as
+// they are stored, without following replace refs, so the objects found
+// are those a pack of them must hold.
+func (r *Repository) walkObjects(roots []string, seen map[string]bool, lenient bool, fn func(string)) error {
+	missing := func(err error) bool {
+		return lenient && IsNotExist(err)
+	}
+	var stack []link
+	for i := len(roots) - 1; i >= 0; i-- {
+		stack = append(stack, link{sha: roots[i]})
+	}
+	for len(stack) > 0 {
+		o := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[o.sha] {
+			continue
+		}
+		var links []link
+		if o.kind != "blob" {
+			// blobs refer to nothing, so need not be read.
+			var err error
+			links, err = r.storedLinks(o.sha)
+			if missing(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		seen[o.sha] = true
+		if fn != nil {
+			fn(o.sha)
+		}
+		// links are pushed in reverse, so are visited in order.
+		for i := len(links) - 1; i >= 0; i-- {
+			stack = append(stack, links[i])
+		}
+	}
+	return nil
+}
+
+// link is an object referred to by another, and its kind, if known.
+type link struct {
+	sha, kind string // kind is empty if not yet known
+}
+
+// storedLinks returns the objects the object sha, as it is stored,
+// refers to: the object a tag tags, the tree then the parents of a
+// commit, or the entries of a tree, excluding submodule commits, which
+// belong to another repository.
+func (r *Repository) storedLinks(sha string) ([]link, error) {
+	h, rc, err := r.readStored(sha)
+	if err != nil {
+		return nil, objectError("object", sha, err)
+	}
+	defer rc.Close()
+	var links []link
+	switch h.Kind {
+	case "tag":
+		t := Tag{Repository: r, id: sha}
+		if _, err := t.parseTag(rc); err != nil {
+			return nil, objectError("tag", sha, err)
+		}
+		links = append(links, link{sha: t.Object})
+	case "commit":
+		c := Commit{Repository: r, id: sha}
+		if _, err := c.parseCommit(rc, h.Length); err != nil {
+			return nil, objectError("commit", sha, err)
+		}
+		// the tree comes first, so is visited before the parents.
+		links = append(links, link{sha: c.tree, kind: "tree"})
+		for _, p := range c.Parents {
+			links = append(links, link{sha: p, kind: "commit"})
+		}
+	case "tree":
+		buf, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, objectError("tree", sha, err)
+		}
+		t := Tree{Commit: &Commit{Repository: r}, id: sha}
+		if _, err := t.parseTree(buf); err != nil {
+			return nil, objectError("tree", sha, err)
+		}
+		for i := range t.Entries {
+			e := &t.Entries[i]
+			switch {
+			case e.Mode.IsDir():
+				links = append(links, link{sha: e.id, kind: "tree"})
+			case e.Mode&os.ModeIrregular == 0:
+				links = append(links, link{sha: e.id, kind: "blob"})
+			}
+		}
+	}
+	return links, nil
+}
+
+// WritePack writes the objects ids to w as a version 2 pack file. Each
+// object is stored whole, compressed but not deltified, and is copied
+// from the repository as it is read, so memory use does not depend on
+// the size of the objects.
+func (r *Repository) WritePack(w io.Writer, ids []string) error {
+	sum := sha1.New()
+	mw := io.MultiWriter(w, sum)
+	var hdr [12]byte
+	copy(hdr[:], "PACK")
+	binary.BigEndian.PutUint32(hdr[4:], 2)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(ids)))
+	if _, err := mw.Write(hdr[:]); err != nil {
+		return errors.WithStack(err)
+	}
+	zw := zlib.NewWriter(mw)
+	for _, sha := range ids {
+		if err := r.writePackEntry(mw, zw, sha); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(sum.Sum(nil))
+	return errors.WithStack(err)
+}
+
+// writePackEntry writes the object sha to w as a pack entry, compressing
+// its content with zw. The object is written as it is stored, without
+// following replace refs.
+func (r *Repository) writePackEntry(w io.Writer, zw *zlib.Writer, sha string) error {
+	h, rc, err := r.readStored(sha)
+	if err != nil {
+		return objectError("object", sha, err)
+	}
+	defer rc.Close()
+	typ, ok := objTypes[h.Kind]
+	if !ok {
+		return objectError("object", sha, errors.Errorf("unknown object kind %q", h.Kind))
+	}
+
+	// the entry header holds the type and the length, in little endian
+	// base 128, four bits in the first byte and seven in each following.
+	var buf [16]byte
+	n := 0
+	c := typ<<4 | byte(h.Length&0x0f)
+	for length := h.Length >> 4; length > 0; length >>= 7 {
+		buf[n] = c | 0x80
+		n++
+		c = byte(length & 0x7f)
+	}
+	buf[n] = c
+	n++
+	if _, err := w.Write(buf[:n]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	zw.Reset(w)
+	copied, err := io.Copy(zw, rc)
+	if err != nil {
+		return objectError(h.Kind, sha, err)
+	}
+	if copied != h.Length {
+		return objectError(h.Kind, sha, errors.Errorf("read %d bytes, expected %d", copied, h.Length))
+	}
+	return errors.WithStack(zw.Close())
+}
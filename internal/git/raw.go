@@ -0,0 +1,181 @@
+package git
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// RawObject is an object as git stores it on disk, undecoded. A loose
+// object is its zlib compressed file, "<kind> <length>\x00" followed by
+// the content. A packed object is its entry in the pack: the type and
+// length header, the base of a deltified entry, then the zlib compressed
+// data or delta. An OFS_DELTA entry refers to its base by offset within
+// the pack, so is only of use alongside the pack.
+type RawObject struct {
+	io.ReadCloser
+
+	// Size is the length of the stored object.
+	Size int64
+
+	// Pack is the file name of the pack holding the object, or empty
+	// for a loose object.
+	Pack string
+
+	// Offset is the offset of a packed object's entry in its pack.
+	Offset int64
+}
+
+// rawStore is implemented by ObjectStores which can return objects as
+// they are stored.
+type rawStore interface {
+	getRaw(sha string) (*RawObject, error)
+}
+
+// ReadRaw returns the object sha as it is stored, undecoded, for copying
+// verbatim. Loose objects are preferred to packed objects, as by
+// readObject.
+func (r *Repository) ReadRaw(sha string) (*RawObject, error) {
+	if r.closed {
+		return nil, errors.New("repository closed")
+	}
+	if !isObjectID(sha) {
+		return nil, errors.Errorf("invalid object id %q", sha)
+	}
+	m, _ := r.objects().(multiStore)
+	raw, err := m.getRaw(sha)
+	if IsNotExist(err) && m.reload() {
+		raw, err = m.getRaw(sha)
+	}
+	return raw, err
+}
+
+// getRaw returns the object sha as it is stored by the first store
+// holding it.
+func (m multiStore) getRaw(sha string) (*RawObject, error) {
+	for _, s := range m {
+		rs, ok := s.(rawStore)
+		if !ok {
+			continue
+		}
+		raw, err := rs.getRaw(sha)
+		if IsNotExist(err) {
+			continue
+		}
+		return raw, err
+	}
+	return nil, notFound(sha)
+}
+
+func (s *looseStore) getRaw(sha string) (*RawObject, error) {
+	f, err := os.Open(s.path(sha))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &RawObject{ReadCloser: f, Size: fi.Size()}, nil
+}
+
+func (s *packStore) getRaw(sha string) (*RawObject, error) {
+	id, err := hex.DecodeString(sha)
+	if err != nil || len(id) != 20 {
+		return nil, errors.Errorf("invalid object id %q", sha)
+	}
+	packs, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packs {
+		off, ok, err := p.find(id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not search pack %q", p.path)
+		}
+		if !ok {
+			continue
+		}
+		f, err := p.open()
+		if err != nil {
+			return nil, err
+		}
+		end, err := p.entryEnd(f, off)
+		if err != nil {
+			return nil, err
+		}
+		return &RawObject{
+			ReadCloser: ioutil.NopCloser(io.NewSectionReader(f, off, end-off)),
+			Size:       end - off,
+			Pack:       filepath.Base(p.path),
+			Offset:     off,
+		}, nil
+	}
+	return nil, notFound(sha)
+}
+
+// entryEnd returns the offset of the end of the pack entry at off. The
+// compressed length of an entry is not recorded, so the entry's data is
+// inflated, and discarded, to find where it ends.
+func (p *pack) entryEnd(f *os.File, off int64) (int64, error) {
+	if off < 12 || off >= p.size-20 {
+		return 0, corrupt("pack entry offset %d out of range", off)
+	}
+	cr := &countingReader{r: bufio.NewReader(io.NewSectionReader(f, off, p.size-20-off))}
+	c, err := cr.ReadByte()
+	typ := (c >> 4) & 7
+	for err == nil && c&0x80 != 0 {
+		c, err = cr.ReadByte()
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not read pack entry at offset %d", off)
+	}
+	switch typ {
+	case objOfsDelta:
+		for c, err = cr.ReadByte(); err == nil && c&0x80 != 0; c, err = cr.ReadByte() {
+		}
+	case objRefDelta:
+		_, err = io.CopyN(ioutil.Discard, cr, 20)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not read delta base at offset %d", off)
+	}
+	zr, err := zlib.NewReader(cr)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not inflate pack entry at offset %d", off)
+	}
+	defer zr.Close()
+	if _, err := io.Copy(ioutil.Discard, zr); err != nil {
+		return 0, errors.Wrapf(err, "could not inflate pack entry at offset %d", off)
+	}
+	return off + cr.n, nil
+}
+
+// countingReader counts the bytes read through it. It implements
+// io.ByteReader, so compress/flate reads no further than the end of the
+// compressed data.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
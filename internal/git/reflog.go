@@ -0,0 +1,57 @@
+package git
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// zeroID is the object id recorded in a reflog for a ref which did not,
+// or no longer does, exist.
+const zeroID = "0000000000000000000000000000000000000000"
+
+// reflog returns the object ids the fully qualified ref name has held, as
+// recorded in its reflog, newest first. Each line of a reflog is
+// "<old> <new> <committer> <time> <zone>\t<message>", oldest first.
+// Entries recording the deletion of the ref are skipped. A ref with no
+// reflog has no entries. Reflogs held in reftables are not read.
+func (r *Repository) reflog(name string) ([]string, error) {
+	if !validRefName(name) {
+		return nil, errors.Errorf("invalid ref name %q", name)
+	}
+	f, err := os.Open(filepath.Join(r.refDir(name), "logs", filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	var ids []string
+	// messages may be longer than the default limit of a bufio.Scanner.
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+		if line != "" {
+			if len(line) < 82 || line[40] != ' ' || line[81] != ' ' || !isObjectID(line[:40]) || !isObjectID(line[41:81]) {
+				return nil, errors.Errorf("reflog of %q is malformed: %q", name, line)
+			}
+			if id := line[41:81]; id != zeroID {
+				ids = append(ids, id)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids, nil
+}
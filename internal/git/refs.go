@@ -0,0 +1,386 @@
+package git
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Exists reports whether the object sha is present in the repository,
+// either as a loose object or in a pack. The object itself is not read.
+func (r *Repository) Exists(sha string) bool {
+	if r.closed || !isObjectID(sha) {
+		return false
+	}
+	return r.objects().Has(sha)
+}
+
+// RefExists reports whether the fully qualified ref name, for example
+// refs/heads/master or HEAD, exists as a loose ref or in packed-refs, or
+// if the repository stores its refs in reftables, in those.
+func (r *Repository) RefExists(name string) bool {
+	if !validRefName(name) {
+		return false
+	}
+	if r.usesReftable() {
+		_, ok, err := r.lookupReftable(name)
+		return ok && err == nil
+	}
+	if fi, err := os.Stat(r.refPath(name)); err == nil && fi.Mode().IsRegular() {
+		return true
+	}
+	refs, err := r.packedRefs()
+	if err != nil {
+		return false
+	}
+	_, ok := refs[name]
+	return ok
+}
+
+// validRefName reports whether name is safe to use as a path relative
+// to the git directory.
+func validRefName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return !strings.ContainsAny(name, "\x00\\")
+}
+
+// isPseudoRef reports whether name could be a ref at the top of the git
+// directory, as HEAD, ORIG_HEAD, or FETCH_HEAD: upper case letters and
+// underscores.
+func isPseudoRef(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// packedRefs returns the contents of the packed-refs file as a map of
+// ref names to object ids. A missing packed-refs file is not an error.
+func (r *Repository) packedRefs() (map[string]string, error) {
+	f, err := os.Open(filepath.Join(r.commonDir(), "packed-refs"))
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	return parsePackedRefs(f)
+}
+
+// parsePackedRefs parses a packed-refs file, returning a map of ref names
+// to object ids.
+func parsePackedRefs(r io.Reader) (map[string]string, error) {
+	refs := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			// skip the header and peeled tag values.
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs, errors.Wrap(sc.Err(), "could not read packed-refs")
+}
+
+// ResolveRev returns the object id named by rev, which may be a full
+// object id, the name of a ref, or an object id abbreviated to at least
+// four hex digits. Ref names are resolved in the order used by git
+// rev-parse: rev, refs/rev, refs/tags/rev, refs/heads/rev,
+// refs/remotes/rev, then refs/remotes/rev/HEAD. As in git, a ref is
+// preferred to an abbreviated id, and an abbreviation which is the start
+// of the ids of more than one object is an error satisfying IsAmbiguous.
+//
+// As with git rev-parse, branch@{upstream}, or branch@{u}, names the
+// remote tracking branch configured as the upstream of branch, or of the
+// current branch if branch is omitted. stash@{n} names the nth entry of
+// the stash, as listed by Stashes, stash@{0} being the newest. The
+// suffixes ^{commit} and ^{tree} name the commit, or tree, a rev refers
+// to, and ^{} peels any annotated tags.
+//
+// If a RefResolver has been set with SetRefResolver, it is consulted
+// before the repository's refs.
+func (r *Repository) ResolveRev(rev string) (string, error) {
+	for _, kind := range []string{"tree", "commit", ""} {
+		if suffix := "^{" + kind + "}"; strings.HasSuffix(rev, suffix) {
+			sha, err := r.ResolveRev(strings.TrimSuffix(rev, suffix))
+			if err != nil {
+				return "", err
+			}
+			return r.peelTo(sha, kind)
+		}
+	}
+	if i := strings.Index(rev, "@{"); i >= 0 && strings.HasSuffix(rev, "}") {
+		switch inner := strings.ToLower(rev[i+2 : len(rev)-1]); {
+		case inner == "upstream" || inner == "u":
+			ref, err := r.upstream(rev[:i])
+			if err != nil {
+				return "", err
+			}
+			return r.readRef(ref)
+		case rev[:i] == "stash" || rev[:i] == stashRef:
+			return r.resolveStash(rev, inner)
+		}
+	}
+	if isObjectID(rev) {
+		return strings.ToLower(rev), nil
+	}
+	for _, rr := range r.refResolvers() {
+		sha, err := rr.Resolve(rev)
+		if IsNotExist(err) {
+			continue
+		}
+		return sha, err
+	}
+	if isAbbrev(rev) {
+		sha, err := r.expandAbbrev(rev)
+		if !IsNotExist(err) {
+			return sha, err
+		}
+	}
+	return "", errors.Errorf("could not resolve %q", rev)
+}
+
+// ResolveRef returns the fully qualified name of the ref named by rev,
+// found in the order used by git rev-parse: rev, refs/rev, refs/tags/rev,
+// refs/heads/rev, refs/remotes/rev, then refs/remotes/rev/HEAD. So a tag
+// is preferred to a branch of the same name, and refs/heads/master names
+// the branch regardless. The second result is false if there is no such
+// ref.
+//
+// As in git, rev itself is only tried if it is fully qualified, starting
+// with refs/, or names a ref at the top of the git directory such as HEAD
+// or FETCH_HEAD, which are written in capitals and underscores, so a
+// branch named config is not mistaken for the repository's config file.
+func (r *Repository) ResolveRef(rev string) (string, bool) {
+	for _, name := range refNames(rev) {
+		if r.RefExists(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// refNames returns the fully qualified names rev may refer to, in the
+// order ResolveRef tries them.
+func refNames(rev string) []string {
+	names := []string{
+		rev,
+		"refs/" + rev,
+		"refs/tags/" + rev,
+		"refs/heads/" + rev,
+		"refs/remotes/" + rev,
+		"refs/remotes/" + rev + "/HEAD",
+	}
+	if !strings.HasPrefix(rev, "refs/") && !isPseudoRef(rev) {
+		names = names[1:]
+	}
+	return names
+}
+
+// peelTo returns the id of the object of the given kind, tree or commit,
+// that sha refers to. Annotated tags are peeled, and a commit refers to
+// its tree. If kind is empty, only tags are peeled.
+func (r *Repository) peelTo(sha, kind string) (string, error) {
+	sha, err := r.Peel(sha)
+	if err != nil || kind == "" {
+		return sha, err
+	}
+	typ, err := r.Type(sha)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case typ == kind:
+		return sha, nil
+	case typ == "commit" && kind == "tree":
+		c, err := r.Commit(sha)
+		if err != nil {
+			return "", err
+		}
+		return c.tree, nil
+	default:
+		return "", errors.Errorf("%s is a %s, not a %s", sha, typ, kind)
+	}
+}
+
+// upstream returns the remote tracking ref configured as the upstream of
+// branch, or of the branch HEAD refers to if branch is empty.
+func (r *Repository) upstream(branch string) (string, error) {
+	if branch == "" || branch == "HEAD" {
+		ref, err := r.readSymref("HEAD")
+		if err != nil {
+			return "", err
+		}
+		if ref == "" {
+			return "", errors.New("HEAD does not point to a branch")
+		}
+		branch = ref
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+	remote, ok := cfg.Get("branch."+branch, "remote")
+	merge, ok2 := cfg.Get("branch."+branch, "merge")
+	if !ok || !ok2 {
+		return "", errors.Errorf("no upstream configured for branch %q", branch)
+	}
+	if remote == "." {
+		// the upstream is a local branch.
+		return merge, nil
+	}
+	for _, spec := range cfg.GetAll("remote."+remote, "fetch") {
+		if ref, ok := mapRefspec(spec, merge); ok {
+			return ref, nil
+		}
+	}
+	return "", errors.Errorf("upstream of branch %q is not fetched to a remote tracking branch", branch)
+}
+
+// mapRefspec returns the destination of ref when fetched using the
+// refspec spec, like +refs/heads/*:refs/remotes/origin/*.
+func mapRefspec(spec, ref string) (string, bool) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return "", false
+	}
+	src, dst := strings.TrimPrefix(spec[:i], "+"), spec[i+1:]
+	j := strings.Index(src, "*")
+	if j < 0 {
+		return dst, src == ref
+	}
+	prefix, suffix := src[:j], src[j+1:]
+	if !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) || len(ref) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	match := ref[len(prefix) : len(ref)-len(suffix)]
+	return strings.Replace(dst, "*", match, 1), true
+}
+
+// Head returns the object id HEAD points to. HEAD usually names the
+// current branch, which may be a loose ref, or only in packed-refs.
+func (r *Repository) Head() (string, error) {
+	return r.readRef("HEAD")
+}
+
+// readSymref returns the name of the ref the symbolic ref name points to,
+// or "" if name is not a symbolic ref.
+func (r *Repository) readSymref(name string) (string, error) {
+	if r.usesReftable() {
+		rec, ok, err := r.lookupReftable(name)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", errors.Errorf("could not resolve ref %q", name)
+		}
+		return rec.symref, nil
+	}
+	buf, err := ioutil.ReadFile(r.refPath(name))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	ref := strings.TrimSpace(string(buf))
+	if !strings.HasPrefix(ref, "ref:") {
+		return "", nil
+	}
+	return strings.TrimSpace(strings.TrimPrefix(ref, "ref:")), nil
+}
+
+// maxSymrefDepth limits the number of symbolic refs followed by readRef.
+const maxSymrefDepth = 5
+
+// readRef returns the object id the fully qualified ref name points to,
+// following symbolic refs. Refs with no loose ref file, as in a fully
+// packed repository which may have no refs directory at all, are read
+// from packed-refs. If the repository stores its refs in reftables, they
+// are read from those instead.
+func (r *Repository) readRef(name string) (string, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		if !validRefName(name) {
+			return "", errors.Errorf("invalid ref name %q", name)
+		}
+		if r.usesReftable() {
+			rec, ok, err := r.lookupReftable(name)
+			switch {
+			case err != nil:
+				return "", err
+			case !ok:
+				return "", errors.Errorf("could not resolve ref %q", name)
+			case rec.symref != "":
+				name = rec.symref
+				continue
+			}
+			return rec.id, nil
+		}
+		buf, err := ioutil.ReadFile(r.refPath(name))
+		if os.IsNotExist(err) || isNotDir(err) {
+			refs, err := r.packedRefs()
+			if err != nil {
+				return "", err
+			}
+			sha, ok := refs[name]
+			if !ok {
+				return "", errors.Errorf("could not resolve ref %q", name)
+			}
+			return sha, nil
+		}
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		ref := strings.TrimSpace(string(buf))
+		if !strings.HasPrefix(ref, "ref:") {
+			if !isObjectID(ref) {
+				return "", errors.Errorf("ref %q is malformed", name)
+			}
+			return ref, nil
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(ref, "ref:"))
+	}
+	return "", errors.Errorf("too many levels of symbolic refs resolving %q", name)
+}
+
+// isNotDir reports whether err is ENOTDIR, as returned when reading the
+// loose ref refs/heads/a/b while refs/heads/a is a loose ref. Like a
+// missing file, it means the ref is not loose.
+func isNotDir(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err == syscall.ENOTDIR
+	}
+	return false
+}
+
+// isObjectID reports whether s is a full, hex encoded, SHA1 object id.
+func isObjectID(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,265 @@
+package git
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// TestPackedRefsOnly checks refs are resolved from packed-refs in a
+// repository holding nothing else but HEAD and its objects, as a
+// repository cloned, or garbage collected, with no commits since.
+func TestPackedRefsOnly(t *testing.T) {
+	src := fixture(t, gittest.Packed)
+	dir, err := ioutil.TempDir("", "packed-refs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitOutput(t, dir, "clone", "-q", "--bare", "--no-local", src, "repo.git")
+	gitDir := filepath.Join(dir, "repo.git")
+	fis, err := ioutil.ReadDir(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		switch fi.Name() {
+		case "HEAD", "packed-refs", "objects":
+		default:
+			if err := os.RemoveAll(filepath.Join(gitDir, fi.Name())); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// git requires the refs directory, even when empty.
+	if err := os.Mkdir(filepath.Join(gitDir, "refs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	want := func(rev string) string {
+		return strings.TrimSpace(gitOutput(t, src, "rev-parse", rev))
+	}
+
+	if got, err := r.Head(); err != nil || got != want("HEAD") {
+		t.Errorf("Head: got %s, %v, want %s", got, err, want("HEAD"))
+	}
+	for _, rev := range []string{"HEAD", "master", "refs/heads/master", "t1", "refs/tags/t1", "t1^{}", "t1^{commit}", "l2"} {
+		if got, err := r.ResolveRev(rev); err != nil || got != want(rev) {
+			t.Errorf("ResolveRev(%q): got %s, %v, want %s", rev, got, err, want(rev))
+		}
+	}
+	if _, err := r.ResolveRev("missing"); err == nil {
+		t.Errorf("ResolveRev(%q): want error", "missing")
+	}
+
+	branches, err := r.Branches()
+	if err != nil || len(branches) != 1 || branches["master"] != want("master") {
+		t.Errorf("Branches: got %v, %v", branches, err)
+	}
+	tags, err := r.Tags()
+	if err != nil || len(tags) != 2*gittest.Commits {
+		t.Errorf("Tags: got %v, %v", tags, err)
+	}
+}
+
+// TestResolveAbbrev checks abbreviated object ids are resolved to the
+// one object whose id starts with them, whether it is loose or packed.
+func TestResolveAbbrev(t *testing.T) {
+	for _, layout := range gittest.Layouts {
+		t.Run(layout, func(t *testing.T) {
+			r := openFixture(t, layout)
+			defer r.Close()
+			objects := allObjects(t, fixture(t, layout))
+			for _, o := range objects {
+				for _, n := range []int{4, 5, 7, 39} {
+					prefix := o.sha[:n]
+					matches := 0
+					for _, other := range objects {
+						if strings.HasPrefix(other.sha, prefix) {
+							matches++
+						}
+					}
+					got, err := r.ResolveRev(strings.ToUpper(prefix))
+					switch {
+					case matches > 1 && !IsAmbiguous(err):
+						t.Errorf("ResolveRev(%q): got %s, %v, want an error satisfying IsAmbiguous", prefix, got, err)
+					case matches == 1 && (err != nil || got != o.sha):
+						t.Errorf("ResolveRev(%q): got %s, %v, want %s", prefix, got, err, o.sha)
+					}
+				}
+			}
+			for _, rev := range []string{"abc", "zzzz", strings.Repeat("0", 39)} {
+				if got, err := r.ResolveRev(rev); err == nil || IsAmbiguous(err) {
+					t.Errorf("ResolveRev(%q): got %s, %v, want an error", rev, got, err)
+				}
+			}
+		})
+	}
+}
+
+// TestResolveAmbiguous checks an abbreviated object id which is the start
+// of the ids of two objects is refused, and a longer one is not, whether
+// the objects are loose or packed.
+func TestResolveAmbiguous(t *testing.T) {
+	gitDir := emptyRepo(t, t.TempDir())
+	// two blobs whose ids share their first four hex digits.
+	seen := make(map[string]string)
+	var a, b string
+	for i := 0; a == ""; i++ {
+		sha, raw := looseObject("blob", []byte(fmt.Sprintln(i)))
+		if other, ok := seen[sha[:4]]; ok {
+			a, b = other, sha
+		}
+		seen[sha[:4]] = sha
+		writeLoose(t, gitDir, sha, compress(t, raw, zlib.DefaultCompression))
+	}
+	n := 4
+	for a[n] == b[n] {
+		n++
+	}
+
+	check := func(t *testing.T) {
+		r, err := Open(gitDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if got, err := r.ResolveRev(a[:n]); !IsAmbiguous(err) {
+			t.Errorf("ResolveRev(%q): got %s, %v, want an error satisfying IsAmbiguous", a[:n], got, err)
+		} else if !strings.Contains(err.Error(), a) || !strings.Contains(err.Error(), b) {
+			t.Errorf("ResolveRev(%q): error %q does not name %s and %s", a[:n], err, a, b)
+		}
+		for _, want := range []string{a, b} {
+			if got, err := r.ResolveRev(want[:n+1]); err != nil || got != want {
+				t.Errorf("ResolveRev(%q): got %s, %v, want %s", want[:n+1], got, err, want)
+			}
+		}
+	}
+	t.Run("loose", check)
+	// the blobs are unreachable, so are packed by name.
+	cmd := gittest.Command(gitDir, "pack-objects", "-q", "objects/pack/pack")
+	cmd.Stdin = strings.NewReader(a + "\n" + b + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	gitOutput(t, gitDir, "prune-packed")
+	t.Run("packed", check)
+}
+
+// TestTagTargets checks annotated tags of a tree and of a blob are peeled
+// to them, and that only the tag of the tree names a tree. Neither names
+// a commit.
+func TestTagTargets(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir, err := gittest.New(t.TempDir(), gittest.Loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := strings.TrimSpace(gitOutput(t, dir, "rev-parse", "master^{tree}"))
+	blob := strings.TrimSpace(gitOutput(t, dir, "rev-parse", "master:a.txt"))
+	gitOutput(t, dir, "tag", "-a", "-m", "a tree", "treetag", tree)
+	gitOutput(t, dir, "tag", "-a", "-m", "a blob", "blobtag", blob)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		rev  string
+		want string // "" for an error
+	}{
+		{"treetag^{}", tree},
+		{"treetag^{tree}", tree},
+		{"treetag^{commit}", ""},
+		{"blobtag^{}", blob},
+		{"blobtag^{tree}", ""},
+		{"blobtag^{commit}", ""},
+	}
+	for _, tt := range tests {
+		got, err := r.ResolveRev(tt.rev)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("ResolveRev(%q): got %s, want an error", tt.rev, got)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("ResolveRev(%q): got %s, %v, want %s", tt.rev, got, err, tt.want)
+		}
+	}
+
+	// the tree served for the tag of the blob is the one git would make.
+	bt, err := r.BlobTree("blobtag", blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := gittest.Command(dir, "mktree")
+	cmd.Stdin = strings.NewReader("100644 blob " + blob + "\tblobtag\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(string(out)); bt.ID() != want {
+		t.Errorf("BlobTree: got id %s, want %s", bt.ID(), want)
+	}
+	if _, err := r.BlobTree("treetag", tree); err == nil {
+		t.Errorf("BlobTree of a tree: got no error")
+	}
+}
+
+// TestRefAmbiguity checks a name which is both a tag and a branch names
+// the tag, as in git, unless qualified, and that a branch named after a
+// file in the git directory names the branch, whether the refs are loose
+// or packed.
+func TestRefAmbiguity(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir, err := gittest.New(t.TempDir(), gittest.Loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, dir, "branch", "both", "t0")
+	gitOutput(t, dir, "tag", "both", "t1^{}")
+	gitOutput(t, dir, "branch", "config", "t2")
+
+	tests := []struct {
+		rev, ref string
+	}{
+		{"both", "refs/tags/both"},
+		{"tags/both", "refs/tags/both"},
+		{"heads/both", "refs/heads/both"},
+		{"refs/heads/both", "refs/heads/both"},
+		{"config", "refs/heads/config"},
+	}
+	check := func(t *testing.T) {
+		r, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		for _, tt := range tests {
+			if ref, ok := r.ResolveRef(tt.rev); !ok || ref != tt.ref {
+				t.Errorf("ResolveRef(%q): got %s, %v, want %s", tt.rev, ref, ok, tt.ref)
+			}
+			want := strings.TrimSpace(gitOutput(t, dir, "rev-parse", "--verify", "-q", tt.rev))
+			if got, err := r.ResolveRev(tt.rev); err != nil || got != want {
+				t.Errorf("ResolveRev(%q): got %s, %v, want %s, as git rev-parse", tt.rev, got, err, want)
+			}
+		}
+	}
+	t.Run("loose", check)
+	gitOutput(t, dir, "pack-refs", "--all", "--prune")
+	t.Run("packed", check)
+}
@@ -0,0 +1,425 @@
+package git
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Refs may be stored in the reftable format, described in git's
+// Documentation/technical/reftable.txt, rather than as loose refs and
+// packed-refs. The refs are held in a stack of tables, in the reftable
+// directory of the git directory, listed oldest first in tables.list.
+// Records in newer tables take precedence over those in older ones, and
+// a deletion record hides the ref in older tables.
+//
+// Each table is a header, a number of blocks, then a footer. Only the ref
+// blocks, which come first, are read; the log, object, and index blocks
+// are not. Ref records are sorted by name, and each name is stored as the
+// length of the prefix it shares with the previous name, and the rest.
+
+// reftableRecord is the value of a ref read from a reftable.
+type reftableRecord struct {
+	id     string // object id, if not a symbolic ref
+	symref string // name of the ref a symbolic ref points to
+}
+
+// ref record value types.
+const (
+	reftableDeletion = 0
+	reftableID       = 1
+	reftableIDPeeled = 2
+	reftableSymref   = 3
+)
+
+// maxReftableRetries is the number of times the tables of a stack are
+// reread when a table listed has been removed, as happens when git
+// compacts the stack between tables.list being read and the table opened.
+const maxReftableRetries = 3
+
+// usesReftable reports whether the repository's refs are stored in
+// reftables, rather than as loose refs and packed-refs.
+func (r *Repository) usesReftable() bool {
+	_, err := os.Stat(filepath.Join(r.commonDir(), "reftable", "tables.list"))
+	return err == nil
+}
+
+// lookupReftable returns the value of the ref name. The second result is
+// false if there is no such ref.
+func (r *Repository) lookupReftable(name string) (reftableRecord, bool, error) {
+	var rec reftableRecord
+	var found bool
+	err := r.eachReftable(r.refDir(name), func(tables []*reftable) error {
+		for i := len(tables) - 1; i >= 0; i-- {
+			var ok, deleted bool
+			var err error
+			rec, ok, deleted, err = tables[i].lookup(name)
+			if err != nil || ok {
+				found = ok && !deleted
+				return err
+			}
+		}
+		return nil
+	})
+	return rec, found, err
+}
+
+// reftableRefs returns every ref in the repository's reftables, by name.
+// For a linked worktree, the refs belonging to the worktree are read
+// from its own stack, and the shared refs from that of the main worktree.
+func (r *Repository) reftableRefs() (map[string]reftableRecord, error) {
+	refs := make(map[string]reftableRecord)
+	dirs := []string{r.commonDir()}
+	if r.gitDir() != r.commonDir() {
+		dirs = append(dirs, r.gitDir())
+	}
+	for _, dir := range dirs {
+		stack := make(map[string]reftableRecord)
+		err := r.eachReftable(dir, func(tables []*reftable) error {
+			for k := range stack {
+				delete(stack, k)
+			}
+			for _, t := range tables {
+				err := t.each(func(name string, rec reftableRecord, deleted bool) (bool, error) {
+					if deleted {
+						delete(stack, name)
+					} else {
+						stack[name] = rec
+					}
+					return true, nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for name, rec := range stack {
+			if len(dirs) == 1 || worktreeRef(name) == (dir == r.gitDir()) {
+				refs[name] = rec
+			}
+		}
+	}
+	return refs, nil
+}
+
+// eachReftable opens the tables of the stack in dir, and calls fn with
+// them, oldest first. If a table listed does not exist, the stack is
+// reread. A missing stack has no tables.
+func (r *Repository) eachReftable(dir string, fn func([]*reftable) error) error {
+	dir = filepath.Join(dir, "reftable")
+	for retry := 0; ; retry++ {
+		tables, err := openReftables(dir)
+		if os.IsNotExist(errors.Cause(err)) && retry < maxReftableRetries {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(tables)
+		for _, t := range tables {
+			t.f.Close()
+		}
+		return err
+	}
+}
+
+// openReftables opens the tables listed in the tables.list file of dir,
+// oldest first.
+func openReftables(dir string) ([]*reftable, error) {
+	f, err := os.Open(filepath.Join(dir, "tables.list"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	var tables []*reftable
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name := strings.TrimSpace(sc.Text())
+		if name == "" {
+			continue
+		}
+		if strings.ContainsAny(name, "/\\") {
+			err = errors.Errorf("invalid reftable name %q", name)
+			break
+		}
+		var t *reftable
+		t, err = openReftable(filepath.Join(dir, name))
+		if err != nil {
+			break
+		}
+		tables = append(tables, t)
+	}
+	if err == nil {
+		err = errors.Wrap(sc.Err(), "could not read tables.list")
+	}
+	if err != nil {
+		for _, t := range tables {
+			t.f.Close()
+		}
+		return nil, err
+	}
+	return tables, nil
+}
+
+// reftable is a table of refs in the reftable format.
+type reftable struct {
+	f         *os.File
+	blockSize int64 // 0 if blocks are not aligned
+	headerLen int64
+	refEnd    int64 // offset of the end of the ref blocks
+}
+
+// reftable header and footer lengths, by format version. Version 2 adds
+// the id of the hash function.
+var (
+	reftableHeaderLen = map[byte]int64{1: 24, 2: 28}
+	reftableFooterLen = map[byte]int64{1: 68, 2: 72}
+)
+
+// openReftable opens the reftable at path, and reads its header and
+// footer.
+func openReftable(path string) (*reftable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	t, err := readReftable(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "could not read reftable %q", path)
+	}
+	return t, nil
+}
+
+func readReftable(f *os.File) (*reftable, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var hdr [28]byte
+	if _, err := f.ReadAt(hdr[:5], 0); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if string(hdr[:4]) != "REFT" {
+		return nil, errors.New("not a reftable")
+	}
+	version := hdr[4]
+	headerLen, ok := reftableHeaderLen[version]
+	if !ok {
+		return nil, errors.Errorf("unsupported reftable version %d", version)
+	}
+	footerLen := reftableFooterLen[version]
+	if fi.Size() < headerLen+footerLen {
+		return nil, errors.New("reftable truncated")
+	}
+	footer := make([]byte, footerLen)
+	if _, err := f.ReadAt(footer, fi.Size()-footerLen); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if crc32.ChecksumIEEE(footer[:footerLen-4]) != binary.BigEndian.Uint32(footer[footerLen-4:]) {
+		return nil, errors.New("reftable footer checksum mismatch")
+	}
+	copy(hdr[:], footer[:headerLen])
+	if version == 2 && string(hdr[24:28]) != "sha1" {
+		return nil, errors.Errorf("unsupported reftable hash %q", hdr[24:28])
+	}
+	t := reftable{
+		f:         f,
+		blockSize: int64(uint24(hdr[5:8])),
+		headerLen: headerLen,
+		refEnd:    fi.Size() - footerLen,
+	}
+	// the ref blocks end where the first of the sections following them,
+	// if any, starts.
+	positions := footer[headerLen : headerLen+40]
+	for _, pos := range []uint64{
+		binary.BigEndian.Uint64(positions[0:]),      // ref index
+		binary.BigEndian.Uint64(positions[8:]) >> 5, // objects
+		binary.BigEndian.Uint64(positions[24:]),     // logs
+	} {
+		if pos > 0 && int64(pos) < t.refEnd {
+			t.refEnd = int64(pos)
+		}
+	}
+	return &t, nil
+}
+
+// lookup returns the record of the ref name, and whether it is a deletion.
+// The second result is false if the table has no record of name.
+func (t *reftable) lookup(name string) (reftableRecord, bool, bool, error) {
+	// TODO(dfc) use the restart points, and the ref index of large
+	// tables, rather than reading every block before the ref.
+	var rec reftableRecord
+	var found, deleted bool
+	err := t.each(func(n string, r reftableRecord, del bool) (bool, error) {
+		switch {
+		case n == name:
+			rec, found, deleted = r, true, del
+			return false, nil
+		case n > name:
+			// records are sorted by name, so there is none for name.
+			return false, nil
+		}
+		return true, nil
+	})
+	return rec, found, deleted, err
+}
+
+// each calls fn for each ref record in the table, in name order, until fn
+// returns false or an error.
+func (t *reftable) each(fn func(name string, rec reftableRecord, deleted bool) (bool, error)) error {
+	for off := int64(0); off < t.refEnd; {
+		start := int64(0)
+		if off == 0 {
+			// the first block follows the file header, which is
+			// counted in its length.
+			start = t.headerLen
+		}
+		var bh [4]byte
+		if _, err := t.f.ReadAt(bh[:], off+start); err != nil {
+			return errors.Wrapf(err, "could not read block at offset %d", off)
+		}
+		if bh[0] != 'r' {
+			// the end of the ref blocks, or padding.
+			return nil
+		}
+		blockLen := int64(uint24(bh[1:4]))
+		if blockLen < start+4+2 || off+blockLen > t.refEnd {
+			return errors.Errorf("invalid block length %d at offset %d", blockLen, off)
+		}
+		// read the byte following the block, to find whether the next
+		// block is aligned.
+		n := blockLen
+		if off+n < t.refEnd {
+			n++
+		}
+		block := make([]byte, n)
+		if _, err := t.f.ReadAt(block, off); err != nil {
+			return errors.Wrapf(err, "could not read block at offset %d", off)
+		}
+		more, err := eachRefRecord(block[start+4:blockLen], fn)
+		if err != nil {
+			return errors.Wrapf(err, "invalid block at offset %d", off)
+		}
+		if !more {
+			return nil
+		}
+		switch {
+		case t.blockSize == 0, blockLen < t.blockSize && n > blockLen && block[blockLen] != 0:
+			// blocks are not aligned, the next follows this one.
+			off += blockLen
+		default:
+			// the next block follows the padding of this one.
+			off += t.blockSize
+		}
+	}
+	return nil
+}
+
+// eachRefRecord calls fn for each record in the ref block b, excluding the
+// block header, until fn returns false or an error, and reports whether
+// it did not.
+func eachRefRecord(b []byte, fn func(string, reftableRecord, bool) (bool, error)) (bool, error) {
+	// the block ends with the offsets of the restart points, 3 bytes
+	// each, and their number.
+	restarts := int(binary.BigEndian.Uint16(b[len(b)-2:]))
+	end := len(b) - 2 - 3*restarts
+	if restarts == 0 || end < 0 {
+		return false, errors.New("invalid restart count")
+	}
+	b = b[:end]
+	var name []byte
+	for len(b) > 0 {
+		prefix, err := reftableVarint(&b)
+		if err != nil {
+			return false, err
+		}
+		v, err := reftableVarint(&b)
+		if err != nil {
+			return false, err
+		}
+		suffix, typ := v>>3, v&7
+		if prefix > uint64(len(name)) || suffix > uint64(len(b)) {
+			return false, errors.New("invalid ref name")
+		}
+		name = append(name[:prefix], b[:suffix]...)
+		b = b[suffix:]
+		if _, err := reftableVarint(&b); err != nil {
+			// the update index, relative to the table's minimum.
+			return false, err
+		}
+		var rec reftableRecord
+		switch typ {
+		case reftableDeletion:
+		case reftableID, reftableIDPeeled:
+			n := 20
+			if typ == reftableIDPeeled {
+				// followed by the id of the peeled tag.
+				n = 40
+			}
+			if len(b) < n {
+				return false, errors.New("ref record truncated")
+			}
+			rec.id = hex.EncodeToString(b[:20])
+			b = b[n:]
+		case reftableSymref:
+			n, err := reftableVarint(&b)
+			if err != nil {
+				return false, err
+			}
+			if n > uint64(len(b)) {
+				return false, errors.New("ref record truncated")
+			}
+			rec.symref = string(b[:n])
+			b = b[n:]
+		default:
+			return false, errors.Errorf("unknown ref value type %d", typ)
+		}
+		more, err := fn(string(name), rec, typ == reftableDeletion)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// reftableVarint reads a variable length integer, encoded as the offsets
+// of OFS_DELTA entries in packs are, from the start of *b.
+func reftableVarint(b *[]byte) (uint64, error) {
+	buf := *b
+	if len(buf) == 0 {
+		return 0, errors.WithStack(io.ErrUnexpectedEOF)
+	}
+	c := buf[0]
+	v := uint64(c & 0x7f)
+	i := 1
+	for c&0x80 != 0 {
+		if i == len(buf) || i > 9 {
+			return 0, errors.New("invalid varint")
+		}
+		c = buf[i]
+		v = ((v + 1) << 7) | uint64(c&0x7f)
+		i++
+	}
+	*b = buf[i:]
+	return v, nil
+}
+
+// uint24 returns the big endian 24 bit integer in b.
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
@@ -0,0 +1,49 @@
+package git
+
+import "github.com/pkg/errors"
+
+// maxReplaceDepth is the longest chain of replace refs followed, as git
+// follows, so replacements which replace each other are found.
+const maxReplaceDepth = 5
+
+// replacement returns the id of the object read in place of sha: that
+// named by refs/replace/<sha>, or the replacement of that in turn, if
+// UseReplaceRefs is set, or sha itself. The replace refs, loose and
+// packed, are read once, when first needed.
+func (r *Repository) replacement(sha string) (string, error) {
+	if !r.UseReplaceRefs {
+		return sha, nil
+	}
+	r.replaceOnce.Do(func() {
+		r.replace, r.replaceErr = r.replaceRefs()
+	})
+	if r.replaceErr != nil {
+		return "", r.replaceErr
+	}
+	id := sha
+	for i := 0; i <= maxReplaceDepth; i++ {
+		next, ok := r.replace[id]
+		if !ok {
+			return id, nil
+		}
+		id = next
+	}
+	return "", errors.Errorf("replace depth too high for object %s", sha)
+}
+
+// replaceRefs returns the refs below refs/replace/ as a map of the ids
+// they replace to the ids of their replacements. Refs not named by an
+// object id are ignored, as git ignores them.
+func (r *Repository) replaceRefs() (map[string]string, error) {
+	refs, err := r.refs("refs/replace/")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read replace refs")
+	}
+	replace := make(map[string]string, len(refs))
+	for name, sha := range refs {
+		if isObjectID(name) && isObjectID(sha) {
+			replace[name] = sha
+		}
+	}
+	return replace, nil
+}
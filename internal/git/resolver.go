@@ -0,0 +1,79 @@
+package git
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RefResolver resolves the names of refs to object ids, so refs may be
+// kept outside the repository, for example by a deploy service recording
+// the commit each environment runs.
+type RefResolver interface {
+	// Resolve returns the object id name refers to. name is as given to
+	// ResolveRev, so may be a short name such as master, a fully
+	// qualified one such as refs/heads/master, or one only the resolver
+	// knows, such as production. If the resolver does not know name, the
+	// error satisfies IsNotExist.
+	Resolve(name string) (sha string, err error)
+}
+
+// SetRefResolver sets a RefResolver for ResolveRev to consult before the
+// repository's own refs, its loose refs, packed-refs, or reftables. A
+// name the resolver knows is resolved by it, even if a ref of the same
+// name exists in the repository; names it does not know are resolved
+// from the repository's refs as usual. Object ids, and the upstream of
+// a branch, as master@{upstream}, are not passed to the resolver, nor are
+// the refs read to serve every branch and tag, or advertised to git
+// clients, which are always the repository's own. A nil resolver leaves
+// only the repository's refs. SetRefResolver may be called at any time.
+func (r *Repository) SetRefResolver(rr RefResolver) {
+	r.refResolver.Store(refResolver{rr})
+}
+
+// refResolver holds a RefResolver, which may be nil, in an atomic.Value.
+type refResolver struct {
+	RefResolver
+}
+
+// refResolvers returns the resolvers ResolveRev consults, in order.
+func (r *Repository) refResolvers() []RefResolver {
+	if rr, _ := r.refResolver.Load().(refResolver); rr.RefResolver != nil {
+		return []RefResolver{checkedRefs{rr.RefResolver}, diskRefs{r}}
+	}
+	return []RefResolver{diskRefs{r}}
+}
+
+// diskRefs resolves names from the repository's own refs, in the order
+// used by git rev-parse, as ResolveRef does.
+type diskRefs struct {
+	r *Repository
+}
+
+func (d diskRefs) Resolve(name string) (string, error) {
+	ref, ok := d.r.ResolveRef(name)
+	if !ok {
+		return "", &os.PathError{Op: "resolve", Path: name, Err: os.ErrNotExist}
+	}
+	return d.r.readRef(ref)
+}
+
+// checkedRefs checks the object ids a RefResolver returns are valid.
+type checkedRefs struct {
+	RefResolver
+}
+
+func (c checkedRefs) Resolve(name string) (string, error) {
+	sha, err := c.RefResolver.Resolve(name)
+	if IsNotExist(err) {
+		return "", err
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "could not resolve %q", name)
+	}
+	if !isObjectID(sha) {
+		return "", errors.Errorf("%q resolved to invalid object id %q", name, sha)
+	}
+	return strings.ToLower(sha), nil
+}
@@ -0,0 +1,164 @@
+package git
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// sizeStore is implemented by ObjectStores which can report the length of
+// an object more cheaply than by reading it.
+type sizeStore interface {
+	size(sha string) (int64, error)
+}
+
+// objectSize returns the length of the content of the object sha, without
+// reading the content. Loose objects are preferred to packed objects, and
+// replace refs followed, as by readObject.
+func (r *Repository) objectSize(sha string) (int64, error) {
+	if r.closed {
+		return 0, errors.New("repository closed")
+	}
+	if !isObjectID(sha) {
+		return 0, errors.Errorf("invalid object id %q", sha)
+	}
+	sha, err := r.replacement(sha)
+	if err != nil {
+		return 0, err
+	}
+	m, _ := r.objects().(multiStore)
+	n, err := m.size(sha)
+	if IsNotExist(err) && m.reload() {
+		n, err = m.size(sha)
+	}
+	return n, err
+}
+
+// size returns the length of the object sha in the first store holding
+// it.
+func (m multiStore) size(sha string) (int64, error) {
+	for _, s := range m {
+		var n int64
+		var err error
+		if ss, ok := s.(sizeStore); ok {
+			n, err = ss.size(sha)
+		} else {
+			var h Header
+			var rc io.ReadCloser
+			h, rc, err = s.Get(sha)
+			if err == nil {
+				rc.Close()
+				n = h.Length
+			}
+		}
+		if IsNotExist(err) {
+			continue
+		}
+		return n, err
+	}
+	return 0, notFound(sha)
+}
+
+func (s *packStore) size(sha string) (int64, error) {
+	id, err := hex.DecodeString(sha)
+	if err != nil || len(id) != 20 {
+		return 0, errors.Errorf("invalid object id %q", sha)
+	}
+	packs, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range packs {
+		off, ok, err := p.find(id)
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not search pack %q", p.path)
+		}
+		if !ok {
+			continue
+		}
+		f, err := p.open()
+		if err != nil {
+			return 0, err
+		}
+		return p.objectSize(f, off)
+	}
+	return 0, notFound(sha)
+}
+
+// objectSize returns the length of the object at off. The pack entry
+// header records the length of a deltified entry's delta, not of the
+// object, so the object's length is read from the start of the delta.
+func (p *pack) objectSize(f *os.File, off int64) (int64, error) {
+	typ, length, br, err := p.entry(f, off)
+	if err != nil {
+		return 0, err
+	}
+	switch typ {
+	case objOfsDelta:
+		for {
+			c, err := br.ReadByte()
+			if err != nil {
+				return 0, errors.Wrapf(err, "could not read delta base at offset %d", off)
+			}
+			if c&0x80 == 0 {
+				break
+			}
+		}
+	case objRefDelta:
+		if _, err := br.Discard(20); err != nil {
+			return 0, errors.Wrapf(err, "could not read delta base at offset %d", off)
+		}
+	default:
+		return length, nil
+	}
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not inflate pack entry at offset %d", off)
+	}
+	defer zr.Close()
+	// the delta starts with the lengths of the base, then of the object.
+	dr := bufio.NewReaderSize(zr, 16)
+	var n int64
+	for i := 0; i < 2; i++ {
+		n = 0
+		for shift := uint(0); ; shift += 7 {
+			c, err := dr.ReadByte()
+			if err != nil {
+				return 0, errors.Wrapf(err, "could not read delta at offset %d", off)
+			}
+			n |= int64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				break
+			}
+		}
+	}
+	return n, nil
+}
+
+// Size returns the length of the blob the entry refers to. Only the
+// object's header is read, and for a blob stored as a delta in a pack,
+// the start of the delta; the content is not inflated.
+func (e *Entry) Size() (int64, error) {
+	n, err := e.objectSize(e.id)
+	return n, objectError("blob", e.id, err)
+}
+
+// Size returns the total length of the files and symlinks below this
+// tree, as by Entry.Size. Submodules, whose content is in another
+// repository, are not counted.
+func (t *Tree) Size() (int64, error) {
+	var total int64
+	err := t.Walk(func(_ string, e *Entry) error {
+		if e.Mode.IsDir() || e.Mode&os.ModeIrregular != 0 {
+			return nil
+		}
+		n, err := e.Size()
+		total += n
+		return err
+	})
+	return total, err
+}
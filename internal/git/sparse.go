@@ -0,0 +1,218 @@
+package git
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SparseCheckout reports which paths of a repository's working tree are
+// included in its sparse checkout, as set up by git sparse-checkout, so
+// files outside it may be hidden even if they are present on disk.
+//
+// In cone mode, core.sparseCheckoutCone, the patterns name directories:
+// the files at the root are always included, along with every path below
+// the directories named, and the files directly within their parents.
+// Otherwise, or if the patterns are not in the form cone mode writes, as
+// git does, they are read as gitignore patterns, a path being included
+// if the last pattern to match it, or failing that one of its parent
+// directories, is not negated. A directory is also included if a pattern
+// which is not negated may match a path below it, as /a/b/ includes a
+// and a/b even following !/*/, as git checks out the files below them.
+type SparseCheckout struct {
+	cone bool
+
+	// in cone mode, recursive holds the directories whose every path is
+	// included, and parents those whose files are included.
+	recursive map[string]bool
+	parents   map[string]bool
+
+	// otherwise, patterns are the patterns read, and below matches the
+	// directories a pattern which is not negated may match a path below.
+	patterns []ignorePattern
+	below    []*regexp.Regexp
+}
+
+// SparseCheckout returns the sparse checkout of the repository's working
+// tree, read from $GIT_DIR/info/sparse-checkout, or nil if
+// core.sparseCheckout is not set, or there is no such file, in which case
+// every path is included.
+func (r *Repository) SparseCheckout() (*SparseCheckout, error) {
+	cfg, err := r.worktreeConfig()
+	if err != nil {
+		return nil, err
+	}
+	if on, _ := cfg.Bool("core", "sparseCheckout"); !on {
+		return nil, nil
+	}
+	cone, _ := cfg.Bool("core", "sparseCheckoutCone")
+	file := filepath.Join(r.gitDir(), "info", "sparse-checkout")
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "could not read %q", file)
+	}
+	s := &SparseCheckout{}
+	if cone && s.parseCone(lines) {
+		return s, nil
+	}
+	s.cone = false
+	for _, line := range lines {
+		if pat, ok := parseIgnorePattern(line, ""); ok {
+			s.patterns = append(s.patterns, pat)
+			if re := parentsRegexp(line); re != nil {
+				s.below = append(s.below, re)
+			}
+		}
+	}
+	return s, nil
+}
+
+// parentsRegexp returns a regular expression matching the directories
+// below which the gitignore pattern line may match a path, or nil if the
+// pattern is negated. A pattern without a slash, other than a trailing
+// one, may match at any depth, and so below any directory.
+func parentsRegexp(line string) *regexp.Regexp {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "!") {
+		return nil
+	}
+	line = strings.TrimSuffix(strings.TrimPrefix(line, `\`), "/")
+	if !strings.Contains(line, "/") {
+		return regexp.MustCompile(".*")
+	}
+	// a directory is the parent of a match if it matches the leading
+	// elements of the pattern, or those before a **.
+	elems := strings.Split(strings.TrimPrefix(line, "/"), "/")
+	var expr, end string
+	for i, elem := range elems[:len(elems)-1] {
+		if elem == "**" {
+			if i > 0 {
+				expr += "(?:/.*)?"
+			} else {
+				expr += ".*"
+			}
+			break
+		}
+		if i > 0 {
+			expr += "(?:/"
+			end += ")?"
+		}
+		expr += globRegexp(elem)
+	}
+	re, err := regexp.Compile("^(?:" + expr + end + ")$")
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// parseCone reads lines as cone mode patterns, reporting false if any is
+// not of the form cone mode writes: "/*" and "!/*/" for the files at the
+// root, "/dir/" for a directory, and "!/dir/*/" following it if only the
+// files directly in dir are included.
+func (s *SparseCheckout) parseCone(lines []string) bool {
+	s.cone = true
+	s.recursive = make(map[string]bool)
+	s.parents = make(map[string]bool)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", line[0] == '#', line == "/*", line == "!/*/":
+		case strings.HasPrefix(line, "!/") && strings.HasSuffix(line, "/*/") && len(line) > len("!//*/"):
+			dir := unescapeCone(line[len("!/") : len(line)-len("/*/")])
+			if !s.recursive[dir] {
+				return false
+			}
+			delete(s.recursive, dir)
+			s.parents[dir] = true
+		case strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") && len(line) > len("//"):
+			s.recursive[unescapeCone(line[1:len(line)-1])] = true
+		default:
+			return false
+		}
+	}
+	// the directories leading to those included are always written as
+	// parents, but are added in case they were not.
+	for dir := range s.recursive {
+		for d := path.Dir(dir); d != "."; d = path.Dir(d) {
+			s.parents[d] = true
+		}
+	}
+	return true
+}
+
+// unescapeCone removes the backslashes git sparse-checkout adds before
+// the glob characters in the directory names of cone mode patterns.
+func unescapeCone(dir string) string {
+	var buf strings.Builder
+	for i := 0; i < len(dir); i++ {
+		if dir[i] == '\\' && i+1 < len(dir) {
+			i++
+		}
+		buf.WriteByte(dir[i])
+	}
+	return buf.String()
+}
+
+// parent reports whether a pattern which is not negated may match a path
+// below the directory p, outside cone mode.
+func (s *SparseCheckout) parent(p string) bool {
+	for _, re := range s.below {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Included reports whether the slash separated path p, relative to the
+// root of the working tree, is included in the sparse checkout. isDir
+// reports whether p is a directory. If s is nil, every path is included.
+//
+// Outside cone mode, a directory no pattern decides on is included, as
+// paths below it may be, as is one excluded if a pattern which is not
+// negated may include a path below it.
+func (s *SparseCheckout) Included(p string, isDir bool) bool {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if s == nil || p == "" {
+		return true
+	}
+	if !s.cone {
+		for q, dir := p, isDir; q != "."; q, dir = path.Dir(q), true {
+			if matched, negate := lastMatch(s.patterns, q, dir); matched {
+				return !negate || isDir && s.parent(p)
+			}
+		}
+		return isDir
+	}
+	if isDir && s.parents[p] {
+		return true
+	}
+	dir := path.Dir(p)
+	if !isDir && (dir == "." || s.parents[dir]) {
+		return true
+	}
+	for d := p; d != "."; d = path.Dir(d) {
+		if s.recursive[d] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package git
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// stashRef is the ref git stash records stashed states in.
+const stashRef = "refs/stash"
+
+// Stashes returns the commits recording the states of the working tree
+// stashed by git stash, newest first, so the commit at index n is
+// stash@{n}. The tree of each commit is the stashed working tree; its
+// first parent is the commit stashed on, and its second the stashed
+// index. The stashes are found from the reflog of refs/stash, or if it
+// has none, as when the repository stores its refs in reftables, only
+// the commit refs/stash names is returned. A repository with no stash
+// has no stashes, and no error is returned.
+func (r *Repository) Stashes() ([]*Commit, error) {
+	if !r.RefExists(stashRef) {
+		return nil, nil
+	}
+	ids, err := r.reflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		sha, err := r.readRef(stashRef)
+		if err != nil {
+			return nil, err
+		}
+		ids = []string{sha}
+	}
+	stashes := make([]*Commit, 0, len(ids))
+	for _, sha := range ids {
+		c, err := r.Commit(sha)
+		if err != nil {
+			return nil, err
+		}
+		stashes = append(stashes, c)
+	}
+	return stashes, nil
+}
+
+// resolveStash returns the id of the commit of stash@{n}, where n is the
+// text between the braces of rev.
+func (r *Repository) resolveStash(rev, n string) (string, error) {
+	i, err := strconv.Atoi(n)
+	if err != nil || i < 0 {
+		return "", errors.Errorf("could not resolve %q: invalid stash index %q", rev, n)
+	}
+	stashes, err := r.Stashes()
+	if err != nil {
+		return "", err
+	}
+	if i >= len(stashes) {
+		return "", errors.Errorf("could not resolve %q: there are %d stashes", rev, len(stashes))
+	}
+	return stashes[i].String(), nil
+}
@@ -0,0 +1,393 @@
+package git
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ObjectStore is a source of git objects. A Repository reads its objects
+// from the loose objects and packs of its object directories, unless
+// given another store by SetObjectStore, and is itself an ObjectStore.
+type ObjectStore interface {
+	// Get returns the Header and an io.ReadCloser for the contents of
+	// the object sha. If the store does not hold the object the error
+	// satisfies IsNotExist.
+	Get(sha string) (Header, io.ReadCloser, error)
+
+	// Has reports whether the store holds the object sha, without
+	// reading it.
+	Has(sha string) bool
+}
+
+// notFound returns an error, satisfying IsNotExist, for the missing object sha.
+func notFound(sha string) error {
+	return &os.PathError{
+		Op:   "open",
+		Path: sha,
+		Err:  os.ErrNotExist,
+	}
+}
+
+// looseStore is an ObjectStore holding loose objects in an objects directory.
+type looseStore struct {
+	dir string
+}
+
+func (s *looseStore) path(sha string) string {
+	return filepath.Join(s.dir, sha[0:2], sha[2:])
+}
+
+func (s *looseStore) Get(sha string) (Header, io.ReadCloser, error) {
+	f, err := os.Open(s.path(sha))
+	if err != nil {
+		return Header{}, nil, errors.WithStack(err)
+	}
+	in, err := newInflater(f)
+	if err != nil {
+		f.Close()
+		return Header{}, nil, errors.WithStack(err)
+	}
+	h, err := readHeader(in)
+	if err != nil {
+		in.Close()
+		return Header{}, nil, err
+	}
+	return h, &sizedReader{ReadCloser: in, remaining: h.Length}, nil
+}
+
+// sizedReader reads the content of an object whose length, from its
+// header, is remaining. It returns io.ErrUnexpectedEOF if the content
+// ends early, and an error if there is more content than the header
+// describes, rather than silently returning the content read. Reading
+// to the end of a zlib stream verifies its checksum, so reading every
+// object to io.EOF through a sizedReader also detects corrupt streams.
+type sizedReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		var extra [1]byte
+		n, err := r.ReadCloser.Read(extra[:])
+		if n > 0 {
+			return 0, errors.New("object is longer than its header")
+		}
+		if err != nil && err != io.EOF {
+			return 0, errors.WithStack(err)
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF {
+		if r.remaining > 0 {
+			return n, errors.Wrap(io.ErrUnexpectedEOF, "object is shorter than its header")
+		}
+		// the stream ended, and was verified, with the last byte.
+		err = nil
+		if n == 0 {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+func (s *looseStore) Has(sha string) bool {
+	_, err := os.Stat(s.path(sha))
+	return err == nil
+}
+
+// packStore is an ObjectStore holding the packs in an objects directory.
+// The pack indexes are read on first use. Packs written later, as by git
+// gc or git repack, are found when the directory is scanned again, see
+// reload. A pack whose index cannot be read is skipped, so the objects
+// of the other packs can still be read, and the error is reported by
+// VerifyPacks.
+type packStore struct {
+	dir string
+
+	// base resolves REF_DELTA bases which are not in the same pack.
+	base ObjectStore
+
+	// limit returns the length above which deltified objects are
+	// reconstructed in temporary files, see SetMaxDeltaMemory.
+	limit func() int64
+
+	mu      sync.Mutex
+	loaded  bool
+	packs   []*pack
+	removed []*pack          // packs no longer in dir, closed by Close
+	failed  map[string]error // errors reading indexes, by path
+
+	// modTime is the modification time of the pack directory when it was
+	// last scanned, at scanned.
+	modTime, scanned time.Time
+}
+
+// racyPackDir is how long after the pack directory was modified a scan of
+// it may have missed a pack written in the same tick of the clock of a
+// file system recording modification times to the second.
+const racyPackDir = 2 * time.Second
+
+// load returns the packs in the store, reading their indexes on first
+// use.
+func (s *packStore) load() ([]*pack, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded {
+		if _, err := s.scan(); err != nil {
+			return nil, err
+		}
+	}
+	return s.packs, nil
+}
+
+// reload scans the pack directory again, as git does before deciding an
+// object is missing, and reports whether the packs in the store changed.
+// Adding or removing a pack modifies the directory, so it is not scanned
+// if its modification time is unchanged since the last scan, unless that
+// was too soon after the time to be sure, see racyPackDir.
+func (s *packStore) reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded && !s.modTime.IsZero() {
+		fi, err := os.Stat(filepath.Join(s.dir, "pack"))
+		if err == nil && fi.ModTime().Equal(s.modTime) && s.scanned.Sub(s.modTime) > racyPackDir {
+			return false, nil
+		}
+	}
+	return s.scan()
+}
+
+// scan reads the indexes of the packs in the store not already loaded,
+// and drops those whose index has been removed, reporting whether there
+// were any. The files of dropped packs may still be being read, so they
+// are closed by Close, rather than now. s.mu must be held.
+func (s *packStore) scan() (bool, error) {
+	// the directory is examined before it is read, so a pack added while
+	// it is being read changes its modification time from that recorded.
+	s.modTime, s.scanned = time.Time{}, time.Now()
+	if fi, err := os.Stat(filepath.Join(s.dir, "pack")); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	matches, err := filepath.Glob(filepath.Join(s.dir, "pack", "*.idx"))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	loaded := make(map[string]*pack, len(s.packs))
+	for _, p := range s.packs {
+		loaded[p.path] = p
+	}
+	changed := false
+	packs := make([]*pack, 0, len(matches))
+	failed := make(map[string]error)
+	for _, m := range matches {
+		p, ok := loaded[strings.TrimSuffix(m, ".idx")+".pack"]
+		switch {
+		case ok:
+			delete(loaded, p.path)
+		case s.failed[m] != nil:
+			// packs are named by their content, so an index which could
+			// not be read is not read again.
+			failed[m] = s.failed[m]
+			continue
+		default:
+			if p, err = openPack(m); err != nil {
+				failed[m] = err
+				continue
+			}
+			changed = true
+		}
+		packs = append(packs, p)
+	}
+	for _, p := range s.packs {
+		if _, ok := loaded[p.path]; ok {
+			s.removed = append(s.removed, p)
+			changed = true
+		}
+	}
+	s.packs, s.failed, s.loaded = packs, failed, true
+	return changed, nil
+}
+
+// indexErrors returns the errors reading the indexes of the packs skipped by
+// the store, in order of their paths.
+func (s *packStore) indexErrors() ([]error, error) {
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.failed))
+	for path := range s.failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		errs[i] = s.failed[path]
+	}
+	return errs, nil
+}
+
+func (s *packStore) Get(sha string) (Header, io.ReadCloser, error) {
+	id, err := hex.DecodeString(sha)
+	if err != nil || len(id) != 20 {
+		return Header{}, nil, errors.Errorf("invalid object id %q", sha)
+	}
+	packs, err := s.load()
+	if err != nil {
+		return Header{}, nil, err
+	}
+	for _, p := range packs {
+		off, ok, err := p.find(id)
+		if err != nil {
+			return Header{}, nil, errors.Wrapf(err, "could not search pack %q", p.path)
+		}
+		if ok {
+			return p.readObject(s.base, off, s.limit())
+		}
+	}
+	return Header{}, nil, notFound(sha)
+}
+
+func (s *packStore) Has(sha string) bool {
+	id, err := hex.DecodeString(sha)
+	if err != nil || len(id) != 20 {
+		return false
+	}
+	packs, err := s.load()
+	if err != nil {
+		return false
+	}
+	for _, p := range packs {
+		if _, ok, _ := p.find(id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes any open pack files.
+func (s *packStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, p := range append(s.packs, s.removed...) {
+		if cerr := p.close(); err == nil {
+			err = cerr
+		}
+	}
+	s.packs, s.removed, s.loaded = nil, nil, false
+	return errors.WithStack(err)
+}
+
+// multiStore is an ObjectStore which consults each of its stores in turn.
+type multiStore []ObjectStore
+
+// Get returns the object sha from the first store holding it. If no store
+// holds it, the pack directories modified since they were last scanned
+// are scanned again, and if the packs have changed the stores are
+// consulted once more, so objects moved into new packs by git gc or git
+// repack, and those fetched since, are found.
+func (m multiStore) Get(sha string) (Header, io.ReadCloser, error) {
+	h, rc, err := m.get(sha)
+	if IsNotExist(err) && m.reload() {
+		h, rc, err = m.get(sha)
+	}
+	return h, rc, err
+}
+
+func (m multiStore) get(sha string) (Header, io.ReadCloser, error) {
+	for _, s := range m {
+		h, rc, err := s.Get(sha)
+		if IsNotExist(err) {
+			continue
+		}
+		return h, rc, err
+	}
+	return Header{}, nil, notFound(sha)
+}
+
+func (m multiStore) Has(sha string) bool {
+	return m.has(sha) || m.reload() && m.has(sha)
+}
+
+func (m multiStore) has(sha string) bool {
+	for _, s := range m {
+		if s.Has(sha) {
+			return true
+		}
+	}
+	return false
+}
+
+// reload scans the pack directories again, see packStore.reload, and
+// reports whether any packs were added or removed. A directory which
+// cannot be scanned is left as it was.
+func (m multiStore) reload() bool {
+	changed := false
+	for _, s := range m {
+		if ps, ok := s.(*packStore); ok {
+			c, err := ps.reload()
+			changed = changed || (c && err == nil)
+		}
+	}
+	return changed
+}
+
+// Close closes each store that is an io.Closer.
+func (m multiStore) Close() error {
+	var err error
+	for _, s := range m {
+		if c, ok := s.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// SetObjectStore makes the repository read its objects from s, rather
+// than from its object directories, as when the objects are held
+// elsewhere, or to test how failures reading them are handled. Close
+// closes s if it is an io.Closer. SetObjectStore must be called before
+// the repository is used.
+func (r *Repository) SetObjectStore(s ObjectStore) {
+	r.storeOnce.Do(func() {})
+	// the store is consulted as the only member of a multiStore, so
+	// objectSize and ReadRaw fall back to Get.
+	m, ok := s.(multiStore)
+	if !ok {
+		m = multiStore{s}
+	}
+	r.store = m
+}
+
+// objects returns the repository's ObjectStore. Unless set by
+// SetObjectStore, it consults the loose objects, then the packs, of the
+// primary object directory, followed by those of each alternate object
+// directory.
+func (r *Repository) objects() ObjectStore {
+	r.storeOnce.Do(func() {
+		var m multiStore
+		for _, dir := range r.objectDirs() {
+			m = append(m, &looseStore{dir: dir}, &packStore{dir: dir, base: r, limit: r.deltaLimit})
+		}
+		r.store = m
+	})
+	return r.store
+}
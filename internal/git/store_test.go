@@ -0,0 +1,405 @@
+package git
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/gittest"
+	"github.com/pkg/errors"
+)
+
+// emptyRepo returns a new bare repository in dir, with no objects.
+func emptyRepo(t testing.TB, dir string) string {
+	t.Helper()
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	gitDir := filepath.Join(dir, "repo.git")
+	gitOutput(t, dir, "init", "-q", "--bare", gitDir)
+	return gitDir
+}
+
+// looseObject returns the id of the object of the given kind and content,
+// and the object as git stores it, before compression.
+func looseObject(kind string, content []byte) (string, []byte) {
+	raw := append([]byte(fmt.Sprintf("%s %d\x00", kind, len(content))), content...)
+	sum := sha1.Sum(raw)
+	return hex.EncodeToString(sum[:]), raw
+}
+
+// writeLoose writes the loose object file of the object sha to the
+// repository at gitDir.
+func writeLoose(t *testing.T, gitDir, sha string, file []byte) {
+	t.Helper()
+	path := filepath.Join(gitDir, "objects", sha[:2], sha[2:])
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, file, 0444); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compress returns buf compressed by compress/zlib at level.
+func compress(t *testing.T, buf []byte, level int) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&out, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(buf)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// compressWindow returns buf compressed as a zlib stream whose header
+// gives a window of 1<<(8+cinfo) bytes. buf must be no longer than the
+// window.
+func compressWindow(t *testing.T, buf []byte, cinfo byte) []byte {
+	t.Helper()
+	cmf := cinfo<<4 | 8 // deflate
+	flg := byte(31 - (uint(cmf)<<8)%31)
+	if flg == 31 {
+		flg = 0
+	}
+	out := bytes.NewBuffer([]byte{cmf, flg})
+	fw, err := flate.NewWriter(out, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(buf)
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	binary.Write(out, binary.BigEndian, adler32.Checksum(buf))
+	return out.Bytes()
+}
+
+// readContent reads the object sha from r, returning its kind and content.
+func readContent(r *Repository, sha string) (string, []byte, error) {
+	kind, _, rc, err := r.ObjectReader(sha)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rc.Close()
+	content, err := ioutil.ReadAll(rc)
+	return kind, content, err
+}
+
+// TestLooseCompression checks loose objects are read whatever the level
+// and window size of their compression, as written by implementations
+// other than git.
+func TestLooseCompression(t *testing.T) {
+	gitDir := emptyRepo(t, t.TempDir())
+	// short enough for the smallest window of 256 bytes.
+	content := []byte(strings.Repeat("hello, world\n", 15))
+
+	type stream struct {
+		name string
+		file func(raw []byte) []byte
+	}
+	var streams []stream
+	for level := zlib.HuffmanOnly; level <= zlib.BestCompression; level++ {
+		level := level
+		streams = append(streams, stream{fmt.Sprintf("level %d", level), func(raw []byte) []byte {
+			return compress(t, raw, level)
+		}})
+	}
+	for cinfo := byte(0); cinfo <= 7; cinfo++ {
+		cinfo := cinfo
+		streams = append(streams, stream{fmt.Sprintf("window %d", 1<<(8+cinfo)), func(raw []byte) []byte {
+			return compressWindow(t, raw, cinfo)
+		}})
+	}
+
+	var ids []string
+	for i, s := range streams {
+		// each stream holds a different object, so has a different id.
+		sha, raw := looseObject("blob", append(content, byte('a'+i)))
+		writeLoose(t, gitDir, sha, s.file(raw))
+		ids = append(ids, sha)
+	}
+	r, err := Open(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i, s := range streams {
+		kind, got, err := readContent(r, ids[i])
+		if want := append(content, byte('a'+i)); err != nil || kind != "blob" || !bytes.Equal(got, want) {
+			t.Errorf("%s: got %s %q, %v", s.name, kind, got, err)
+		}
+	}
+}
+
+// TestLooseCorrupt checks loose objects which cannot be read give an
+// error, rather than a panic or a short read.
+func TestLooseCorrupt(t *testing.T) {
+	content := []byte(strings.Repeat("hello, world\n", 100))
+	sha, raw := looseObject("blob", content)
+	good := compress(t, raw, zlib.DefaultCompression)
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"not zlib", raw},
+		{"bad header checksum", corrupt(func(b []byte) []byte { b[1]++; return b })},
+		{"window too large", corrupt(func(b []byte) []byte { b[0] = 8<<4 | 8; return b })},
+		{"truncated", good[:len(good)/2]},
+		{"no checksum", good[:len(good)-4]},
+		{"bad checksum", corrupt(func(b []byte) []byte { b[len(b)-1]++; return b })},
+		{"bad data", corrupt(func(b []byte) []byte { b[len(b)/2] ^= 0xff; return b })},
+		{"short content", compress(t, raw[:len(raw)-1], zlib.DefaultCompression)},
+		{"long content", compress(t, append(raw, '!'), zlib.DefaultCompression)},
+		{"no object header", compress(t, content, zlib.DefaultCompression)},
+		{"bad object header", compress(t, []byte("blob 1x\x00"), zlib.DefaultCompression)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitDir := emptyRepo(t, t.TempDir())
+			writeLoose(t, gitDir, sha, tt.file)
+			r, err := Open(gitDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, got, err := readContent(r, sha); err == nil {
+				t.Errorf("read %d bytes, want error", len(got))
+			}
+		})
+	}
+}
+
+// mapStore is an ObjectStore holding objects in memory, by id.
+type mapStore map[string]mapObject
+
+type mapObject struct {
+	kind    string
+	content []byte
+}
+
+// add adds the object of the given kind and content to s, returning its
+// id.
+func (s mapStore) add(kind string, content []byte) string {
+	sha, _ := looseObject(kind, content)
+	s[sha] = mapObject{kind, content}
+	return sha
+}
+
+func (s mapStore) Get(sha string) (Header, io.ReadCloser, error) {
+	o, ok := s[sha]
+	if !ok {
+		return Header{}, nil, notFound(sha)
+	}
+	return Header{Kind: o.kind, Length: int64(len(o.content))}, ioutil.NopCloser(bytes.NewReader(o.content)), nil
+}
+
+func (s mapStore) Has(sha string) bool {
+	_, ok := s[sha]
+	return ok
+}
+
+// TestSetObjectStore checks a repository reads its commits, trees, and
+// blobs from a store given by SetObjectStore, rather than from disk.
+func TestSetObjectStore(t *testing.T) {
+	r, err := Open(emptyRepo(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := make(mapStore)
+	blob := s.add("blob", []byte("hello, world\n"))
+	id, _ := hex.DecodeString(blob)
+	tree := s.add("tree", append([]byte("100644 hello.txt\x00"), id...))
+	commit := s.add("commit", []byte("tree "+tree+"\n"+
+		"author A U Thor <author@example.com> 1112911993 +0000\n"+
+		"committer C O Mitter <committer@example.com> 1112911993 +0000\n\nhello\n"))
+	r.SetObjectStore(s)
+
+	c, err := r.Commit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Message != "hello\n" {
+		t.Errorf("Message: got %q, want %q", c.Message, "hello\n")
+	}
+	root, err := c.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.ID() != tree {
+		t.Errorf("Tree: got %s, want %s", root.ID(), tree)
+	}
+	e, err := root.Lookup("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := e.Size(); err != nil || n != 13 {
+		t.Errorf("Size: got %d, %v, want 13, nil", n, err)
+	}
+	b, err := e.Blob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got, err := ioutil.ReadAll(b); err != nil || string(got) != "hello, world\n" {
+		t.Errorf("Blob: got %q, %v, want %q", got, err, "hello, world\n")
+	}
+	if !r.Has(blob) {
+		t.Errorf("Has(%s): got false, want true", blob)
+	}
+	missing := strings.Repeat("0", 40)
+	if r.Has(missing) {
+		t.Errorf("Has(%s): got true, want false", missing)
+	}
+	if _, err := r.Blob(missing); !IsNotExist(err) {
+		t.Errorf("Blob(%s): got %v, want an error satisfying IsNotExist", missing, err)
+	}
+}
+
+// errStore is an ObjectStore which holds no objects, and fails to read
+// any with err.
+type errStore struct{ err error }
+
+func (s errStore) Get(sha string) (Header, io.ReadCloser, error) { return Header{}, nil, s.err }
+func (s errStore) Has(sha string) bool                           { return false }
+
+// TestMultiStore checks a multiStore returns each object from the first
+// store holding it, passes over stores which do not hold it, and stops at
+// a store which fails to read it.
+func TestMultiStore(t *testing.T) {
+	first, second := make(mapStore), make(mapStore)
+	both := first.add("blob", []byte("both\n"))
+	second[both] = mapObject{"blob", []byte("not first\n")}
+	only := second.add("blob", []byte("second\n"))
+	failed := errors.New("read failed")
+
+	tests := []struct {
+		name string
+		m    multiStore
+		sha  string
+		want Header
+		err  func(error) bool
+	}{
+		{"first", multiStore{first, second}, both, Header{"blob", 5}, nil},
+		{"fallthrough", multiStore{first, second}, only, Header{"blob", 7}, nil},
+		{"missing", multiStore{first, second}, strings.Repeat("0", 40), Header{}, IsNotExist},
+		{"empty", nil, both, Header{}, IsNotExist},
+		{"error", multiStore{errStore{failed}, second}, only, Header{}, func(err error) bool { return err == failed }},
+		{"not exist", multiStore{errStore{notFound(only)}, second}, only, Header{"blob", 7}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, rc, err := tt.m.Get(tt.sha)
+			if tt.err != nil {
+				if !tt.err(err) {
+					t.Fatalf("Get: got %v, want error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc.Close()
+			if h != tt.want {
+				t.Errorf("Get: got %+v, want %+v", h, tt.want)
+			}
+			if !tt.m.Has(tt.sha) {
+				t.Errorf("Has: got false, want true")
+			}
+		})
+	}
+}
+
+// faultStore is an ObjectStore which fails to read the objects in fail
+// with their error, and reads all others from the store it wraps.
+type faultStore struct {
+	ObjectStore
+	fail map[string]error
+}
+
+func (s *faultStore) Get(sha string) (Header, io.ReadCloser, error) {
+	if err, ok := s.fail[sha]; ok {
+		return Header{}, nil, err
+	}
+	return s.ObjectStore.Get(sha)
+}
+
+// TestTransientErrors checks I/O errors reading objects, as from a
+// network file system, are reported as transient, not as missing objects.
+func TestTransientErrors(t *testing.T) {
+	r, err := Open(emptyRepo(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := make(mapStore)
+	blob := s.add("blob", []byte("hello, world\n"))
+	id, _ := hex.DecodeString(blob)
+	sub := s.add("tree", append([]byte("100644 hello.txt\x00"), id...))
+	subID, _ := hex.DecodeString(sub)
+	tree := s.add("tree", append(append([]byte("100644 hello.txt\x00"), id...), append([]byte("40000 sub\x00"), subID...)...))
+	commit := s.add("commit", []byte("tree "+tree+"\n"+
+		"author A U Thor <author@example.com> 1112911993 +0000\n"+
+		"committer C O Mitter <committer@example.com> 1112911993 +0000\n\nhello\n"))
+	r.SetObjectStore(&faultStore{ObjectStore: s, fail: map[string]error{
+		blob: &os.PathError{Op: "read", Path: blob, Err: syscall.EIO},
+		sub:  &os.PathError{Op: "open", Path: sub, Err: syscall.ESTALE},
+	}})
+
+	c, err := r.Commit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := c.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(what string, err error) {
+		t.Helper()
+		if !IsTransient(err) || IsNotExist(err) {
+			t.Errorf("%s: got %v, want an error satisfying IsTransient, not IsNotExist", what, err)
+		}
+	}
+	e, err := root.Lookup("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Blob()
+	check("Blob", err)
+	_, err = root.Lookup("sub/hello.txt")
+	check("Lookup", err)
+	_, _, err = root.Exists("sub/hello.txt")
+	check("Exists", err)
+	_, err = root.Tree("sub")
+	check("Tree", err)
+
+	// objects that are missing are still reported as such.
+	if _, _, err := root.Exists("nope"); err != nil {
+		t.Errorf("Exists(nope): got %v, want nil", err)
+	}
+	if _, err := r.Blob(strings.Repeat("0", 40)); !IsNotExist(err) {
+		t.Errorf("Blob: got %v, want an error satisfying IsNotExist", err)
+	}
+}
@@ -0,0 +1,197 @@
+package git
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Tag represents an annotated tag object.
+type Tag struct {
+	*Repository
+
+	// id is the SHA1 of this tag
+	id string
+
+	// Object is the id of the tagged object, and Type its kind,
+	// usually commit.
+	Object string
+	Type   string
+
+	// Name is the name of the tag, without the refs/tags/ prefix.
+	Name string
+
+	// Tagger is the identity of the person who created the tag, followed
+	// by the time it was created, as recorded in the tag object.
+	Tagger string
+
+	// Message is the tag message, including any signature.
+	Message string
+}
+
+// ID returns the SHA1 of this tag.
+func (t *Tag) ID() string { return t.id }
+
+// Tag returns the annotated tag matching the supplied id.
+func (r *Repository) Tag(sha string) (*Tag, error) {
+	h, rc, err := r.readObject(sha)
+	if err != nil {
+		return nil, objectError("tag", sha, err)
+	}
+	defer rc.Close()
+	if h.Kind != "tag" {
+		return nil, objectError("tag", sha, errors.Errorf("expected tag, got %q", h.Kind))
+	}
+	t := Tag{
+		Repository: r,
+		id:         sha,
+	}
+	if _, err := t.parseTag(rc); err != nil {
+		return nil, objectError("tag", sha, err)
+	}
+	return &t, nil
+}
+
+// parseTag parses a tag object from the supplied io.Reader.
+func (t *Tag) parseTag(r io.Reader) (*Tag, error) {
+	br := bufio.NewReader(r)
+	for {
+		s, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+		s = strings.TrimSuffix(s, "\n")
+		if s == "" {
+			// end of headers, the message follows.
+			break
+		}
+		if i := strings.Index(s, " "); i > 0 {
+			switch s[:i] {
+			case "object":
+				t.Object = s[i+1:]
+			case "type":
+				t.Type = s[i+1:]
+			case "tag":
+				t.Name = s[i+1:]
+			case "tagger":
+				t.Tagger = s[i+1:]
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	msg, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	t.Message = string(msg)
+	if !isObjectID(t.Object) {
+		return nil, errors.New("tag has no object")
+	}
+	return t, nil
+}
+
+// maxTagDepth limits the number of tags followed by Peel.
+const maxTagDepth = 10
+
+// Peel returns the id of the object sha refers to once any annotated
+// tags, including tags of tags, have been followed. The id of an object
+// which is not a tag is returned unchanged.
+func (r *Repository) Peel(sha string) (string, error) {
+	for i := 0; i < maxTagDepth; i++ {
+		kind, err := r.Type(sha)
+		if err != nil {
+			return "", err
+		}
+		if kind != "tag" {
+			return sha, nil
+		}
+		t, err := r.Tag(sha)
+		if err != nil {
+			return "", err
+		}
+		sha = t.Object
+	}
+	return "", errors.Errorf("too many levels of tags peeling %q", sha)
+}
+
+// Branches returns the branches of the repository as a map of branch
+// names, without the refs/heads/ prefix, to commit ids.
+func (r *Repository) Branches() (map[string]string, error) {
+	return r.refs("refs/heads/")
+}
+
+// Tags returns the tags of the repository as a map of tag names, without
+// the refs/tags/ prefix, to object ids. The id of an annotated tag is that
+// of the tag object; use Peel to find the tagged commit.
+func (r *Repository) Tags() (map[string]string, error) {
+	return r.refs("refs/tags/")
+}
+
+// refs returns the refs below prefix, loose or packed, as a map of names
+// relative to prefix to object ids. Loose refs take precedence over
+// packed refs of the same name. If the repository stores its refs in
+// reftables, they are read from those instead.
+func (r *Repository) refs(prefix string) (map[string]string, error) {
+	if r.usesReftable() {
+		all, err := r.reftableRefs()
+		if err != nil {
+			return nil, err
+		}
+		refs := make(map[string]string)
+		for name, rec := range all {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			sha := rec.id
+			if rec.symref != "" {
+				if sha, err = r.readRef(name); err != nil {
+					return nil, err
+				}
+			}
+			refs[name[len(prefix):]] = sha
+		}
+		return refs, nil
+	}
+	packed, err := r.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for name, sha := range packed {
+		if strings.HasPrefix(name, prefix) {
+			refs[name[len(prefix):]] = sha
+		}
+	}
+	root := r.refPath(strings.TrimSuffix(prefix, "/"))
+	err = filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if p == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasSuffix(p, ".lock") {
+			// skip directories, and refs git is part way through updating.
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		sha, err := r.readRef(prefix + name)
+		if err != nil {
+			return err
+		}
+		refs[name] = sha
+		return nil
+	})
+	return refs, errors.WithStack(err)
+}
@@ -0,0 +1,38 @@
+package git
+
+// Preload reads every tree below t, and from then on keeps the trees the
+// repository reads, so that later lookups and listings, of this or any
+// other tree, do not read and parse them again. It returns the number of
+// entries below t. The cache is never trimmed, so memory use grows with
+// the number of distinct trees read.
+func (t *Tree) Preload() (int, error) {
+	t.treesMu.Lock()
+	if t.trees == nil {
+		t.trees = make(map[string]*Tree)
+	}
+	t.treesMu.Unlock()
+	t.cacheTree(t)
+	n := 0
+	err := t.Walk(func(string, *Entry) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// cachedTree returns the tree sha if it has been cached, otherwise nil.
+func (r *Repository) cachedTree(sha string) *Tree {
+	r.treesMu.RLock()
+	defer r.treesMu.RUnlock()
+	return r.trees[sha]
+}
+
+// cacheTree adds t to the cache of parsed trees, if Preload has enabled
+// it.
+func (r *Repository) cacheTree(t *Tree) {
+	r.treesMu.Lock()
+	defer r.treesMu.Unlock()
+	if r.trees != nil {
+		r.trees[t.id] = t
+	}
+}
@@ -0,0 +1,99 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// VerifyPacks checks the packs of the repository, and of its alternates,
+// are intact, returning the number checked. Each pack must match the
+// checksum at its end, and its index, and the entry of each object must
+// match the CRC-32 the index records for it. Every pack is read in full,
+// which takes time in proportion to the size of the repository. If a
+// pack, or its index, is damaged, the error satisfies IsCorrupt. Loose
+// objects are not checked.
+func (r *Repository) VerifyPacks() (int, error) {
+	if r.closed {
+		return 0, errors.New("repository closed")
+	}
+	var n int
+	m, _ := r.objects().(multiStore)
+	for _, s := range m {
+		ps, ok := s.(*packStore)
+		if !ok {
+			continue
+		}
+		// packs whose index could not be read are skipped when reading
+		// objects.
+		errs, err := ps.indexErrors()
+		if err != nil {
+			return n, err
+		}
+		if len(errs) > 0 {
+			return n, errs[0]
+		}
+		packs, err := ps.load()
+		if err != nil {
+			return n, err
+		}
+		for _, p := range packs {
+			if err := p.verify(); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// verify checks the content of the pack against its checksum, and the
+// entry of each object against its CRC-32.
+func (p *pack) verify() error {
+	f, err := p.open()
+	if err != nil {
+		return err
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, p.size-20)); err != nil {
+		return errors.Wrapf(err, "could not read pack %q", p.path)
+	}
+	if !bytes.Equal(h.Sum(nil), p.checksum) {
+		return corrupt("pack %q checksum mismatch", p.path)
+	}
+
+	// entries are not recorded in order of offset, nor are their lengths,
+	// each entry ends where the next begins.
+	n := len(p.names) / 20
+	order := make([]int, n)
+	offsets := make([]int64, n)
+	for i := range order {
+		order[i] = i
+		if offsets[i], err = p.offset(i); err != nil {
+			return err
+		}
+	}
+	sort.Slice(order, func(a, b int) bool { return offsets[order[a]] < offsets[order[b]] })
+	for j, i := range order {
+		start, end := offsets[i], p.size-20
+		if j+1 < n {
+			end = offsets[order[j+1]]
+		}
+		if start < 12 || end <= start {
+			return corrupt("pack %q entry offset %d out of range", p.path, start)
+		}
+		crc := crc32.NewIEEE()
+		if _, err := io.Copy(crc, io.NewSectionReader(f, start, end-start)); err != nil {
+			return errors.Wrapf(err, "could not read pack %q", p.path)
+		}
+		if crc.Sum32() != binary.BigEndian.Uint32(p.crcs[i*4:]) {
+			return corrupt("pack %q entry of %x at offset %d damaged", p.path, p.names[i*20:(i+1)*20], start)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package git
+
+// SetWarnFunc sets the function called to report oddities found reading
+// the repository which are not errors, as a tree whose entries are not in
+// the order git writes them. Each is reported once. Warnings are
+// discarded unless a function is set. SetWarnFunc must be called before
+// the repository is used.
+func (r *Repository) SetWarnFunc(fn func(format string, args ...interface{})) {
+	r.warnMu.Lock()
+	defer r.warnMu.Unlock()
+	r.warn = fn
+}
+
+// warnf reports a warning, formatted as by fmt.Sprintf, with the function
+// set by SetWarnFunc, unless one has been reported for key.
+func (r *Repository) warnf(key, format string, args ...interface{}) {
+	r.warnMu.Lock()
+	defer r.warnMu.Unlock()
+	if r.warn == nil || r.warned[key] {
+		return
+	}
+	if r.warned == nil {
+		r.warned = make(map[string]bool)
+	}
+	r.warned[key] = true
+	r.warn(format, args...)
+}
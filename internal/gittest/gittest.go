@@ -0,0 +1,181 @@
+// Package gittest builds repositories with the git command, for tests
+// comparing what gitdav reads with what git itself reads.
+package gittest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The layouts of the object store of a repository built by New.
+const (
+	Loose  = "loose"  // every object loose, every ref a file
+	Packed = "packed" // every object packed by git gc, every ref in packed-refs
+	Delta  = "delta"  // every object packed in long delta chains, every ref in packed-refs
+	Mixed  = "mixed"  // objects packed, then a further commit left loose
+)
+
+// Layouts are the layouts of the object store New builds.
+var Layouts = []string{Loose, Packed, Delta, Mixed}
+
+// Commits is the number of commits New makes, before any left loose by
+// the Mixed layout.
+const Commits = 6
+
+// Installed reports whether the git command can be run.
+func Installed() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+// Command returns a command running git with args in dir, isolated from
+// the system and user configuration, with its author and committer set
+// so the ids of the commits it makes are the same on every run.
+func Command(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"HOME="+dir,
+		"GIT_AUTHOR_NAME=A U Thor",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_AUTHOR_DATE=2005-04-07T22:13:13Z",
+		"GIT_COMMITTER_NAME=C O Mitter",
+		"GIT_COMMITTER_EMAIL=committer@example.com",
+		"GIT_COMMITTER_DATE=2005-04-07T22:13:13Z",
+	)
+	return cmd
+}
+
+// Git runs git with args in dir, as Command, returning its standard
+// output.
+func Git(dir string, args ...string) (string, error) {
+	cmd := Command(dir, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", strings.Join(args, " "), bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(out), nil
+}
+
+// Names are the names of files, beyond the plain ones, in the root of
+// every commit New makes, which must be escaped in URLs, or are not
+// ASCII.
+var Names = []string{
+	"with space.txt",
+	"ünïcödé.txt",
+	"hash#.txt",
+	"question?.txt",
+	"100%.txt",
+}
+
+// file is a file New writes to the work tree of a repository.
+type file struct {
+	name    string
+	content []byte
+	mode    os.FileMode
+}
+
+// New builds a repository in a new directory within dir, storing its
+// objects as layout, and returns the path of its work tree. The
+// repository's master branch holds Commits commits, each tagged with an
+// annotated tag t<n> and a lightweight tag l<n>, of a tree holding a small
+// file changed by every commit, a large file changed slightly by every
+// commit, so packing stores it as deltas, an empty file, an executable
+// binary file with a name which is not UTF-8, a symlink, and the files
+// named by Names.
+func New(dir, layout string) (string, error) {
+	work, err := ioutil.TempDir(dir, layout)
+	if err != nil {
+		return "", err
+	}
+	run := func(args ...string) error {
+		_, err := Git(work, args...)
+		return err
+	}
+	if err := run("init", "-q"); err != nil {
+		return "", err
+	}
+	// the default branch name may be configured otherwise.
+	if err := run("symbolic-ref", "HEAD", "refs/heads/master"); err != nil {
+		return "", err
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	big := make([]byte, 200<<10)
+	for i := range big {
+		big[i] = byte('a' + rnd.Intn(26))
+	}
+	if err := os.MkdirAll(filepath.Join(work, "d", "e f"), 0755); err != nil {
+		return "", err
+	}
+	for i := 0; i < Commits; i++ {
+		big[rnd.Intn(len(big))] = 'Z'
+		files := []file{
+			{"a.txt", []byte(fmt.Sprintf("v%d\n", i)), 0644},
+			{"empty", nil, 0644},
+			{filepath.Join("d", "big.txt"), big, 0644},
+			{filepath.Join("d", "e f", "x\xff y"), []byte("bin\x00ary"), 0755},
+		}
+		for _, name := range Names {
+			files = append(files, file{name, []byte(name + "\n"), 0644})
+		}
+		for _, f := range files {
+			if err := ioutil.WriteFile(filepath.Join(work, f.name), f.content, f.mode); err != nil {
+				return "", err
+			}
+		}
+		link := filepath.Join(work, "link")
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err := os.Symlink("d/big.txt", link); err != nil {
+			return "", err
+		}
+		if err := run("add", "-A"); err != nil {
+			return "", err
+		}
+		if err := run("commit", "-q", "-m", fmt.Sprintf("commit %d\n\nbody line\n", i)); err != nil {
+			return "", err
+		}
+		if err := run("tag", "-a", "-m", fmt.Sprintf("tag %d", i), fmt.Sprintf("t%d", i)); err != nil {
+			return "", err
+		}
+		if err := run("tag", fmt.Sprintf("l%d", i)); err != nil {
+			return "", err
+		}
+	}
+
+	switch layout {
+	case Loose:
+	case Packed:
+		err = run("gc", "-q")
+	case Delta:
+		if err = run("repack", "-adf", "--depth=50", "--window=50"); err == nil {
+			err = run("pack-refs", "--all")
+		}
+	case Mixed:
+		if err = run("repack", "-adq"); err == nil {
+			if err = ioutil.WriteFile(filepath.Join(work, "a.txt"), []byte("loose\n"), 0644); err == nil {
+				err = run("commit", "-qam", "loose")
+			}
+		}
+	default:
+		err = errors.Errorf("unknown layout %q", layout)
+	}
+	if err != nil {
+		return "", err
+	}
+	return work, nil
+}
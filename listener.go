@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation, following stdin, stdout, and stderr.
+const listenFDsStart = 3
+
+// activationListener returns the listener passed to gitdav by systemd
+// socket activation, as described in sd_listen_fds(3), or false if gitdav
+// was not socket activated. Only the first socket passed is used. The
+// variables describing the sockets are unset, so they are not inherited
+// by child processes.
+func activationListener() (net.Listener, bool, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		// not set, or meant for another process.
+		return nil, false, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, false, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		log.Printf("socket activated with %d sockets, using only the first", n)
+	}
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not use socket passed by systemd")
+	}
+	return l, true, nil
+}
+
+// limitListener is a net.Listener which accepts at most cap(sem)
+// connections at once. While the limit is reached Accept waits for a
+// connection to close, so further connections queue in the listen
+// backlog rather than being refused.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// newLimitListener returns a limitListener accepting at most n
+// connections at once from l.
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		log.Printf("connection limit of %d reached, waiting for a connection to close", cap(l.sem))
+		select {
+		case l.sem <- struct{}{}:
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitConn is a connection accepted by a limitListener, which releases
+// its place when closed.
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
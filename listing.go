@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// listingPage is the HTML listing of a directory, for browsers.
+var listingPage = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+{{if ne .Path "/"}}<tr><td><a href="..">..</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td>{{if not .Dir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// listingEntry describes a file or directory in a listing.
+type listingEntry struct {
+	Name    string      `json:"name"`
+	Dir     bool        `json:"dir,omitempty"`
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mod_time"`
+	URL     string      `json:"-"`
+}
+
+// serveListing serves the entries of the directory f, requested at r,
+// as an HTML page, or if json is set, as a JSON object. Entries are
+// linked relative to the directory, so a request for a directory without
+// a trailing slash is first redirected to one with it, as browsers
+// resolve relative links against the last slash.
+func serveListing(w http.ResponseWriter, r *http.Request, name string, f webdav.File, asJSON bool) error {
+	w.Header().Set("Vary", "Accept")
+	if !asJSON && !strings.HasSuffix(r.URL.Path, "/") {
+		u := *r.URL
+		u.Path += "/"
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		return nil
+	}
+	entries, err := listingEntries(f)
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		Path    string         `json:"path"`
+		Entries []listingEntry `json:"entries"`
+	}{
+		Path:    path.Clean("/" + name),
+		Entries: entries,
+	}
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "HEAD" {
+			return nil
+		}
+		return json.NewEncoder(w).Encode(&resp)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return nil
+	}
+	return listingPage.Execute(w, &resp)
+}
+
+// listingEntries returns the entries of the directory f, linked relative
+// to the directory.
+func listingEntries(f webdav.File) ([]listingEntry, error) {
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]listingEntry, 0, len(fis))
+	for _, fi := range fis {
+		e := listingEntry{
+			Name:    fi.Name(),
+			Dir:     fi.IsDir(),
+			Mode:    fi.Mode(),
+			ModTime: fi.ModTime(),
+			URL:     (&url.URL{Path: "./" + fi.Name()}).String(),
+		}
+		if e.Dir {
+			e.URL += "/"
+		} else {
+			e.Size = fi.Size()
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
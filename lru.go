@@ -0,0 +1,59 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru is an in memory cache of at most max values, by key. Once full,
+// adding a value removes the least recently used.
+type lru struct {
+	max int
+
+	mu      sync.Mutex
+	order   *list.List               // of *lruEntry, most recently used first
+	entries map[string]*list.Element // key to element of order
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// newLRU returns an empty lru holding at most max values.
+func newLRU(max int) *lru {
+	return &lru{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the value held for key, and whether there is one.
+func (c *lru) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// add holds value for key, replacing any value held already.
+func (c *lru) add(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	for c.order.Len() > c.max {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.entries, e.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// lsTree serves the entries of the tree at the requested path in the
+// format of git ls-tree <commit>:<path>, a line "<mode> <type> <id>\t<name>"
+// for each, with names quoted as git quotes them. With recursive=true the
+// files below the tree are listed by their paths relative to it, as by
+// git ls-tree -r, and trees themselves are not listed.
+type lsTree struct {
+	served *served
+	filter *pathFilter // if not nil, paths it does not allow are omitted
+}
+
+func (l *lsTree) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive"))
+	_, tree := l.served.get()
+	dir := strings.Trim(path.Clean(strings.TrimPrefix(r.URL.Path, "/ls-tree")), "/")
+	if dir != "" {
+		e, err := tree.Lookup(dir)
+		if err == nil && !l.filter.allowed(dir, e.Mode.IsDir()) {
+			err = notExist(dir)
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		if !e.Mode.IsDir() {
+			http.Error(w, "not a tree", http.StatusBadRequest)
+			return
+		}
+		if tree, err = e.Subtree(); err != nil {
+			httpError(w, err)
+			return
+		}
+	}
+
+	var entries []string
+	list := func(p string, e *git.Entry) error {
+		if !l.filter.allowed(path.Join(dir, p), e.Mode.IsDir()) || (recursive && e.Mode.IsDir()) {
+			return nil
+		}
+		entries = append(entries, fmt.Sprintf("%06o %s %s\t%s\n", e.GitMode(), e.Kind(), e.ID(), quotePath(p)))
+		return nil
+	}
+	if recursive {
+		if err := tree.Walk(list); err != nil {
+			httpError(w, err)
+			return
+		}
+	} else {
+		for i := range tree.Entries {
+			list(tree.Entries[i].Name, &tree.Entries[i])
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, line := range entries {
+		bw.WriteString(line)
+	}
+	bw.Flush()
+}
+
+// quotePath returns p as git prints paths with core.quotePath, its
+// default: unchanged, unless it holds a control character, a double
+// quote, a backslash, or a byte which is not ASCII, in which case it is
+// double quoted with those escaped, as in C.
+func quotePath(p string) string {
+	needed := false
+	for i := 0; i < len(p); i++ {
+		if c := p[i]; c < 0x20 || c == '"' || c == '\\' || c >= 0x7f {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return p
+	}
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\v':
+			buf.WriteString(`\v`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&buf, `\%03o`, c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
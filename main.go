@@ -2,14 +2,22 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"strings"
+	"syscall"
 	"time"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/net/webdav"
 
 	"github.com/davecheney/gitdav/internal/git"
@@ -20,88 +28,553 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "index":
+			indexMain(os.Args[2:])
+			return
+		case "bundle":
+			bundleMain(os.Args[2:])
+			return
+		case "daemon":
+			daemonMain(os.Args[2:])
+			return
+		case "fsck":
+			fsckMain(os.Args[2:])
+			return
+		case "verify":
+			verifyMain(os.Args[2:])
+			return
+		case "serve":
+			// serving is the default, the subcommand name is optional.
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	httpAddr := flag.String("http", defaultAddr, "HTTP service address (e.g., '"+defaultAddr+"')")
 	c := flag.String("c", "", "commit to serve")
+	commitFile := flag.String("commit-file", "", "read the commit to serve from this file, instead of -c")
+	poll := flag.Duration("poll", 0, "with -commit-file, reread the file at this interval and serve the commit it names")
+	allRefs := flag.Bool("refs", false, "serve every branch and tag, under /heads/ and /tags/, instead of -c")
+	worktree := flag.Bool("worktree", false, "serve the files of the working tree, hiding those git ignores, instead of -c")
+	index := flag.String("index", "", "serve listings from this index, written by gitdav index, rather than reading trees")
+	preload := flag.Bool("preload", false, "read every tree of the commit at startup, and keep the trees read in memory, for consistent request latency")
+	noListing := flag.Bool("no-listing", false, "disable directory listings, files remain accessible by path")
+	browse := flag.Bool("browse", false, "list directories for browsers with breadcrumbs, modes, and links to previews of text files")
+	useH2C := flag.Bool("h2c", false, "serve HTTP/2 without TLS (h2c) as well as HTTP/1.1")
+	accessLog := flag.String("access-log", "", "write the request log to this file rather than stderr, reopened on SIGHUP")
+	accessLogSize := flag.Int64("access-log-max-size", 0, "rotate the access log when it reaches this many megabytes, 0 disables")
+	cacheDir := flag.String("cache-dir", "", "cache the contents of blobs in this directory once read")
+	cacheSize := flag.Int64("cache-max-size", 1024, "with -cache-dir, remove the least recently used blobs once the cache reaches this many megabytes, 0 disables")
+	attachmentExt := flag.String("attachment-ext", "", "comma separated file extensions, e.g. '.zip,.tar.gz', served as attachments to be downloaded")
+	allow := flag.String("allow", "", "comma separated glob patterns, e.g. 'docs/**,*.md', of the only paths to serve")
+	deny := flag.String("deny", "", "comma separated glob patterns, e.g. 'secrets', of paths to hide, taking precedence over -allow")
+	symlinkRedirect := flag.Bool("symlink-redirect", false, "redirect GET requests for symlinks to the files they refer to, rather than serving the link target as the content")
+	contentMD5 := flag.Bool("content-md5", false, "set Content-MD5 on GET requests for files, for older clients which check it")
+	rateLimit := flag.Int64("rate-limit", 0, "limit the file content sent on each connection to this many bytes a second, or 0 for no limit")
+	shaTrailer := flag.Bool("sha-trailer", false, "send GET requests for whole files chunked, with the file's git blob id in the X-Git-Sha trailer")
+	maxConns := flag.Int("max-conns", 0, "accept at most this many connections at once, further connections wait, 0 disables")
+	commitQuery := flag.Bool("commit-param", false, "with -c or -commit-file, serve requests with ?commit=<rev> from that commit, or the commit a tag refers to, rather than the one being served")
+	smart := flag.Bool("smart-http", false, "serve git's smart HTTP protocol, so git can clone and fetch the commit, or with -refs every branch and tag")
+	serveRaw := flag.Bool("raw-objects", false, "serve any object in the repository as git stores it, compressed, at /objects/raw/<id>")
+	strict := flag.Bool("strict", false, "require the path to be the root of the repository, rather than searching the directories above it")
+	sharedCache := flag.Int64("shared-cache", 0, "keep up to this many megabytes of trees, commits, and tags in memory once read, in a cache which may be shared with other repositories, 0 disables")
+	maxDeltaMemory := flag.Int64("max-delta-memory", 256, "reconstruct files stored as deltas, larger than this many megabytes, in temporary files rather than in memory, 0 disables")
+	perFileMTime := flag.Bool("per-file-mtime", false, "report the time of the last commit to change each file and directory as its modification time, found by walking the history of the commit")
+	waitCommit := flag.Bool("wait-for-commit", false, "with -c or -commit-file, if the commit cannot be found, retry until it can rather than exiting")
+	unreadyMissingTree := flag.Bool("unready-on-missing-tree", false, "with -c or -commit-file, if the commit's tree is missing from the repository, keep running but reply 503 to every request, including /ready, rather than exiting")
+	archiveCompression := flag.String("archive-compression", "default", "compression of zip and gzipped tar archives: store, fast, default, or best")
+	ninepAddr := flag.String("9p", "", "also serve the files read only over 9P2000 at this address, e.g. ':5640', for Plan 9 or Linux v9fs mounts")
+	checkOnly := flag.Bool("check", false, "check the repository and commit can be served, print what was found, and exit without serving")
+	var transforms transformFlag
+	flag.Var(&transforms, "transform", "serve GET requests for files matching a pattern through a command, e.g. '**/*.md=pandoc -t html', may be repeated")
+	showVersion := flag.Bool("version", false, "print the version of gitdav, the revision it was built from, and the Go version, then exit")
 
 	flag.Parse()
-	if len(flag.Args()) != 1 || *c == "" {
+	if *showVersion {
+		fmt.Println(version())
+		return
+	}
+	modes := 0
+	for _, set := range []bool{*c != "", *commitFile != "", *allRefs, *worktree} {
+		if set {
+			modes++
+		}
+	}
+	if len(flag.Args()) != 1 || modes != 1 {
 		flag.Usage()
 		os.Exit(2)
 	}
-	repo, err := git.Open(flag.Args()[0])
-	if err != nil {
-		log.Fatal(err)
+	for _, c := range []struct {
+		set        bool
+		flag, with string
+	}{
+		{*index != "" && *allRefs, "-index", "-refs"},
+		{*index != "" && *worktree, "-index", "-worktree"},
+		{*index != "" && *poll > 0, "-index", "-poll"},
+		{*preload && *allRefs, "-preload", "-refs"},
+		{*preload && *worktree, "-preload", "-worktree"},
+		{*waitCommit && *allRefs, "-wait-for-commit", "-refs"},
+		{*waitCommit && *worktree, "-wait-for-commit", "-worktree"},
+		{*unreadyMissingTree && *allRefs, "-unready-on-missing-tree", "-refs"},
+		{*unreadyMissingTree && *worktree, "-unready-on-missing-tree", "-worktree"},
+		{*perFileMTime && *worktree, "-per-file-mtime", "-worktree"},
+		{*perFileMTime && *index != "", "-per-file-mtime", "-index"},
+		{*browse && *noListing, "-browse", "-no-listing"},
+		{*commitQuery && *allRefs, "-commit-param", "-refs"},
+		{*commitQuery && *worktree, "-commit-param", "-worktree"},
+	} {
+		if c.set {
+			log.Fatalf("%s cannot be used with %s", c.flag, c.with)
+		}
 	}
-
-	commit, err := repo.Commit(*c)
+	filter, err := newPathFilter(*allow, *deny)
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
-
-	tree, err := commit.Tree()
+	if filter != nil && *smart {
+		// git clients fetch whole commits, so the paths hidden by the
+		// filter would be sent to them.
+		log.Fatal("-smart-http cannot be used with -allow or -deny")
+	}
+	if filter != nil && *serveRaw {
+		log.Fatal("-raw-objects cannot be used with -allow or -deny")
+	}
+	if filter != nil && *commitQuery {
+		// the filter hides paths by name, and what it hides in the
+		// commit served may be at other paths in another commit.
+		log.Fatal("-commit-param cannot be used with -allow or -deny")
+	}
+	if *allRefs && isArchive(flag.Args()[0]) {
+		// refs in an archive are resolved, but not listed.
+		log.Fatal("-refs cannot be used with an archive")
+	}
+	transforms.register()
+	archiveLevel, err := parseCompression(*archiveCompression)
 	if err != nil {
-		log.Fatalf("%+v", err)
+		log.Fatalf("-archive-compression: %v", err)
+	}
+	var objects *git.CacheGroup
+	if *sharedCache > 0 {
+		objects = git.NewCacheGroup(*sharedCache << 20)
+	}
+	openRepo := func() (*git.Repository, error) {
+		repo, err := openRepository(flag.Args()[0], *strict)
+		if err != nil {
+			return nil, err
+		}
+		repo.SetMaxDeltaMemory(*maxDeltaMemory << 20)
+		if err := repo.SetCacheGroup(objects); err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+	servedRev := func() (string, error) {
+		if *commitFile != "" {
+			return readCommitFile(*commitFile)
+		}
+		return *c, nil
 	}
 
-	dav := webdav.Handler{
-		FileSystem: &dir{root: tree},
-		LockSystem: webdav.NewMemLS(),
-		Logger: func(req *http.Request, err error) {
+	var repo *git.Repository
+	var current *served
+	if *waitCommit {
+		var commit *git.Commit
+		var tree *git.Tree
+		repo, commit, tree = waitForCommit(openRepo, servedRev)
+		current = &served{commit: commit, tree: tree}
+	} else {
+		repo, err = openRepo()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *c != "" || *commitFile != "" {
+			rev, err := servedRev()
 			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			commit, tree, err := resolve(repo, rev)
+			if err != nil && *unreadyMissingTree && git.IsMissingTree(err) {
 				log.Printf("%+v", err)
+				if err := serveUnready(*httpAddr, err); err != nil {
+					log.Fatalf("%+v", err)
+				}
 				return
 			}
-			log.Printf("%v %v %v\n", req.Method, req.URL, req.Proto)
-		},
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			current = &served{commit: commit, tree: tree}
+		}
+	}
+	var times *fileTimes
+	if *perFileMTime {
+		times = new(fileTimes)
+	}
+	if current != nil {
+		commit, tree := current.get()
+		if *preload {
+			if err := preloadTree(tree); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		}
+		// find the times now, rather than on the first request.
+		times.get(commit)
+		if *commitFile != "" && *poll > 0 {
+			go pollCommitFile(repo, *commitFile, *poll, current, *preload)
+		}
+	}
+
+	requests := log.New(os.Stderr, "", log.LstdFlags)
+	if *accessLog != "" {
+		f, err := openRotatingFile(*accessLog, *accessLogSize<<20)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		requests.SetOutput(f)
+		go func() {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			for range hup {
+				if err := f.reopen(); err != nil {
+					log.Printf("%+v", err)
+				}
+			}
+		}()
+	}
+
+	var cache *blobCache
+	if *cacheDir != "" {
+		cache, err = openBlobCache(*cacheDir, *cacheSize<<20)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// newDir returns the files of the tree s serves.
+	newDir := func(s *served) webdav.FileSystem {
+		return &dir{served: s, noListing: *noListing, cache: cache, filter: filter, times: times}
+	}
+	var fs webdav.FileSystem = &refsDir{repo: repo, noListing: *noListing, cache: cache, filter: filter, times: times}
+	switch {
+	case *worktree:
+		if repo.Bare() {
+			log.Fatalf("-worktree: %s has no working tree", repo.GitDir())
+		}
+		excludes, err := repo.Excludes()
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		sparse, err := repo.SparseCheckout()
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		fs = &worktreeDir{root: repo.Root, excludes: excludes, sparse: sparse, noListing: *noListing, filter: filter}
+	case *index != "":
+		m, err := readManifest(*index)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		commit, root := current.get()
+		d, err := newIndexDir(repo, commit, root, m)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		d.noListing, d.cache, d.filter = *noListing, cache, filter
+		fs = d
+	case current != nil:
+		fs = newDir(current)
 	}
+	if *checkOnly {
+		if err := check(os.Stdout, repo, current, *allRefs); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	ls := webdav.NewMemLS()
+	logger := func(req *http.Request, err error) {
+		if err != nil {
+			log.Printf("%+v", err)
+			return
+		}
+		requests.Printf("%v %v %v\n", req.Method, req.URL, req.Proto)
+	}
+	exts := parseExts(*attachmentExt)
+	var sums *md5Sums
+	if *contentMD5 {
+		sums = new(md5Sums)
+	}
+	sha256s := new(sha256Sums)
+
+	// files returns the handler for WebDAV requests, serving s, or if s
+	// is nil every ref or the working tree, from fs. prefix is removed
+	// from the URL path to form the name of the file in fs.
+	files := func(prefix string, s *served, fs webdav.FileSystem) http.Handler {
+		dav := webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fs,
+			LockSystem: ls,
+			Logger:     logger,
+		}
 
-	log.Println("serving requests for", repo.Root, "at commit", commit)
-	log.Fatalf("%+v", http.ListenAndServe(*httpAddr, &dav))
+		var h http.Handler = &blobs{fs: fs, Handler: &dav, md5: sums, shaTrailer: *shaTrailer, noListing: *noListing, browse: *browse, symlinkRedirect: *symlinkRedirect, Prefix: prefix, Logger: dav.Logger}
+		if s != nil {
+			h = &unavailable{served: s, prefix: prefix, Handler: h, Logger: dav.Logger}
+			h = &syncCollection{repo: repo, served: s, prefix: prefix, filter: filter, Handler: h, Logger: dav.Logger}
+		}
+		if len(exts) > 0 {
+			h = &attachments{exts: exts, Handler: h}
+		}
+		return h
+	}
+
+	// routes returns the handler for all requests, serving s, or if s is
+	// nil every ref or the working tree, with files served from fs.
+	routes := func(s *served, fs webdav.FileSystem) http.Handler {
+		mux := http.NewServeMux()
+		if s != nil && *index == "" {
+			// fs is newDir(s); a request may read several files, so
+			// each is served from the commit served when it arrived.
+			mux.Handle("/", &snapshots{served: s, handler: func(s *served) http.Handler {
+				return files("", s, newDir(s))
+			}})
+		} else {
+			mux.Handle("/", files("", s, fs))
+		}
+		var smartRefs *smartHTTP
+		if *smart {
+			smartRefs = &smartHTTP{repo: repo, refs: func() ([]advertisedRef, error) {
+				if s == nil {
+					return listRefs(repo)
+				}
+				commit, _ := s.get()
+				rev := ""
+				if s == current {
+					// not a commit named by ?commit=
+					rev = *c
+				}
+				return servedRefs(repo, rev, commit.String()), nil
+			}}
+			mux.Handle("/git-upload-pack", smartRefs)
+		}
+		mux.Handle("/info/refs", &infoRefs{repo: repo, smart: smartRefs})
+		mux.Handle("/changed", &changed{repo: repo, served: s, filter: filter})
+		meta := &metadata{repo: repo, served: s}
+		if s == current && *c != "" {
+			// not a commit named by ?commit=
+			meta.ref, _ = repo.ResolveRef(*c)
+		}
+		mux.Handle("/ready", ready{})
+		mux.Handle("/description", &description{repo: repo})
+		mux.Handle("/notes/", &notes{repo: repo})
+		mux.Handle("/metadata.json", meta)
+		if *serveRaw {
+			mux.Handle("/objects/raw/", &rawObjects{repo: repo})
+		}
+		if s != nil {
+			// these endpoints describe a single commit, so are not
+			// available when serving every branch and tag, or the
+			// working tree.
+			mux.Handle("/checksums/", &checksums{served: s, filter: filter, sha256: sha256s})
+			mux.Handle("/changes.json", &changes{served: s, filter: filter})
+			mux.Handle("/parents.json", &parents{served: s})
+			mux.Handle("/sha/", &pathSHA{served: s, filter: filter})
+			mux.Handle("/ls-tree/", &lsTree{served: s, filter: filter})
+			mux.Handle("/treehash", &treeHash{served: s, filter: filter})
+			mux.Handle("/manifest.json", &manifestHandler{served: s, filter: filter, sha256: sha256s})
+			mux.Handle("/find", &find{served: s, filter: filter})
+			arch := &archive{served: s, filter: filter, level: archiveLevel}
+			mux.Handle("/archive.tar", arch)
+			mux.Handle("/archive.tar.gz", arch)
+			mux.Handle("/archive.zip", arch)
+			mux.Handle("/at/", &at{
+				served: s,
+				files: func(prefix string, s *served) http.Handler {
+					return files(prefix, s, newDir(s))
+				},
+			})
+		}
+		return mux
+	}
+
+	var handler = routes(current, fs)
+	if *commitQuery {
+		handler = &commitParam{
+			repo:    repo,
+			Handler: handler,
+			routes: func(s *served) http.Handler {
+				return routes(s, newDir(s))
+			},
+			routesCache: newLRU(maxCommitRoutes),
+		}
+	}
+	handler = &notFound{Handler: handler}
+	if *useH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	srv := &http.Server{
+		Addr:    *httpAddr,
+		Handler: handler,
+	}
+	if *rateLimit > 0 {
+		srv.ConnContext = limitConns(*rateLimit)
+	}
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("shutting down")
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Printf("%+v", err)
+		}
+		close(done)
+	}()
+
+	log.Println(version())
+	if current != nil {
+		commit, tree := current.get()
+		if commit == nil {
+			log.Println("serving requests for", repo.Root, "at tree", tree.ID())
+		} else {
+			log.Println("serving requests for", repo.Root, "at commit", commit)
+		}
+	} else if *worktree {
+		log.Println("serving requests for the working tree of", repo.Root)
+	} else {
+		log.Println("serving requests for the branches and tags of", repo.Root)
+	}
+	// when socket activated by systemd, serve on the socket passed in
+	// rather than binding -http.
+	l, activated, err := activationListener()
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if !activated {
+		l, err = net.Listen("tcp", srv.Addr)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	if *maxConns > 0 {
+		l = newLimitListener(l, *maxConns)
+	}
+	// with -http :0 the port is chosen by the system, so log the address
+	// actually bound for callers to discover.
+	log.Printf("listening on http://%s/", l.Addr())
+	if *ninepAddr != "" {
+		nl, err := net.Listen("tcp", *ninepAddr)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		log.Printf("listening for 9P on %s", nl.Addr())
+		go func() {
+			if err := serve9P(nl, fs); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		}()
+	}
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		log.Fatalf("%+v", err)
+	}
+	<-done
+	if err := repo.Close(); err != nil {
+		log.Fatalf("%+v", err)
+	}
 }
 
 type dir struct {
-	root *git.Tree
+	served *served
+
+	// noListing hides the contents of directories.
+	noListing bool
+
+	// cache, if not nil, holds the contents of blobs once read.
+	cache *blobCache
+
+	// filter, if not nil, restricts the paths served.
+	filter *pathFilter
+
+	// times, if not nil, holds the times each path was last modified.
+	times *fileTimes
 }
 
 func (d *dir) Mkdir(path string, mode os.FileMode) error { return os.ErrInvalid }
 
 func (d *dir) OpenFile(name string, flag int, perm os.FileMode) (webdav.File, error) {
-	dir, f := path.Split(name)
-	if dir == "/" && f == "" {
+	commit, root := d.served.get()
+	return openPath(commit, root, name, d.noListing, d.cache, d.filter, d.times)
+}
+
+// openPath opens the file or directory at name within root, the tree
+// of commit, which is nil if a tree is served directly. If cache is not
+// nil, blobs are read through it. Paths filter does not allow do not
+// exist. If times is not nil, files and directories report the time they
+// were last modified, rather than the time of commit.
+func openPath(commit *git.Commit, root *git.Tree, name string, noListing bool, cache *blobCache, filter *pathFilter, times *fileTimes) (webdav.File, error) {
+	mtimes := times.get(commit)
+	p := strings.Trim(path.Clean("/"+name), "/")
+	if p == "" {
 		return &tree{
-			name: dir,
-			tree: d.root,
+			name:      "/",
+			tree:      root,
+			root:      root,
+			commit:    commitID(commit),
+			modTime:   modTime(mtimes, "", commitTime(commit)),
+			times:     mtimes,
+			noListing: noListing,
+			filter:    filter,
 		}, nil
 	}
 
-	if dir == "/" {
-		// local file
-		b, err := d.root.Blob(f)
-		if err == nil {
-			return &blob{
-				name: f,
-				Blob: b,
-			}, nil
-		}
-
-		t, err := d.root.Tree(f)
+	e, err := root.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !filter.allowed(name, e.Mode.IsDir()) {
+		return nil, notExist(name)
+	}
+	switch {
+	case e.Mode.IsDir():
+		t, err := e.Subtree()
 		if err != nil {
 			return nil, err
 		}
 		return &tree{
-			name: f,
-			tree: t,
+			name:      path.Base(name),
+			dir:       p,
+			tree:      t,
+			root:      root,
+			commit:    commitID(commit),
+			modTime:   modTime(mtimes, p, commitTime(commit)),
+			times:     mtimes,
+			noListing: noListing,
+			filter:    filter,
+		}, nil
+	case e.Mode&os.ModeIrregular != 0:
+		// submodules refer to a commit in another repository, which
+		// cannot be served from this one.
+		return nil, &os.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  os.ErrNotExist,
+		}
+	default:
+		// regular files and symlinks, whose blob holds the link target.
+		b, err := e.Blob()
+		if err != nil {
+			return nil, err
+		}
+		return &blob{
+			name:    path.Base(name),
+			commit:  commitID(commit),
+			modTime: modTime(mtimes, p, commitTime(commit)),
+			mode:    e.Mode,
+			Blob:    b,
+			cache:   cache,
 		}, nil
 	}
-
-	t, err := d.root.Tree(dir)
-	if err != nil {
-		return nil, err
-	}
-	return &tree{
-		name: f,
-		tree: t,
-	}, nil
 }
 
 func (d *dir) RemoveAll(name string) error {
@@ -113,27 +586,78 @@ func (d *dir) Rename(oldName, newName string) error {
 }
 
 func (d *dir) Stat(name string) (os.FileInfo, error) {
-	return &fileinfo{name: name, mode: os.ModeDir | 0644}, nil
+	f, err := d.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
 }
 
 type tree struct {
-	name string
-	tree *git.Tree
+	name    string
+	dir     string // slash separated path of the tree from the root
+	tree    *git.Tree
+	root    *git.Tree // the tree being served, if not nil, see DeadProps
+	commit  string    // id of the commit being served
+	modTime time.Time // time of the commit being served
+
+	// times, if not nil, holds the times the paths of the commit were
+	// last modified, reported by Readdir rather than modTime.
+	times map[string]time.Time
+
+	// noListing causes Readdir to return no entries.
+	noListing bool
+
+	// filter, if not nil, hides the entries it does not allow.
+	filter *pathFilter
+
+	off int // number of entries returned by Readdir
 }
 
 func (t *tree) Close() error             { return nil }
 func (t *tree) Read([]byte) (int, error) { return 0, os.ErrInvalid }
-func (t *tree) Readdir(int) ([]os.FileInfo, error) {
-	// TODO(dfc) respect n
-	var entries []os.FileInfo
-	for _, e := range t.tree.Entries {
-		b, err := t.tree.Blob(e.Name)
-		if err != nil {
-			entries = append(entries, &fileinfo{name: e.Name, mode: e.Mode})
-		} else {
-			entries = append(entries, &fileinfo{name: e.Name, size: b.Size, mode: e.Mode})
+
+// Readdir returns the entries of the tree following those returned by
+// previous calls, with the semantics of os.File's Readdir. If n > 0, at
+// most n entries are returned, and io.EOF once there are none left. If
+// n <= 0, all the remaining entries are returned with a nil error, even
+// if there are none.
+func (t *tree) Readdir(n int) ([]os.FileInfo, error) {
+	var rest []git.Entry
+	if !t.noListing {
+		rest = t.tree.Entries
+		if t.filter != nil {
+			rest = nil
+			for _, e := range t.tree.Entries {
+				if t.filter.allowed(path.Join(t.dir, e.Name), e.Mode.IsDir()) {
+					rest = append(rest, e)
+				}
+			}
+		}
+		rest = rest[t.off:]
+	}
+	if n > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(rest) {
+			rest = rest[:n]
 		}
 	}
+	t.off += len(rest)
+	entries := make([]os.FileInfo, 0, len(rest))
+	for i := range rest {
+		e := &rest[i]
+		fi := &fileinfo{name: e.Name, mode: e.Mode, modTime: modTime(t.times, path.Join(t.dir, e.Name), t.modTime)}
+		if !e.Mode.IsDir() {
+			// only the object header is read.
+			if size, err := e.Size(); err == nil {
+				fi.size = size
+			}
+		}
+		entries = append(entries, fi)
+	}
 	return entries, nil
 }
 
@@ -141,43 +665,121 @@ func (t *tree) Seek(offset int64, whence int) (int64, error) {
 	return 0, os.ErrInvalid
 }
 func (t *tree) Stat() (os.FileInfo, error) {
-	return &fileinfo{name: t.name, mode: os.ModeDir | 0644}, nil
+	return &fileinfo{name: t.name, mode: os.ModeDir | 0644, modTime: t.modTime}, nil
 }
 func (t *tree) Write(p []byte) (int, error) { return 0, os.ErrInvalid }
 
 type fileinfo struct {
-	name string
-	size int64
-	mode os.FileMode
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time // if zero, the current time is reported
+	etag    string    // if not "", reported by ETag
 }
 
-func (fi *fileinfo) Name() string       { return fi.name }
-func (fi *fileinfo) Size() int64        { return fi.size }
-func (fi *fileinfo) Mode() os.FileMode  { return fi.mode }
-func (fi *fileinfo) ModTime() time.Time { return time.Now() }
-func (fi *fileinfo) IsDir() bool        { return fi.Mode().IsDir() }
-func (fi *fileinfo) Sys() interface{}   { return nil }
+func (fi *fileinfo) Name() string      { return fi.name }
+func (fi *fileinfo) Size() int64       { return fi.size }
+func (fi *fileinfo) Mode() os.FileMode { return fi.mode }
+func (fi *fileinfo) ModTime() time.Time {
+	if fi.modTime.IsZero() {
+		return time.Now()
+	}
+	return fi.modTime
+}
+func (fi *fileinfo) IsDir() bool      { return fi.Mode().IsDir() }
+func (fi *fileinfo) Sys() interface{} { return nil }
+
+// ETag implements webdav.ETager, so PROPFIND reports the ETag of a blob
+// that GET sends. Otherwise webdav derives one from the size and time.
+func (fi *fileinfo) ETag(context.Context) (string, error) {
+	if fi.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.etag, nil
+}
 
 type blob struct {
-	name string
+	name    string
+	commit  string      // id of the commit being served
+	modTime time.Time   // time of the commit being served
+	mode    os.FileMode // mode of the tree entry, 0755 if executable
 	*git.Blob
+
+	// cache, if not nil, holds the contents of the blob once read.
+	// They are then read from f, the cache file, which may be seeked.
+	cache *blobCache
+	f     *os.File
+}
+
+func (b *blob) Read(p []byte) (int, error) {
+	if err := b.openCache(); err != nil {
+		return 0, err
+	}
+	if b.f != nil {
+		return b.f.Read(p)
+	}
+	return b.Blob.Read(p)
+}
+
+// ReadAt reads from the cache file if there is a cache, otherwise from the
+// blob, see git.Blob.ReadAt.
+func (b *blob) ReadAt(p []byte, off int64) (int, error) {
+	if err := b.openCache(); err != nil {
+		return 0, err
+	}
+	if b.f != nil {
+		return b.f.ReadAt(p, off)
+	}
+	return b.Blob.ReadAt(p, off)
+}
+
+// openCache switches to reading the blob from the cache, adding it to
+// the cache if necessary. It does nothing if there is no cache, or the
+// blob is already read from the cache.
+func (b *blob) openCache() error {
+	if b.cache == nil || b.f != nil {
+		return nil
+	}
+	pos, err := b.Blob.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	f, err := b.cache.open(b.Blob)
+	if err == nil {
+		_, err = f.Seek(pos, io.SeekStart)
+	}
+	if err != nil {
+		b.cache = nil
+		b.Blob.Seek(pos, io.SeekStart)
+		return err
+	}
+	b.f = f
+	return nil
+}
+
+func (b *blob) Close() error {
+	if b.f != nil {
+		b.f.Close()
+	}
+	return b.Blob.Close()
 }
 
 func (b *blob) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
 
 func (b *blob) Seek(offset int64, whence int) (int64, error) {
-	// work around the way net/http.ServeContent's seeking to the end then
-	// rewind to the start behaviour to get the size of a file ...
-	switch {
-	case offset == 0 && whence == io.SeekEnd:
-		return b.Size, nil
-	case offset == 0 && whence == io.SeekStart:
-		return 0, nil
-	default:
-		return 0, os.ErrInvalid
+	if b.f != nil {
+		return b.f.Seek(offset, whence)
 	}
+	return b.Blob.Seek(offset, whence)
 }
 func (b *blob) Stat() (os.FileInfo, error) {
-	return &fileinfo{name: b.name, size: b.Size, mode: 0644}, nil
+	etag, _ := b.ETag(context.Background())
+	return &fileinfo{name: b.name, size: b.Size, mode: b.mode, modTime: b.modTime, etag: etag}, nil
+}
+
+// ETag returns the blob's id, quoted, which is its ETag whether reported
+// by GET, PROPFIND, or a sync-collection report.
+func (b *blob) ETag(context.Context) (string, error) {
+	return `"` + b.ID() + `"`, nil
 }
 func (b *blob) Write(p []byte) (int, error) { return 0, os.ErrInvalid }
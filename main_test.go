@@ -0,0 +1,706 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/davecheney/gitdav/internal/git"
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// testRepo is the work tree of the repository built by TestMain, or ""
+// if git is not installed.
+var testRepo string
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	if !gittest.Installed() {
+		return m.Run()
+	}
+	dir, err := ioutil.TempDir("", "gitdav-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if testRepo, err = gittest.New(dir, gittest.Packed); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return m.Run()
+}
+
+// openTestRepo opens the repository built by TestMain, skipping the test
+// if git is not installed.
+func openTestRepo(t testing.TB) *git.Repository {
+	t.Helper()
+	if testRepo == "" {
+		t.Skip("git is not installed")
+	}
+	repo, err := git.Open(testRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// serveRev returns a served holding the commit rev names in repo.
+func serveRev(t testing.TB, repo *git.Repository, rev string) *served {
+	t.Helper()
+	commit, tree, err := resolve(repo, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &served{commit: commit, tree: tree}
+}
+
+// filesHandler returns the handler main uses to serve the files of the
+// commit s serves, configured by h, whose fs and Handler are set.
+func filesHandler(s *served, h blobs) http.Handler {
+	return fsHandler(&dir{served: s}, h)
+}
+
+// fsHandler returns the handler main uses to serve the files of fs,
+// configured by h, whose fs and Handler are set.
+func fsHandler(fs webdav.FileSystem, h blobs) http.Handler {
+	h.fs = fs
+	h.Handler = &webdav.Handler{
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+	}
+	return &notFound{Handler: &h}
+}
+
+// readBody reads and closes the body of resp.
+func readBody(t testing.TB, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestTreeReaddir(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	_, root := s.get()
+	all := len(root.Entries)
+
+	type call struct {
+		n    int   // the argument to Readdir
+		want int   // the number of entries returned
+		err  error // the error returned
+	}
+	tests := []struct {
+		name      string
+		noListing bool
+		calls     []call
+	}{
+		{"all", false, []call{{0, all, nil}, {0, 0, nil}, {1, 0, io.EOF}}},
+		{"all negative", false, []call{{-1, all, nil}, {-1, 0, nil}}},
+		{"pages", false, []call{{2, 2, nil}, {2, 2, nil}, {all, all - 4, nil}, {2, 0, io.EOF}, {2, 0, io.EOF}}},
+		{"more than all", false, []call{{all + 1, all, nil}, {all + 1, 0, io.EOF}}},
+		{"page then rest", false, []call{{3, 3, nil}, {0, all - 3, nil}, {0, 0, nil}}},
+		{"no listing", true, []call{{0, 0, nil}, {1, 0, io.EOF}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := (&dir{served: s, noListing: tt.noListing}).OpenFile("/", os.O_RDONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			seen := make(map[string]bool)
+			for i, c := range tt.calls {
+				fis, err := f.Readdir(c.n)
+				if len(fis) != c.want || err != c.err {
+					t.Fatalf("call %d: Readdir(%d): got %d entries, %v, want %d, %v", i, c.n, len(fis), err, c.want, c.err)
+				}
+				for _, fi := range fis {
+					if seen[fi.Name()] {
+						t.Errorf("call %d: Readdir(%d): %q returned again", i, c.n, fi.Name())
+					}
+					seen[fi.Name()] = true
+				}
+			}
+		})
+	}
+}
+
+// TestSpecialNames checks files whose names must be escaped in URLs, or
+// are not ASCII, or not UTF-8, are found by name, and by URL.
+func TestSpecialNames(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	fs := &dir{served: s}
+	h := filesHandler(s, blobs{})
+
+	files := map[string]string{"d/e f/x\xff y": "bin\x00ary"}
+	for _, name := range gittest.Names {
+		files[name] = name + "\n"
+	}
+	for name, content := range files {
+		f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+		if err != nil {
+			t.Errorf("OpenFile(%q): %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || string(got) != content {
+			t.Errorf("OpenFile(%q): read %q, %v, want %q", name, got, err, content)
+		}
+
+		u := (&url.URL{Path: "/" + name}).EscapedPath()
+		w := serve(h, "GET", u)
+		if w.Code != http.StatusOK || w.Body.String() != content {
+			t.Errorf("GET %s: got %d %q, want %q", u, w.Code, w.Body, content)
+		}
+	}
+
+	// the names listed by PROPFIND, unescaped, are the names of the files.
+	w := serve(h, "PROPFIND", "/", "Depth", "1")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND /: got %d", w.Code)
+	}
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &ms); err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[string]bool)
+	for _, r := range ms.Responses {
+		name, err := url.PathUnescape(r.Href)
+		if err != nil {
+			t.Errorf("PROPFIND /: href %q: %v", r.Href, err)
+		}
+		listed[strings.TrimPrefix(name, "/")] = true
+	}
+	for _, name := range gittest.Names {
+		if !listed[name] {
+			t.Errorf("PROPFIND /: %q not listed in %q", name, w.Body)
+		}
+	}
+}
+
+// faultStore is a git.ObjectStore which fails to read the objects in
+// fail with their error, and reads all others from the store it wraps.
+type faultStore struct {
+	git.ObjectStore
+	fail map[string]error
+}
+
+func (s *faultStore) Get(sha string) (git.Header, io.ReadCloser, error) {
+	if err, ok := s.fail[sha]; ok {
+		return git.Header{}, nil, err
+	}
+	return s.ObjectStore.Get(sha)
+}
+
+// TestTransientErrors checks files that cannot be read because of an I/O
+// error, as on a network file system, are replied to with 503 Service
+// Unavailable and Retry-After, not 404 Not Found.
+func TestTransientErrors(t *testing.T) {
+	store := openTestRepo(t)
+	defer store.Close()
+	repo := openTestRepo(t)
+	defer repo.Close()
+	fail := make(map[string]error)
+	for _, p := range []string{"a.txt", "d"} {
+		sha, err := gittest.Git(testRepo, "rev-parse", "master:"+p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sha = strings.TrimSpace(sha)
+		fail[sha] = &os.PathError{Op: "read", Path: sha, Err: syscall.EIO}
+	}
+	repo.SetObjectStore(&faultStore{ObjectStore: store, fail: fail})
+	s := serveRev(t, repo, "master")
+	h := &unavailable{served: s, Handler: filesHandler(s, blobs{})}
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/a.txt", http.StatusServiceUnavailable},
+		{"HEAD", "/a.txt", http.StatusServiceUnavailable},
+		{"GET", "/d/big.txt", http.StatusServiceUnavailable},
+		{"GET", "/empty", http.StatusOK},
+		{"GET", "/nope", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.method, tt.path)
+		if w.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+		retry := w.Header().Get("Retry-After")
+		if want := tt.want == http.StatusServiceUnavailable; (retry != "") != want {
+			t.Errorf("%s %s: Retry-After %q", tt.method, tt.path, retry)
+		}
+	}
+}
+
+// TestNotFoundMessage checks the 404 Not Found page and object keep the
+// reason a request was not found, other than that the file does not
+// exist.
+func TestNotFoundMessage(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	files := filesHandler(s, blobs{}).(*notFound).Handler
+	h := &notFound{Handler: &commitParam{
+		repo:        repo,
+		Handler:     files,
+		routes:      func(*served) http.Handler { return files },
+		routesCache: newLRU(1),
+	}}
+
+	blob, err := gittest.Git(testRepo, "rev-parse", "master:a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob = strings.TrimSpace(blob)
+
+	tests := []struct {
+		path, accept, want string
+	}{
+		{"/nope", "text/html", "/nope does not exist."},
+		{"/nope", "application/json", `{"error":"not found","path":"/nope"}`},
+		{"/nope?commit=t1", "application/json", `{"error":"not found","path":"/nope"}`},
+		{"/a.txt?commit=" + blob, "text/html", "<p>unknown commit."},
+		{"/a.txt?commit=" + blob, "application/json", `{"error":"unknown commit","path":"/a.txt"}`},
+	}
+	for _, tt := range tests {
+		w := serve(h, "GET", tt.path, "Accept", tt.accept)
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("GET %s, Accept %s: got %d %q, want 404 containing %q", tt.path, tt.accept, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+// TestCommitParam checks ?commit= accepts abbreviated object ids, and
+// refuses those which are the start of the ids of more than one object,
+// and revs which cannot be resolved, as bad requests.
+func TestCommitParam(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir, err := gittest.New(t.TempDir(), gittest.Loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two blobs whose ids share their first four hex digits.
+	seen := make(map[string]bool)
+	var ambiguous string
+	for i := 0; ambiguous == ""; i++ {
+		content := fmt.Sprintln(i)
+		sum := sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(content), content)))
+		prefix := hex.EncodeToString(sum[:2])
+		if seen[prefix] {
+			ambiguous = prefix
+		}
+		seen[prefix] = true
+		cmd := gittest.Command(dir, "hash-object", "-w", "--stdin")
+		cmd.Stdin = strings.NewReader(content)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+	}
+	commit, err := gittest.Git(dir, "rev-parse", "t1^{commit}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := git.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	h := &commitParam{
+		repo:        repo,
+		Handler:     filesHandler(s, blobs{}),
+		routes:      func(s *served) http.Handler { return filesHandler(s, blobs{}) },
+		routesCache: newLRU(maxCommitRoutes),
+	}
+
+	tests := []struct {
+		rev  string
+		code int
+		want string
+	}{
+		{"t1", http.StatusOK, "v1\n"},
+		{commit[:7], http.StatusOK, "v1\n"},
+		{strings.ToUpper(commit[:12]), http.StatusOK, "v1\n"},
+		{ambiguous, http.StatusBadRequest, "ambiguous revision"},
+		{"nope", http.StatusBadRequest, "unknown revision"},
+		{"abc", http.StatusBadRequest, "unknown revision"},
+		{strings.Repeat("0", 40), http.StatusNotFound, "unknown commit"},
+	}
+	for _, tt := range tests {
+		w := serve(h, "GET", "/a.txt?commit="+tt.rev)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("GET /a.txt?commit=%s: got %d %q, want %d containing %q", tt.rev, w.Code, w.Body, tt.code, tt.want)
+		}
+	}
+}
+
+// TestManifestModes checks the manifest lists modes as git does, and an
+// index read from it serves each path with the mode the tree gives it.
+func TestManifestModes(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	commit, root := s.get()
+	m, err := buildManifest(commit, root, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "index.json")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewEncoder(f).Encode(m)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		Entries []struct {
+			Path string
+			Mode json.RawMessage
+		}
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		t.Fatal(err)
+	}
+	modes := make(map[string]string)
+	for _, e := range raw.Entries {
+		modes[e.Path] = string(e.Mode)
+	}
+	for p, want := range map[string]string{
+		"a.txt": `"100644"`,
+		"d":     `"040000"`,
+		"link":  `"120000"`,
+	} {
+		if modes[p] != want {
+			t.Errorf("%q: got mode %s, want %s", p, modes[p], want)
+		}
+	}
+
+	// JSON cannot hold names which are not UTF-8, so the executable
+	// file is checked before encoding.
+	for _, e := range m.Entries {
+		if e.Path == "d/e f/x\xff y" && e.Mode != "100755" {
+			t.Errorf("%q: got mode %s, want 100755", e.Path, e.Mode)
+		}
+	}
+
+	m, err = readManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := newIndexDir(repo, commit, root, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range m.Entries {
+		want, err := root.Lookup(e.Path)
+		if err != nil {
+			// the name is not UTF-8, so was changed by encoding.
+			continue
+		}
+		got, err := index.Stat(e.Path)
+		if err != nil {
+			t.Errorf("%q: %v", e.Path, err)
+			continue
+		}
+		if got.Mode() != want.Mode {
+			t.Errorf("%q: got mode %v, want %v", e.Path, got.Mode(), want.Mode)
+		}
+	}
+}
+
+// countingStore is a git.ObjectStore counting the objects read from the
+// store it wraps.
+type countingStore struct {
+	git.ObjectStore
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (s *countingStore) Get(sha string) (git.Header, io.ReadCloser, error) {
+	s.mu.Lock()
+	s.reads[sha]++
+	s.mu.Unlock()
+	return s.ObjectStore.Get(sha)
+}
+
+// TestOpenPathReads checks opening a file or directory reads each tree on
+// the path to it once, and then the file or directory itself once.
+func TestOpenPathReads(t *testing.T) {
+	store := openTestRepo(t)
+	defer store.Close()
+	repo := openTestRepo(t)
+	defer repo.Close()
+	cs := &countingStore{ObjectStore: store, reads: make(map[string]int)}
+	repo.SetObjectStore(cs)
+	s := serveRev(t, repo, "master")
+	commit, root := s.get()
+
+	for _, name := range []string{"a.txt", "d", "d/big.txt", "d/e f", "d/e f/x\xff y"} {
+		cs.reads = make(map[string]int)
+		f, err := openPath(commit, root, name, false, nil, nil, nil)
+		if err != nil {
+			t.Errorf("%q: %v", name, err)
+			continue
+		}
+		f.Close()
+		// the trees below the root on the path, and the object itself.
+		want := strings.Count(name, "/") + 1
+		total := 0
+		for sha, n := range cs.reads {
+			if n != 1 {
+				t.Errorf("%q: read %s %d times, want once", name, sha, n)
+			}
+			total += n
+		}
+		if total != want {
+			t.Errorf("%q: read %d objects, want %d", name, total, want)
+		}
+	}
+}
+
+// TestResolveTags checks a tag of a tree serves the tree, and a tag of a
+// blob a tree holding only the blob, named after the tag, with no commit.
+func TestResolveTags(t *testing.T) {
+	if testRepo == "" {
+		t.Skip("git is not installed")
+	}
+	dir, err := gittest.New(t.TempDir(), gittest.Loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := func(name string) string {
+		t.Helper()
+		out, err := gittest.Git(dir, "rev-parse", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(out)
+	}
+	tree, blob := rev("master^{tree}"), rev("master:a.txt")
+	for _, args := range [][]string{
+		{"tag", "-a", "-m", "a tree", "treetag", tree},
+		{"tag", "-a", "-m", "a blob", "blobtag", blob},
+		{"tag", "lightblob", blob},
+	} {
+		if _, err := gittest.Git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := git.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	commit, root, err := resolve(repo, "treetag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != nil || root.ID() != tree {
+		t.Errorf("resolve(treetag): got commit %v, tree %s, want no commit, tree %s", commit, root.ID(), tree)
+	}
+	for _, name := range []string{"blobtag", "lightblob"} {
+		commit, root, err := resolve(repo, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit != nil || len(root.Entries) != 1 || root.Entries[0].Name != name || root.Entries[0].ID() != blob {
+			t.Errorf("resolve(%s): got commit %v, %d entries, want no commit, only %s", name, commit, len(root.Entries), name)
+			continue
+		}
+		b, err := root.Blob(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(b)
+		b.Close()
+		if err != nil || string(content) != fmt.Sprintf("v%d\n", gittest.Commits-1) {
+			t.Errorf("resolve(%s): read %q, %v", name, content, err)
+		}
+	}
+}
+
+// swapStore is a git.ObjectStore which calls swap before the first object
+// is read from the store it wraps.
+type swapStore struct {
+	git.ObjectStore
+	once sync.Once
+	swap func()
+}
+
+func (s *swapStore) Get(sha string) (git.Header, io.ReadCloser, error) {
+	s.once.Do(s.swap)
+	return s.ObjectStore.Get(sha)
+}
+
+// TestSnapshotSwap checks a PROPFIND of a directory, which reads each of
+// its files, reports the commit served when it arrived for all of them,
+// although polling replaces the commit part way through, and that the
+// next request sees the new commit.
+func TestSnapshotSwap(t *testing.T) {
+	store := openTestRepo(t)
+	defer store.Close()
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	next := serveRev(t, repo, "t0")
+	before, _ := s.get()
+	after, _ := next.get()
+	ss := &swapStore{ObjectStore: store, swap: func() {
+		// as pollCommitFile does, finding the commit file changed.
+		s.set(next.get())
+	}}
+	repo.SetObjectStore(ss)
+	h := &snapshots{served: s, handler: func(s *served) http.Handler {
+		return filesHandler(s, blobs{})
+	}}
+
+	w := serve(h, "PROPFIND", "/", "Depth", "1")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND /: got %d", w.Code)
+	}
+	if commit, _ := s.get(); commit != after {
+		t.Fatalf("the commit was not swapped during the request")
+	}
+	body := w.Body.String()
+	// the root, and each file and directory in it, report the commit.
+	if n := strings.Count(body, before.String()); n < 2 || strings.Contains(body, after.String()) {
+		t.Errorf("PROPFIND /: reported %s %d times, and %s %d times, want only %s", before, n, after, strings.Count(body, after.String()), before)
+	}
+
+	w = serve(h, "PROPFIND", "/", "Depth", "1")
+	if body := w.Body.String(); !strings.Contains(body, after.String()) || strings.Contains(body, before.String()) {
+		t.Errorf("PROPFIND / after the swap: want only %s in %q", after, body)
+	}
+}
+
+// TestMissingTree checks a commit whose tree was removed from the
+// repository, as by a failed fetch or a corrupt disk, is reported as
+// such, and requests for it are answered with a clean error, rather than
+// a panic, or 500 Internal Server Error with a stack trace.
+func TestMissingTree(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir, err := gittest.New(t.TempDir(), gittest.Loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(stdin string, args ...string) string {
+		t.Helper()
+		cmd := gittest.Command(dir, args...)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	// a commit of a tree of its own, which is then removed.
+	blob := run("broken\n", "hash-object", "-w", "--stdin")
+	tree := run("100644 blob "+blob+"\tbroken.txt\n", "mktree")
+	commit := run("", "commit-tree", "-m", "broken", tree)
+	run("", "branch", "broken", commit)
+	if err := os.Remove(filepath.Join(dir, ".git", "objects", tree[:2], tree[2:])); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	_, _, err = resolve(repo, "broken")
+	if !git.IsMissingTree(err) || !git.IsNotExist(err) {
+		t.Fatalf("resolve: got %v, want a missing tree", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, commit) || !strings.Contains(msg, tree) {
+		t.Errorf("resolve: error %q does not name the commit and tree", msg)
+	}
+
+	s := serveRev(t, repo, "master")
+	handlers := []struct {
+		name string
+		h    http.Handler
+	}{
+		{"commit-param", &commitParam{
+			repo:        repo,
+			Handler:     filesHandler(s, blobs{}),
+			routes:      func(s *served) http.Handler { return filesHandler(s, blobs{}) },
+			routesCache: newLRU(maxCommitRoutes),
+		}},
+		{"refs", fsHandler(&refsDir{repo: repo}, blobs{})},
+		{"unready", &unready{reason: err}},
+	}
+	tests := []struct {
+		handler, method, path string
+		want                  int
+	}{
+		{"commit-param", "GET", "/broken.txt?commit=broken", http.StatusNotFound},
+		{"commit-param", "GET", "/a.txt", http.StatusOK},
+		{"refs", "GET", "/heads/broken/broken.txt", http.StatusNotFound},
+		{"refs", "PROPFIND", "/heads/broken/", http.StatusNotFound},
+		{"refs", "GET", "/heads/master/a.txt", http.StatusOK},
+		{"unready", "GET", "/ready", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		for _, h := range handlers {
+			if h.name != tt.handler {
+				continue
+			}
+			w := serve(h.h, tt.method, tt.path, "Depth", "1")
+			if w.Code != tt.want {
+				t.Errorf("%s: %s %s: got %d %q, want %d", tt.handler, tt.method, tt.path, w.Code, w.Body, tt.want)
+			}
+			if body := w.Body.String(); strings.Contains(body, ".go:") || strings.Contains(body, "goroutine") {
+				t.Errorf("%s: %s %s: replied with a stack trace: %q", tt.handler, tt.method, tt.path, body)
+			}
+		}
+	}
+}
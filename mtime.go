@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// maxFileTimes is the most commits whose file times fileTimes holds.
+const maxFileTimes = 16
+
+// fileTimes holds the time each path in the tree of a commit was last
+// modified, as found by git.Commit.LastModified, so files and directories
+// may report those as their modification times rather than the time of
+// the commit served. The times of a commit are found on first use, then
+// cached by commit id. Once more than maxFileTimes commits are held, as
+// when polling a commit file, the cache is emptied.
+type fileTimes struct {
+	mu      sync.Mutex
+	commits map[string]map[string]time.Time
+}
+
+// get returns the time each path in the tree of commit was last modified,
+// or nil if ft or commit is nil, or the times cannot be found, in which
+// case the error is logged.
+func (ft *fileTimes) get(commit *git.Commit) map[string]time.Time {
+	if ft == nil || commit == nil {
+		return nil
+	}
+	id := commit.String()
+	ft.mu.Lock()
+	times, ok := ft.commits[id]
+	ft.mu.Unlock()
+	if ok {
+		return times
+	}
+
+	start := time.Now()
+	times, err := commit.LastModified()
+	if err != nil {
+		log.Printf("%+v", err)
+		return nil
+	}
+	log.Printf("found the modification times of %d paths in commit %s in %v", len(times), id, time.Since(start).Round(time.Millisecond))
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.commits == nil || len(ft.commits) >= maxFileTimes {
+		ft.commits = make(map[string]map[string]time.Time)
+	}
+	ft.commits[id] = times
+	return times
+}
+
+// modTime returns the time p was last modified according to times, or
+// if it is not known, def.
+func modTime(times map[string]time.Time, p string, def time.Time) time.Time {
+	if t, ok := times[p]; ok {
+		return t
+	}
+	return def
+}
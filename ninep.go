@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+// 9P2000 message types. T messages are requests, R messages the replies.
+const (
+	tversion = 100
+	rversion = 101
+	tauth    = 102
+	tattach  = 104
+	rattach  = 105
+	rerror   = 107
+	tflush   = 108
+	rflush   = 109
+	twalk    = 110
+	rwalk    = 111
+	topen    = 112
+	ropen    = 113
+	tcreate  = 114
+	tread    = 116
+	rread    = 117
+	twrite   = 118
+	tclunk   = 120
+	rclunk   = 121
+	tremove  = 122
+	tstat    = 124
+	rstat    = 125
+	twstat   = 126
+)
+
+const (
+	// ninepHeader is the length of the size, type and tag of a message.
+	ninepHeader = 4 + 1 + 2
+
+	// ninepMaxMsize is the largest message gitdav will send or receive,
+	// clients asking for larger are offered this.
+	ninepMaxMsize = 64 << 10
+
+	// ninepMinMsize is the smallest message size a client may ask for,
+	// large enough for a stat.
+	ninepMinMsize = 256
+
+	// ninepMaxWalk is the most path elements a Twalk may name.
+	ninepMaxWalk = 16
+
+	qtdir  = 0x80       // qid type of a directory
+	dmdir  = 0x80000000 // mode bit of a directory
+	oexec  = 3          // open mode equivalent to reading
+	otrunc = 0x10       // open mode bit truncating the file
+)
+
+// errReadOnly is the reply to any request which would change the files
+// served.
+var errReadOnly = errors.New("read-only file system")
+
+// serve9P serves the files of fs, read only, over the 9P2000 protocol, to
+// each connection accepted from l, until l is closed. Files are the same
+// as those served over WebDAV, so Plan 9, or Linux with v9fs, may mount
+// the commit served without FUSE:
+//
+//	mount -t 9p -o trans=tcp,port=5640,version=9p2000 127.0.0.1 /mnt
+//
+// Authentication is not supported, any user name is accepted. Requests
+// on a connection are answered in the order received, so Tflush has
+// nothing to cancel.
+func serve9P(l net.Listener, fs webdav.FileSystem) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		go func() {
+			defer conn.Close()
+			c := &ninepConn{fs: fs, msize: ninepMaxMsize, fids: make(map[uint32]*ninepFid)}
+			if err := c.serve(conn); err != nil {
+				log.Printf("9p %v: %+v", conn.RemoteAddr(), err)
+			}
+			c.clunkAll()
+		}()
+	}
+}
+
+// ninepConn is the state of a 9P connection.
+type ninepConn struct {
+	fs    webdav.FileSystem
+	msize uint32
+	fids  map[uint32]*ninepFid
+}
+
+// ninepFid is a file, or directory, a client has walked to, and once
+// opened, the file read from.
+type ninepFid struct {
+	name string
+	qid  ninepQid
+	file webdav.File
+
+	// dir holds the directory entries, encoded as stats, read from an
+	// open directory, and off the offset of the next entry to be read.
+	dir []byte
+	off uint64
+}
+
+// ninepQid is the server's identity for a file.
+type ninepQid struct {
+	typ  uint8
+	path uint64
+}
+
+// serve reads requests from rw, and writes the replies, until the client
+// disconnects.
+func (c *ninepConn) serve(rw io.ReadWriter) error {
+	r := bufio.NewReader(rw)
+	w := bufio.NewWriter(rw)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n < ninepHeader || n > c.msize {
+			return errors.Errorf("invalid message size %d", n)
+		}
+		msg := make([]byte, n-4)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return errors.WithStack(err)
+		}
+		typ, tag := msg[0], binary.LittleEndian.Uint16(msg[1:3])
+		rtyp, reply, err := c.handle(typ, &ninepReader{b: msg[3:]})
+		if err != nil {
+			rtyp, reply = rerror, ninepString(nil, err.Error())
+		}
+		out := make([]byte, ninepHeader, ninepHeader+len(reply))
+		binary.LittleEndian.PutUint32(out, uint32(ninepHeader+len(reply)))
+		out[4] = rtyp
+		binary.LittleEndian.PutUint16(out[5:], tag)
+		w.Write(append(out, reply...))
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+}
+
+// handle answers the request of type typ, whose body is read from m,
+// returning the type and body of the reply. Errors are replied with
+// Rerror.
+func (c *ninepConn) handle(typ uint8, m *ninepReader) (uint8, []byte, error) {
+	switch typ {
+	case tversion:
+		msize, version := m.uint32(), m.string()
+		if m.err != nil {
+			return 0, nil, m.err
+		}
+		if msize < ninepMinMsize {
+			return 0, nil, errors.Errorf("msize %d too small", msize)
+		}
+		c.clunkAll()
+		c.msize = ninepMaxMsize
+		if msize < c.msize {
+			c.msize = msize
+		}
+		if version != "9P2000" && (len(version) < 7 || version[:7] != "9P2000.") {
+			version = "unknown"
+		} else {
+			// extensions, as 9P2000.u, are not supported.
+			version = "9P2000"
+		}
+		b := ninepUint32(nil, c.msize)
+		return rversion, ninepString(b, version), nil
+	case tauth:
+		return 0, nil, errors.New("authentication not required")
+	case tattach:
+		fid := m.uint32()
+		m.uint32() // afid
+		m.string() // uname
+		m.string() // aname
+		if m.err != nil {
+			return 0, nil, m.err
+		}
+		if _, ok := c.fids[fid]; ok {
+			return 0, nil, errors.New("fid in use")
+		}
+		fi, err := c.fs.Stat("/")
+		if err != nil {
+			return 0, nil, err
+		}
+		f := &ninepFid{name: "/", qid: ninepQidOf("/", fi)}
+		c.fids[fid] = f
+		return rattach, f.qid.append(nil), nil
+	case tflush:
+		return rflush, nil, nil
+	case twalk:
+		return c.walk(m)
+	case topen:
+		fid, mode := m.uint32(), m.uint8()
+		if m.err != nil {
+			return 0, nil, m.err
+		}
+		f, err := c.fid(fid)
+		if err != nil {
+			return 0, nil, err
+		}
+		if f.file != nil {
+			return 0, nil, errors.New("fid already open")
+		}
+		if mode&3 != 0 && mode&3 != oexec || mode&otrunc != 0 {
+			return 0, nil, errReadOnly
+		}
+		file, err := c.fs.OpenFile(f.name, os.O_RDONLY, 0)
+		if err != nil {
+			return 0, nil, err
+		}
+		f.file = file
+		b := f.qid.append(nil)
+		return ropen, ninepUint32(b, c.msize-ninepHeader-4-4), nil
+	case tread:
+		fid, off, count := m.uint32(), m.uint64(), m.uint32()
+		if m.err != nil {
+			return 0, nil, m.err
+		}
+		f, err := c.fid(fid)
+		if err != nil {
+			return 0, nil, err
+		}
+		if f.file == nil {
+			return 0, nil, errors.New("fid not open")
+		}
+		if max := c.msize - ninepHeader - 4; count > max {
+			count = max
+		}
+		var data []byte
+		if f.qid.typ&qtdir != 0 {
+			data, err = f.readDir(off, count)
+		} else {
+			data, err = f.read(off, count)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		b := ninepUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+		return rread, append(b, data...), nil
+	case tclunk:
+		fid := m.uint32()
+		if m.err != nil {
+			return 0, nil, m.err
+		}
+		if _, err := c.fid(fid); err != nil {
+			return 0, nil, err
+		}
+		c.clunk(fid)
+		return rclunk, nil, nil
+	case tremove:
+		// remove clunks the fid, even if the file is not removed.
+		fid := m.uint32()
+		if m.err != nil {
+			return 0, nil, m.err
+		}
+		c.clunk(fid)
+		return 0, nil, errReadOnly
+	case tstat:
+		fid := m.uint32()
+		if m.err != nil {
+			return 0, nil, m.err
+		}
+		f, err := c.fid(fid)
+		if err != nil {
+			return 0, nil, err
+		}
+		fi, err := c.fs.Stat(f.name)
+		if err != nil {
+			return 0, nil, err
+		}
+		st := ninepStat(nil, f.name, fi)
+		return rstat, append(ninepUint16(nil, uint16(len(st))), st...), nil
+	case tcreate, twrite, twstat:
+		return 0, nil, errReadOnly
+	default:
+		return 0, nil, errors.Errorf("unsupported message type %d", typ)
+	}
+}
+
+// walk answers a Twalk, walking from a fid through each path element
+// named to a new fid. If the first element cannot be walked, an error is
+// replied. If a later element cannot be, the qids of those walked are
+// replied, and the new fid is not created.
+func (c *ninepConn) walk(m *ninepReader) (uint8, []byte, error) {
+	fid, newfid, n := m.uint32(), m.uint32(), m.uint16()
+	if n > ninepMaxWalk {
+		return 0, nil, errors.Errorf("walk of %d elements, at most %d are allowed", n, ninepMaxWalk)
+	}
+	names := make([]string, n)
+	for i := range names {
+		names[i] = m.string()
+	}
+	if m.err != nil {
+		return 0, nil, m.err
+	}
+	f, err := c.fid(fid)
+	if err != nil {
+		return 0, nil, err
+	}
+	if f.file != nil {
+		return 0, nil, errors.New("fid open")
+	}
+	if _, ok := c.fids[newfid]; ok && newfid != fid {
+		return 0, nil, errors.New("fid in use")
+	}
+
+	name, qid := f.name, f.qid
+	b := ninepUint16(nil, 0)
+	var walked uint16
+	for _, elem := range names {
+		if qid.typ&qtdir == 0 {
+			err = errors.Errorf("%s: not a directory", name)
+			break
+		}
+		if elem == "" || elem == "." || path.Base(elem) != elem {
+			err = errors.Errorf("invalid path element %q", elem)
+			break
+		}
+		next := path.Join(name, elem)
+		var fi os.FileInfo
+		fi, err = c.fs.Stat(next)
+		if err != nil {
+			break
+		}
+		name, qid = next, ninepQidOf(next, fi)
+		b = qid.append(b)
+		walked++
+	}
+	if walked < n {
+		if walked == 0 {
+			return 0, nil, err
+		}
+	} else {
+		c.clunk(newfid)
+		c.fids[newfid] = &ninepFid{name: name, qid: qid}
+	}
+	binary.LittleEndian.PutUint16(b, walked)
+	return rwalk, b, nil
+}
+
+// fid returns the file the client knows as fid.
+func (c *ninepConn) fid(fid uint32) (*ninepFid, error) {
+	f, ok := c.fids[fid]
+	if !ok {
+		return nil, errors.New("unknown fid")
+	}
+	return f, nil
+}
+
+// clunk forgets fid, closing its file if open.
+func (c *ninepConn) clunk(fid uint32) {
+	if f, ok := c.fids[fid]; ok && f.file != nil {
+		f.file.Close()
+	}
+	delete(c.fids, fid)
+}
+
+// clunkAll forgets every fid, as when the connection is closed or a new
+// session begins with Tversion.
+func (c *ninepConn) clunkAll() {
+	for fid := range c.fids {
+		c.clunk(fid)
+	}
+}
+
+// read reads at most count bytes of the file at off.
+func (f *ninepFid) read(off uint64, count uint32) ([]byte, error) {
+	buf := make([]byte, count)
+	if ra, ok := f.file.(io.ReaderAt); ok {
+		n, err := ra.ReadAt(buf, int64(off))
+		if err == io.EOF {
+			err = nil
+		}
+		return buf[:n], err
+	}
+	if _, err := f.file.Seek(int64(off), io.SeekStart); err != nil {
+		return nil, err
+	}
+	n, err := io.ReadFull(f.file, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+// readDir reads at most count bytes of the entries of the directory at
+// off, which must be 0, to read from the first entry, or the offset
+// following the previous read. Only whole entries are returned.
+func (f *ninepFid) readDir(off uint64, count uint32) ([]byte, error) {
+	if off == 0 {
+		fis, err := f.file.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		f.dir = f.dir[:0]
+		for _, fi := range fis {
+			f.dir = ninepStat(f.dir, path.Join(f.name, fi.Name()), fi)
+		}
+		f.off = 0
+	}
+	if off != f.off {
+		return nil, errors.Errorf("invalid directory read offset %d", off)
+	}
+	start := int(off)
+	end := start
+	for end+2 <= len(f.dir) {
+		n := 2 + int(binary.LittleEndian.Uint16(f.dir[end:]))
+		if end+n-start > int(count) {
+			break
+		}
+		end += n
+	}
+	f.off = uint64(end)
+	return f.dir[start:end], nil
+}
+
+// ninepQidOf returns the qid of the file name. The path of the qid is a
+// hash of the name, so each path served has a constant qid.
+func ninepQidOf(name string, fi os.FileInfo) ninepQid {
+	h := fnv.New64a()
+	io.WriteString(h, name)
+	q := ninepQid{path: h.Sum64()}
+	if fi.IsDir() {
+		q.typ = qtdir
+	}
+	return q
+}
+
+func (q ninepQid) append(b []byte) []byte {
+	b = append(b, q.typ)
+	b = ninepUint32(b, 0) // version, files never change
+	return ninepUint64(b, q.path)
+}
+
+// ninepStat appends the 9P stat of fi, the file name, to b. Files may be
+// read by anyone, and directories searched, but nothing may be written.
+func ninepStat(b []byte, name string, fi os.FileInfo) []byte {
+	start := len(b)
+	b = ninepUint16(b, 0) // size, filled in below
+	b = ninepUint16(b, 0) // type
+	b = ninepUint32(b, 0) // dev
+	b = ninepQidOf(name, fi).append(b)
+	mode := uint32(0444)
+	if fi.IsDir() {
+		mode = dmdir | 0555
+	} else if fi.Mode()&0111 != 0 {
+		mode = 0555
+	}
+	b = ninepUint32(b, mode)
+	mtime := uint32(fi.ModTime().Unix())
+	b = ninepUint32(b, mtime) // atime
+	b = ninepUint32(b, mtime)
+	var length uint64
+	if !fi.IsDir() {
+		length = uint64(fi.Size())
+	}
+	b = ninepUint64(b, length)
+	b = ninepString(b, fi.Name())
+	b = ninepString(b, "gitdav") // uid
+	b = ninepString(b, "gitdav") // gid
+	b = ninepString(b, "")       // muid
+	binary.LittleEndian.PutUint16(b[start:], uint16(len(b)-start-2))
+	return b
+}
+
+func ninepUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func ninepUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func ninepUint64(b []byte, v uint64) []byte {
+	return ninepUint32(ninepUint32(b, uint32(v)), uint32(v>>32))
+}
+
+func ninepString(b []byte, s string) []byte {
+	if len(s) > 0xffff {
+		s = s[:0xffff]
+	}
+	return append(ninepUint16(b, uint16(len(s))), s...)
+}
+
+// ninepReader decodes the fields of a message. Once a field cannot be
+// read, err is set, and later fields read as zero.
+type ninepReader struct {
+	b   []byte
+	err error
+}
+
+func (r *ninepReader) next(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		if r.err == nil {
+			r.err = errors.New("short message")
+		}
+		return make([]byte, n)
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *ninepReader) uint8() uint8   { return r.next(1)[0] }
+func (r *ninepReader) uint16() uint16 { return binary.LittleEndian.Uint16(r.next(2)) }
+func (r *ninepReader) uint32() uint32 { return binary.LittleEndian.Uint32(r.next(4)) }
+func (r *ninepReader) uint64() uint64 { return binary.LittleEndian.Uint64(r.next(8)) }
+
+func (r *ninepReader) string() string {
+	return string(r.next(int(r.uint16())))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// notes serves the note attached to the object named at /notes/<rev>, as
+// text. rev may be an object id or the name of a ref; tags are not
+// peeled, as git notes show does not. Objects without a note have an
+// empty one.
+type notes struct {
+	repo *git.Repository
+}
+
+func (n *notes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rev := strings.TrimPrefix(r.URL.Path, "/notes/")
+	sha, err := n.repo.ResolveRev(rev)
+	if err != nil || !n.repo.Exists(sha) {
+		http.Error(w, "unknown object", http.StatusNotFound)
+		return
+	}
+	note, err := n.repo.Notes(sha)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(note))
+}
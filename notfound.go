@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// notFoundPage is the body of 404 Not Found responses for browsers.
+var notFoundPage = template.Must(template.New("404").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Not Found</title></head>
+<body>
+<h1>Not Found</h1>
+<p>{{if .Message}}{{.Message}}{{else}}{{.Path}} does not exist{{end}}. Go to the <a href="/">root</a>.</p>
+</body>
+</html>
+`))
+
+// notFound wraps a handler, replacing the body of 404 Not Found replies
+// to GET and HEAD requests with one suited to the client, according to
+// its Accept header, see preferred: an HTML page for browsers, or a JSON
+// object for API clients. Other requests, including those of WebDAV
+// clients, which do not ask for either, receive the reply of the wrapped
+// handler. The page and the object keep the wrapped handler's message,
+// as "unknown commit", unless it only says the path was not found.
+type notFound struct {
+	http.Handler
+}
+
+func (n *notFound) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		n.Handler.ServeHTTP(w, r)
+		return
+	}
+	var kind string
+	switch preferred(r.Header.Get("Accept"), "text/html", "application/json") {
+	case "text/html":
+		kind = "text/html; charset=utf-8"
+	case "application/json":
+		kind = "application/json"
+	default:
+		n.Handler.ServeHTTP(w, r)
+		return
+	}
+	nw := &notFoundWriter{ResponseWriter: w, r: r, kind: kind}
+	n.Handler.ServeHTTP(nw, r)
+	nw.finish()
+}
+
+// notFoundWriter is an http.ResponseWriter which replaces the body of a
+// 404 Not Found reply with one of the content type kind, written by
+// finish.
+type notFoundWriter struct {
+	http.ResponseWriter
+	r    *http.Request
+	kind string
+
+	replaced bool         // the reply is a 404, its body to be replaced
+	body     bytes.Buffer // the start of the wrapped handler's body
+}
+
+// maxNotFoundMessage is the length of the wrapped handler's body kept as
+// the message of a replaced reply.
+const maxNotFoundMessage = 512
+
+func (w *notFoundWriter) WriteHeader(code int) {
+	if code != http.StatusNotFound {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.replaced = true
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", w.kind)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *notFoundWriter) Write(p []byte) (int, error) {
+	if w.replaced {
+		// keep the start of the wrapped handler's body, for its message.
+		if n := maxNotFoundMessage - w.body.Len(); n > 0 {
+			if n > len(p) {
+				n = len(p)
+			}
+			w.body.Write(p[:n])
+		}
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// finish writes the body of a replaced reply. The wrapped handler's
+// message is kept, unless it is the plain "not found" of a file that does
+// not exist, as replied by the WebDAV handler or httpError.
+func (w *notFoundWriter) finish() {
+	if !w.replaced || w.r.Method == "HEAD" {
+		return
+	}
+	msg := strings.TrimSpace(w.body.String())
+	if strings.EqualFold(msg, "not found") || !utf8.ValidString(msg) {
+		msg = ""
+	}
+	if strings.HasPrefix(w.kind, "text/html") {
+		notFoundPage.Execute(w.ResponseWriter, struct{ Path, Message string }{w.r.URL.Path, msg})
+		return
+	}
+	if msg == "" {
+		msg = "not found"
+	}
+	json.NewEncoder(w.ResponseWriter).Encode(struct {
+		Error string `json:"error"`
+		Path  string `json:"path"`
+	}{msg, w.r.URL.Path})
+}
+
+// Flush implements http.Flusher, so streamed replies are not delayed.
+func (w *notFoundWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
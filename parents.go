@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// parents serves the parents of the served commit, with the first line of
+// each parent's message. Following a parent by requesting
+// /parents.json?commit=<parent> walks the commit graph.
+type parents struct {
+	served *served
+}
+
+func (p *parents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	commit, _ := p.served.get()
+	if commit == nil {
+		http.Error(w, "serving a tree, not a commit", http.StatusNotFound)
+		return
+	}
+	type parent struct {
+		SHA     string `json:"sha"`
+		Summary string `json:"summary,omitempty"`
+	}
+	resp := struct {
+		Commit  string   `json:"commit"`
+		Parents []parent `json:"parents"`
+	}{
+		Commit:  commit.String(),
+		Parents: make([]parent, 0, len(commit.Parents)),
+	}
+	for _, sha := range commit.Parents {
+		pc, err := commit.Commit(sha)
+		if err != nil && !git.IsNotExist(err) {
+			httpError(w, err)
+			return
+		}
+		var summary string
+		if pc != nil {
+			// the parent may be missing from a shallow clone.
+			summary = pc.Summary()
+		}
+		resp.Parents = append(resp.Parents, parent{SHA: sha, Summary: summary})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// maxCommitRoutes is the number of commits for which commitParam keeps
+// the handler returned by routes.
+const maxCommitRoutes = 16
+
+// commitParam wraps the handler for the served commit. Requests with a
+// commit query parameter are instead served from the commit it names, by
+// a handler returned by routes. Object ids may be abbreviated, and revs
+// which cannot be resolved, or whose abbreviation is ambiguous, are bad
+// requests. Tags are peeled to the commit they tag; revs naming trees or
+// blobs are not found, as serving them at the root would move paths
+// hidden by a filter out from under it. The handlers of the commits most
+// recently requested are kept in routesCache, so the results they cache,
+// as for /at/ and /treehash, are reused.
+type commitParam struct {
+	repo *git.Repository
+	http.Handler
+	routes      func(*served) http.Handler
+	routesCache *lru // of http.Handler by commit id
+}
+
+func (c *commitParam) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rev := r.URL.Query().Get("commit")
+	if rev == "" {
+		c.Handler.ServeHTTP(w, r)
+		return
+	}
+	sha, err := c.repo.ResolveRev(rev)
+	switch {
+	case git.IsAmbiguous(err):
+		http.Error(w, fmt.Sprintf("ambiguous revision %q", rev), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("unknown revision %q", rev), http.StatusBadRequest)
+		return
+	}
+	sha, err = c.repo.ResolveRev(sha + "^{commit}")
+	if err != nil || !c.repo.Exists(sha) {
+		http.Error(w, "unknown commit", http.StatusNotFound)
+		return
+	}
+	if h, ok := c.routesCache.get(sha); ok {
+		h.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	commit, err := c.repo.Commit(sha)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	h := c.routes(&served{commit: commit, tree: tree})
+	c.routesCache.add(sha, h)
+	h.ServeHTTP(w, r)
+}
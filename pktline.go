@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// maxPktLine is the longest pkt-line, including its length prefix.
+const maxPktLine = 65520
+
+// writePktLine writes s to w as a pkt-line: its length, including the
+// four byte length itself, in hex, followed by s.
+func writePktLine(w io.Writer, s string) error {
+	if len(s)+4 > maxPktLine {
+		return errors.Errorf("pkt-line too long: %d bytes", len(s))
+	}
+	_, err := fmt.Fprintf(w, "%04x%s", len(s)+4, s)
+	return errors.WithStack(err)
+}
+
+// writeFlush writes a flush-pkt, 0000, to w, marking the end of a list
+// of pkt-lines.
+func writeFlush(w io.Writer) error {
+	_, err := io.WriteString(w, "0000")
+	return errors.WithStack(err)
+}
+
+// readPktLine reads a pkt-line from r returning its payload, or reports
+// flush if it is a flush-pkt.
+func readPktLine(r io.Reader) (line string, flush bool, err error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", false, errors.WithStack(err)
+	}
+	n, err := strconv.ParseUint(string(hdr[:]), 16, 16)
+	if err != nil {
+		return "", false, errors.Errorf("invalid pkt-line length %q", hdr[:])
+	}
+	switch {
+	case n == 0:
+		return "", true, nil
+	case n < 4 || n > maxPktLine:
+		return "", false, errors.Errorf("invalid pkt-line length %q", hdr[:])
+	}
+	buf := make([]byte, n-4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", false, errors.WithStack(err)
+	}
+	return string(buf), false, nil
+}
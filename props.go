@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// propNamespace is the XML namespace of gitdav's WebDAV properties.
+const propNamespace = "https://github.com/davecheney/gitdav"
+
+// executableProp is the executable property of Apache's mod_dav, which
+// is "T" for executable files and "F" otherwise.
+var executableProp = xml.Name{Space: "http://apache.org/dav/props/", Local: "executable"}
+
+// The quota properties of RFC 4331, reported for directories.
+var (
+	quotaUsedProp      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+	quotaAvailableProp = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+)
+
+// DeadProps returns the git properties of the blob, so they are
+// reported by allprop and propname PROPFIND requests.
+func (b *blob) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := gitProps(map[string]string{
+		"commit":   b.commit,
+		"blob-sha": b.ID(),
+	})
+	if b.mode.IsRegular() {
+		executable := "F"
+		if b.mode&0111 != 0 {
+			executable = "T"
+		}
+		props[executableProp] = webdav.Property{
+			XMLName:  executableProp,
+			InnerXML: []byte(executable),
+		}
+	}
+	return props, nil
+}
+
+// Patch rejects all property changes; the properties of a blob are
+// derived from the repository.
+func (b *blob) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return forbidden(patches), nil
+}
+
+// DeadProps returns the git properties of the tree, so they are
+// reported by allprop and propname PROPFIND requests. The quota
+// properties report the total size of the served tree as used, and no
+// space available, as nothing may be written.
+func (t *tree) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := gitProps(map[string]string{
+		"commit":   t.commit,
+		"tree-sha": t.tree.ID(),
+	})
+	if t.root == nil {
+		return props, nil
+	}
+	used, err := servedSize(t.root, t.filter)
+	if err != nil {
+		// the quota is informational, so is omitted rather than
+		// failing the request.
+		log.Printf("%+v", err)
+		return props, nil
+	}
+	props[quotaUsedProp] = webdav.Property{
+		XMLName:  quotaUsedProp,
+		InnerXML: []byte(strconv.FormatInt(used, 10)),
+	}
+	props[quotaAvailableProp] = webdav.Property{
+		XMLName:  quotaAvailableProp,
+		InnerXML: []byte("0"),
+	}
+	return props, nil
+}
+
+// maxServedSizes is the number of trees whose size servedSizes holds.
+const maxServedSizes = 16
+
+// servedSizes holds the sizes returned by servedSize by root tree id, for
+// the trees most recently served. A tree's content never changes, and
+// the filter is fixed for the life of the process, so sizes are never
+// invalidated, only evicted.
+var servedSizes = newLRU(maxServedSizes)
+
+// servedSize returns the total size of the files in root which filter
+// allows, computed on first use, then cached.
+func servedSize(root *git.Tree, filter *pathFilter) (int64, error) {
+	if n, ok := servedSizes.get(root.ID()); ok {
+		return n.(int64), nil
+	}
+
+	var n int64
+	var err error
+	if filter == nil {
+		n, err = root.Size()
+	} else {
+		err = root.Walk(func(p string, e *git.Entry) error {
+			if e.Mode.IsDir() || e.Mode&os.ModeIrregular != 0 || !filter.allowed(p, false) {
+				return nil
+			}
+			size, err := e.Size()
+			n += size
+			return err
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+	servedSizes.add(root.ID(), n)
+	return n, nil
+}
+
+// Patch rejects all property changes; the properties of a tree are
+// derived from the repository.
+func (t *tree) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return forbidden(patches), nil
+}
+
+// gitProps returns a property in the gitdav namespace for each
+// local name and value in props. Properties with no value, like the
+// commit when a tree is served directly, are omitted.
+func gitProps(props map[string]string) map[xml.Name]webdav.Property {
+	m := make(map[xml.Name]webdav.Property, len(props))
+	for local, value := range props {
+		if value == "" {
+			continue
+		}
+		name := xml.Name{Space: propNamespace, Local: local}
+		var buf bytes.Buffer
+		xml.EscapeText(&buf, []byte(value))
+		m[name] = webdav.Property{
+			XMLName:  name,
+			InnerXML: buf.Bytes(),
+		}
+	}
+	return m
+}
+
+// forbidden returns a 403 Forbidden Propstat for every property in patches.
+func forbidden(patches []webdav.Proppatch) []webdav.Propstat {
+	pstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []webdav.Propstat{pstat}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the bytes of file content sent
+// on a connection to rate bytes a second, allowing bursts of up to a
+// second's worth. It is shared by every request on the connection, so
+// concurrent HTTP/2 streams share the rate.
+type rateLimiter struct {
+	rate float64 // bytes a second
+
+	mu     sync.Mutex
+	tokens float64 // bytes which may be sent now; negative once reserved ahead
+	last   time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes may be sent, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	// reserve n bytes, waiting for the deficit, if any, to refill.
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connLimiterKey is the context key of the rateLimiter of a connection.
+type connLimiterKey struct{}
+
+// limitConns returns a function for http.Server's ConnContext giving
+// each connection its own rateLimiter of rate bytes a second.
+func limitConns(rate int64) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, _ net.Conn) context.Context {
+		return context.WithValue(ctx, connLimiterKey{}, newRateLimiter(rate))
+	}
+}
+
+// throttle returns w limited to the rate of the connection r arrived on,
+// or w itself if the connection is not limited.
+func throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	l, ok := r.Context().Value(connLimiterKey{}).(*rateLimiter)
+	if !ok {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, l: l, ctx: r.Context()}
+}
+
+// throttledWriter is a ResponseWriter whose writes wait for its
+// connection's rateLimiter. Writes are split so none exceeds the burst
+// the limiter allows.
+type throttledWriter struct {
+	http.ResponseWriter
+	l   *rateLimiter
+	ctx context.Context
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	chunk := int(t.l.rate)
+	if chunk < 1 {
+		chunk = 1
+	}
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunk {
+			n = chunk
+		}
+		if err := t.l.wait(t.ctx, n); err != nil {
+			return written, err
+		}
+		n, err := t.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
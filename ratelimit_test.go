@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRateLimit checks a file served on a connection limited by
+// -rate-limit is sent, after the first second's worth, no faster than the
+// rate, and not much slower.
+func TestRateLimit(t *testing.T) {
+	repo := openTestRepo(t)
+	defer repo.Close()
+	s := serveRev(t, repo, "master")
+	_, root := s.get()
+	e, err := root.Lookup("d/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := e.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first half is sent at once, the second over a second.
+	rate := size / 2
+	srv := httptest.NewUnstartedServer(filesHandler(s, blobs{}))
+	srv.Config.ConnContext = limitConns(rate)
+	srv.Start()
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "/d/big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	elapsed := time.Since(start)
+	if resp.StatusCode != http.StatusOK || int64(len(body)) != size {
+		t.Fatalf("GET /d/big.txt: got %s, %d bytes, want %d", resp.Status, len(body), size)
+	}
+	want := time.Duration(float64(size-rate) / float64(rate) * float64(time.Second))
+	if elapsed < want*9/10 || elapsed > want+2*time.Second {
+		t.Errorf("sent %d bytes at %d bytes a second in %v, want about %v", size, rate, elapsed, want)
+	}
+}
+
+// TestRateLimiterCancel checks a write waiting for the rate limit stops
+// when its request is cancelled.
+func TestRateLimiterCancel(t *testing.T) {
+	l := newRateLimiter(1000)
+	if err := l.wait(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// a minute's worth, sent after the burst.
+	if err := l.wait(ctx, 60000); err != context.DeadlineExceeded {
+		t.Errorf("wait: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("wait returned after %v, want when cancelled", elapsed)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// rawObjects serves GET /objects/raw/<sha>, the object sha as git stores
+// it on disk, not its inflated content, for caches which store objects
+// verbatim. A loose object is served as application/x-git-loose-object.
+// A packed object is served as application/x-git-packed-object: its pack
+// entry, with the name of the pack and the entry's offset in the
+// X-Git-Pack and X-Git-Pack-Offset headers. Any object in the repository
+// may be read, not only those of the commit being served.
+type rawObjects struct {
+	repo *git.Repository
+}
+
+func (h *rawObjects) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sha := strings.TrimPrefix(r.URL.Path, "/objects/raw/")
+	if len(sha) != 40 || strings.Trim(sha, "0123456789abcdef") != "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	raw, err := h.repo.ReadRaw(sha)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer raw.Close()
+	if raw.Pack == "" {
+		w.Header().Set("Content-Type", "application/x-git-loose-object")
+	} else {
+		w.Header().Set("Content-Type", "application/x-git-packed-object")
+		w.Header().Set("X-Git-Pack", raw.Pack)
+		w.Header().Set("X-Git-Pack-Offset", strconv.FormatInt(raw.Offset, 10))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(raw.Size, 10))
+	// objects are immutable, the id names their content.
+	w.Header().Set("ETag", `"`+sha+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if r.Method == "HEAD" {
+		return
+	}
+	io.Copy(w, raw)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ready answers readiness checks, replying 200 OK once gitdav is serving.
+type ready struct{}
+
+func (ready) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ready\n")
+}
+
+// unready replies to every request, including readiness checks, with 503
+// Service Unavailable, giving the reason gitdav cannot serve.
+type unready struct {
+	reason error
+}
+
+func (u *unready) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "service unavailable: "+u.reason.Error(), http.StatusServiceUnavailable)
+}
+
+// serveUnready listens on addr, or the socket passed by systemd, and
+// replies to every request as unready does until SIGINT or SIGTERM, so an
+// orchestrator sees gitdav running but failing its readiness check,
+// rather than restarting it repeatedly, while reason is investigated.
+func serveUnready(addr string, reason error) error {
+	l, activated, err := activationListener()
+	if err != nil {
+		return err
+	}
+	if !activated {
+		l, err = net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("listening on http://%s/, not ready: %v", l.Addr(), reason)
+	srv := &http.Server{Handler: &unready{reason: reason}}
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("shutting down")
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Printf("%+v", err)
+		}
+		close(done)
+	}()
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	<-done
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// refsDir is a webdav.FileSystem serving every branch and tag of a
+// repository, mirroring git's ref namespaces. The tree of each branch is
+// served below /heads/<branch>/ and that of each tag below /tags/<tag>/.
+// Annotated tags are also described by a file, /tags/<tag>.tag, holding
+// the tag object. Refs are read on each request so changes to them are
+// served without restarting.
+type refsDir struct {
+	repo *git.Repository
+
+	// noListing hides the contents of directories.
+	noListing bool
+
+	// cache, if not nil, holds the contents of blobs once read.
+	cache *blobCache
+
+	// filter, if not nil, restricts the paths served within each tree.
+	filter *pathFilter
+
+	// times, if not nil, holds the times each path was last modified.
+	times *fileTimes
+}
+
+// refNamespaces maps the top level directories of a refsDir to the
+// methods returning the refs served below them.
+var refNamespaces = map[string]func(*git.Repository) (map[string]string, error){
+	"heads": (*git.Repository).Branches,
+	"tags":  (*git.Repository).Tags,
+}
+
+func (d *refsDir) Mkdir(path string, mode os.FileMode) error { return os.ErrInvalid }
+func (d *refsDir) RemoveAll(name string) error               { return os.ErrInvalid }
+func (d *refsDir) Rename(oldName, newName string) error      { return os.ErrInvalid }
+
+func (d *refsDir) Stat(name string) (os.FileInfo, error) {
+	f, err := d.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (d *refsDir) OpenFile(name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	if p == "" {
+		var entries []os.FileInfo
+		for ns := range refNamespaces {
+			entries = append(entries, &fileinfo{name: ns, mode: os.ModeDir | 0755})
+		}
+		return d.list("/", entries), nil
+	}
+	ns, p := split(p)
+	refs, ok := refNamespaces[ns]
+	if !ok {
+		return nil, notExist(name)
+	}
+	m, err := refs(d.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// ref names may contain slashes, so find the longest ref name which
+	// is a prefix of the path, the remainder of which is within its tree.
+	for ref := p; ref != "" && ref != "."; ref = path.Dir(ref) {
+		if sha, ok := m[ref]; ok {
+			return d.openRef(ref, sha, strings.TrimPrefix(p[len(ref):], "/"))
+		}
+	}
+	if ns == "tags" && strings.HasSuffix(p, ".tag") {
+		if sha, ok := m[strings.TrimSuffix(p, ".tag")]; ok {
+			if t, err := d.repo.Tag(sha); err == nil {
+				return &tagFile{name: path.Base(p), Reader: bytes.NewReader(formatTag(t))}, nil
+			}
+		}
+	}
+
+	// otherwise p may be a directory of ref names, like the feature
+	// directory holding the branch feature/x.
+	prefix := ""
+	if p != "" {
+		prefix = p + "/"
+	}
+	seen := make(map[string]bool)
+	var entries []os.FileInfo
+	for ref, sha := range m {
+		if !strings.HasPrefix(ref, prefix) {
+			continue
+		}
+		first, rest := split(ref[len(prefix):])
+		if !seen[first] {
+			seen[first] = true
+			entries = append(entries, &fileinfo{name: first, mode: os.ModeDir | 0755})
+		}
+		if ns == "tags" && rest == "" {
+			if t, err := d.repo.Tag(sha); err == nil {
+				entries = append(entries, &fileinfo{name: first + ".tag", size: int64(len(formatTag(t))), mode: 0644})
+			}
+		}
+	}
+	if len(entries) == 0 && p != "" {
+		return nil, notExist(name)
+	}
+	return d.list(path.Base("/"+ns+"/"+p), entries), nil
+}
+
+// treeRoot returns the path of the root of the tree of the ref holding
+// name, as ns/ref, or "" if name is not within the tree of a ref.
+func (d *refsDir) treeRoot(name string) string {
+	ns, p := split(strings.Trim(path.Clean("/"+name), "/"))
+	refs, ok := refNamespaces[ns]
+	if !ok {
+		return ""
+	}
+	m, err := refs(d.repo)
+	if err != nil {
+		return ""
+	}
+	for ref := p; ref != "" && ref != "."; ref = path.Dir(ref) {
+		if _, ok := m[ref]; ok {
+			return ns + "/" + ref
+		}
+	}
+	return ""
+}
+
+// openRef opens the file or directory at p within the tree of the commit
+// named by the ref, which points to sha.
+func (d *refsDir) openRef(ref, sha, p string) (webdav.File, error) {
+	id, err := d.repo.Peel(sha)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := d.repo.Commit(id)
+	if err != nil {
+		return nil, err
+	}
+	root, err := commit.Tree()
+	if git.IsMissingTree(err) {
+		// the ref cannot be served, but the error is not one webdav
+		// recognises as not found, and replies to with 404 Not Found.
+		log.Printf("%+v", err)
+		return nil, notExist(ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		mtimes := d.times.get(commit)
+		return &tree{
+			name:      path.Base(ref),
+			tree:      root,
+			root:      root,
+			commit:    commit.String(),
+			modTime:   modTime(mtimes, "", commit.Time),
+			times:     mtimes,
+			noListing: d.noListing,
+			filter:    d.filter,
+		}, nil
+	}
+	return openPath(commit, root, p, d.noListing, d.cache, d.filter, d.times)
+}
+
+// list returns a directory holding entries, sorted by name.
+func (d *refsDir) list(name string, entries []os.FileInfo) webdav.File {
+	if d.noListing {
+		entries = nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return &listing{name: name, entries: entries}
+}
+
+// split returns the first element of the slash separated path p, and the
+// remainder.
+func split(p string) (string, string) {
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+// notExist returns an error, satisfying os.IsNotExist, for the path name.
+func notExist(name string) error {
+	return &os.PathError{
+		Op:   "open",
+		Path: name,
+		Err:  os.ErrNotExist,
+	}
+}
+
+// formatTag returns the contents of the file describing the annotated
+// tag t, in the format of git cat-file -p.
+func formatTag(t *git.Tag) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "object %s\ntype %s\ntag %s\n", t.Object, t.Type, t.Name)
+	if t.Tagger != "" {
+		fmt.Fprintf(&buf, "tagger %s\n", t.Tagger)
+	}
+	fmt.Fprintf(&buf, "\n%s", t.Message)
+	return buf.Bytes()
+}
+
+// listing is a directory whose entries are not backed by a git tree.
+type listing struct {
+	name    string
+	entries []os.FileInfo
+	modTime time.Time // if zero, the current time is reported
+
+	off int // number of entries returned by Readdir
+}
+
+func (l *listing) Close() error             { return nil }
+func (l *listing) Read([]byte) (int, error) { return 0, os.ErrInvalid }
+
+// Readdir returns the entries following those returned by previous
+// calls, with the same semantics as tree's Readdir.
+func (l *listing) Readdir(n int) ([]os.FileInfo, error) {
+	rest := l.entries[l.off:]
+	if n > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(rest) {
+			rest = rest[:n]
+		}
+	}
+	l.off += len(rest)
+	return rest, nil
+}
+
+func (l *listing) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (l *listing) Stat() (os.FileInfo, error) {
+	return &fileinfo{name: l.name, mode: os.ModeDir | 0755, modTime: l.modTime}, nil
+}
+func (l *listing) Write(p []byte) (int, error) { return 0, os.ErrInvalid }
+
+// tagFile is the file describing an annotated tag.
+type tagFile struct {
+	name string
+	*bytes.Reader
+}
+
+func (f *tagFile) Close() error                       { return nil }
+func (f *tagFile) Readdir(int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *tagFile) Stat() (os.FileInfo, error) {
+	return &fileinfo{name: f.name, size: f.Size(), mode: 0644}, nil
+}
+func (f *tagFile) Write(p []byte) (int, error) { return 0, os.ErrInvalid }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// served holds the commit being served, and its tree. When polling a
+// commit file the commit may be replaced while serving. If a tree is
+// served directly, rather than the tree of a commit, commit is nil.
+type served struct {
+	mu     sync.RWMutex
+	commit *git.Commit
+	tree   *git.Tree
+}
+
+// get returns the commit being served and its tree.
+func (s *served) get() (*git.Commit, *git.Tree) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.commit, s.tree
+}
+
+// set replaces the commit being served.
+func (s *served) set(commit *git.Commit, tree *git.Tree) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commit, s.tree = commit, tree
+}
+
+// snapshot returns a served holding the commit s serves now, which is not
+// replaced when s is.
+func (s *served) snapshot() *served {
+	commit, tree := s.get()
+	return &served{commit: commit, tree: tree}
+}
+
+// snapshots serves each request with the commit served when the request
+// arrived, so a request which reads several files, as a PROPFIND of a
+// directory, sees one commit even if polling replaces it part way
+// through.
+type snapshots struct {
+	served *served
+
+	// handler returns the handler for requests serving s.
+	handler func(s *served) http.Handler
+}
+
+func (h *snapshots) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler(h.served.snapshot()).ServeHTTP(w, r)
+}
+
+// resolve returns the commit named by rev, and its tree. If rev names an
+// annotated tag, the tagged object is used. If rev names a tree, the
+// commit returned is nil. If rev names a blob, the tree returned holds
+// only the blob, called by the name of the tag, or the last element of
+// rev, and the commit returned is nil.
+func resolve(repo *git.Repository, rev string) (*git.Commit, *git.Tree, error) {
+	sha, err := repo.ResolveRev(rev)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !repo.Exists(sha) {
+		return nil, nil, errors.Errorf("commit %q not found in %s", rev, repo.Root)
+	}
+	name := path.Base(rev)
+	if tag, err := repo.Tag(sha); err == nil {
+		name = tag.Name
+	}
+	if sha, err = repo.Peel(sha); err != nil {
+		return nil, nil, err
+	}
+	kind, err := repo.Type(sha)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch kind {
+	case "tree":
+		tree, err := repo.Tree(sha)
+		return nil, tree, err
+	case "blob":
+		tree, err := repo.BlobTree(name, sha)
+		return nil, tree, err
+	}
+	commit, err := repo.Commit(sha)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	return commit, tree, nil
+}
+
+// commitID returns the id of commit, or "" if commit is nil because a
+// tree is being served.
+func commitID(commit *git.Commit) string {
+	if commit == nil {
+		return ""
+	}
+	return commit.String()
+}
+
+// commitTime returns the time of commit, or the zero time if commit is
+// nil because a tree is being served.
+func commitTime(commit *git.Commit) time.Time {
+	if commit == nil {
+		return time.Time{}
+	}
+	return commit.Time
+}
+
+// preloadTree reads every tree below tree, keeping them in memory, and
+// logs how many entries were found.
+func preloadTree(tree *git.Tree) error {
+	start := time.Now()
+	n, err := tree.Preload()
+	if err != nil {
+		return err
+	}
+	log.Printf("preloaded %d entries of tree %s in %v", n, tree.ID(), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// readCommitFile returns the commit id, or ref name, held in the file at path.
+func readCommitFile(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	rev := strings.TrimSpace(string(buf))
+	if rev == "" {
+		return "", errors.Errorf("commit file %q is empty", path)
+	}
+	return rev, nil
+}
+
+// maxWaitInterval is the longest waitForCommit waits between attempts.
+const maxWaitInterval = time.Minute
+
+// waitForCommit opens the repository with open, and resolves the commit
+// named by rev, retrying with increasing intervals until both succeed.
+// Each attempt opens the repository afresh, as packs added since it was
+// opened would not otherwise be found. It returns the repository, the
+// commit, and its tree, as resolve does.
+func waitForCommit(open func() (*git.Repository, error), rev func() (string, error)) (*git.Repository, *git.Commit, *git.Tree) {
+	for interval := time.Second; ; {
+		repo, commit, tree, err := openCommit(open, rev)
+		if err == nil {
+			return repo, commit, tree
+		}
+		log.Printf("waiting for commit: %v, retrying in %v", err, interval)
+		time.Sleep(interval)
+		if interval *= 2; interval > maxWaitInterval {
+			interval = maxWaitInterval
+		}
+	}
+}
+
+// openCommit opens the repository with open, and resolves the commit
+// named by rev in it. The repository is closed if the commit cannot be
+// resolved.
+func openCommit(open func() (*git.Repository, error), rev func() (string, error)) (*git.Repository, *git.Commit, *git.Tree, error) {
+	repo, err := open()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	name, err := rev()
+	if err != nil {
+		repo.Close()
+		return nil, nil, nil, err
+	}
+	commit, tree, err := resolve(repo, name)
+	if err != nil {
+		repo.Close()
+		return nil, nil, nil, err
+	}
+	return repo, commit, tree, nil
+}
+
+// pollCommitFile rereads the commit file at path every interval, and
+// serves the commit it names whenever that changes. If preload is set,
+// the trees of the new commit are read before it is served. Errors are
+// logged and the current commit continues to be served.
+func pollCommitFile(repo *git.Repository, path string, interval time.Duration, s *served, preload bool) {
+	for range time.Tick(interval) {
+		rev, err := readCommitFile(path)
+		if err != nil {
+			log.Printf("%+v", err)
+			continue
+		}
+		sha, err := repo.ResolveRev(rev)
+		if err == nil {
+			sha, err = repo.Peel(sha)
+		}
+		if err != nil {
+			log.Printf("%+v", err)
+			continue
+		}
+		if commit, tree := s.get(); commitID(commit) == sha || (commit == nil && tree.ID() == sha) {
+			continue
+		}
+		commit, tree, err := resolve(repo, sha)
+		if err == nil && preload {
+			err = preloadTree(tree)
+		}
+		if err != nil {
+			log.Printf("%+v", err)
+			continue
+		}
+		if commit == nil {
+			log.Println("serving tree", tree.ID())
+		} else {
+			log.Println("serving commit", commit)
+		}
+		s.set(commit, tree)
+	}
+}
+
+// openRepository opens the repository at p. If strict, p must be the
+// root of the repository; otherwise the directories above p are searched,
+// and the repository found is logged if it is not at p, so serving an
+// ancestor repository by mistake is noticed. If p is a tar or zip file,
+// the repository within it is opened, with the limits of git.OpenArchive.
+// Warnings reading the repository, see git.Repository.SetWarnFunc, are
+// logged.
+func openRepository(p string, strict bool) (*git.Repository, error) {
+	var repo *git.Repository
+	var err error
+	switch {
+	case isArchive(p):
+		log.Printf("serving the repository in %s, support for archives is experimental", p)
+		repo, err = git.OpenArchive(p)
+	case strict:
+		repo, err = git.OpenStrict(p)
+	default:
+		repo, err = git.Open(p)
+		if err == nil {
+			if abs, err := filepath.Abs(p); err == nil && abs != repo.Root && abs != repo.GitDir() {
+				log.Printf("%s is not the root of a repository, using the repository at %s", abs, repo.Root)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	repo.SetWarnFunc(log.Printf)
+	return repo, nil
+}
+
+// isArchive reports whether p is a regular file ending in .tar or .zip.
+func isArchive(p string) bool {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".tar", ".zip":
+		fi, err := os.Stat(p)
+		return err == nil && fi.Mode().IsRegular()
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// pathSHA serves the git object id of the blob or tree at the requested
+// path, like git rev-parse <commit>:<path>. The id is returned as plain
+// text unless the client accepts application/json.
+type pathSHA struct {
+	served *served
+	filter *pathFilter // if not nil, paths it does not allow are not found
+}
+
+func (s *pathSHA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, root := s.served.get()
+	p := strings.TrimPrefix(r.URL.Path, "/sha")
+	sha := root.ID()
+	if strings.Trim(p, "/") != "" {
+		e, err := root.Lookup(p)
+		if err == nil && !s.filter.allowed(p, e.Mode.IsDir()) {
+			err = notExist(p)
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		sha = e.ID()
+	}
+	if preferred(r.Header.Get("Accept"), "application/json") != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Path string `json:"path"`
+			SHA  string `json:"sha"`
+		}{p, sha})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, sha)
+}
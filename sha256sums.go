@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// sha256Sums holds the SHA-256 of the content of blobs, for clients
+// which key content on a hash other than git's. The SHA-256 is calculated
+// on first request, by reading the whole blob, then cached by blob id for
+// the life of the process, as a blob's content never changes.
+type sha256Sums struct {
+	mu   sync.Mutex
+	sums map[string]string // blob id to hex encoded SHA-256
+}
+
+// sum returns the hex encoded SHA-256 of the content of the blob e.
+func (s *sha256Sums) sum(e *git.Entry) (string, error) {
+	s.mu.Lock()
+	sum, ok := s.sums[e.ID()]
+	s.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	b, err := e.Blob()
+	if err != nil {
+		return "", err
+	}
+	defer b.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, b); err != nil {
+		return "", err
+	}
+	sum = fmt.Sprintf("%x", h.Sum(nil))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sums == nil {
+		s.sums = make(map[string]string)
+	}
+	s.sums[e.ID()] = sum
+	return sum, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+)
+
+// shaTrailerName is the trailer holding the git blob id of the content
+// sent, as computed while sending it.
+const shaTrailerName = "X-Git-Sha"
+
+// shaTrailer wraps the ResponseWriter of a GET request for a blob,
+// hashing the content as http.ServeContent writes it, so its git blob id
+// can be sent as a trailer once the content has been. The trailer is only
+// declared for 200 OK responses, whose body is the whole blob; partial
+// content and other responses are passed through unchanged.
+type shaTrailer struct {
+	http.ResponseWriter
+	h      hash.Hash
+	status int
+}
+
+// newSHATrailer returns a shaTrailer for a blob of length size.
+func newSHATrailer(w http.ResponseWriter, size int64) *shaTrailer {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", size)
+	return &shaTrailer{ResponseWriter: w, h: h}
+}
+
+func (t *shaTrailer) WriteHeader(code int) {
+	t.status = code
+	if code == http.StatusOK {
+		// without a Content-Length the body is sent chunked, so the
+		// trailer may follow it.
+		t.Header().Del("Content-Length")
+		t.Header().Set("Trailer", shaTrailerName)
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *shaTrailer) Write(p []byte) (int, error) {
+	if t.status == 0 {
+		t.WriteHeader(http.StatusOK)
+	}
+	n, err := t.ResponseWriter.Write(p)
+	t.h.Write(p[:n])
+	return n, err
+}
+
+// finish sets the trailer, if it was declared, to the id of the content
+// written. If that is not id, the id of the blob served, the mismatch is
+// logged, and the client sees it too.
+func (t *shaTrailer) finish(id string) {
+	if t.status != http.StatusOK {
+		return
+	}
+	sum := hex.EncodeToString(t.h.Sum(nil))
+	if sum != id {
+		log.Printf("blob %s: content sent has id %s", id, sum)
+	}
+	t.Header().Set(shaTrailerName, sum)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// smartHTTP serves the fetch side of git's smart HTTP protocol, version
+// 0, so git may clone and fetch over http. The refs advertised, and so
+// the objects which may be fetched, are those returned by refs. Clients
+// asking for version 2 of the protocol fall back to version 0. Pushing is
+// not supported.
+type smartHTTP struct {
+	repo *git.Repository
+	refs func() ([]advertisedRef, error)
+}
+
+// advertise replies to GET /info/refs?service=<service> with the refs
+// advertised to git clients.
+func (h *smartHTTP) advertise(w http.ResponseWriter, r *http.Request, service string) {
+	if service != "git-upload-pack" {
+		http.Error(w, "service not enabled: "+service, http.StatusForbidden)
+		return
+	}
+	refs, err := h.refs()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	bw := bufio.NewWriter(w)
+	writePktLine(bw, "# service=git-upload-pack\n")
+	writeFlush(bw)
+	advertiseRefs(bw, refs)
+	bw.Flush()
+}
+
+// ServeHTTP replies to POST /git-upload-pack, a round of negotiation, and
+// once the client is done, the pack it fetches.
+func (h *smartHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/x-git-upload-pack-request" {
+		http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		// git compresses large requests, such as those with many haves.
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	refs, err := h.refs()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	bw := bufio.NewWriter(w)
+	n, err := uploadPack(bufio.NewReader(body), bw, h.repo, refs, true)
+	if err == nil {
+		err = errors.WithStack(bw.Flush())
+	}
+	if err != nil {
+		// the reply has started, so the error can only be logged.
+		bw.Flush()
+		log.Printf("%v: %+v", r.RemoteAddr, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("%v: sent %d objects", r.RemoteAddr, n)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	// maxSymlinks is the most symlinks followed resolving a path, as
+	// Linux allows, beyond which the path is taken to loop.
+	maxSymlinks = 40
+
+	// maxLinkTarget is the longest symlink target read.
+	maxLinkTarget = 4096
+)
+
+var (
+	// errSymlinkEscapes is returned by resolveSymlink for a target
+	// which is absolute, or outside the served tree.
+	errSymlinkEscapes = errors.New("symlink target is outside the served tree")
+
+	// errSymlinkLoop is returned by resolveSymlink for symlinks which
+	// refer to themselves, or to each other, or form a chain of more
+	// than maxSymlinks.
+	errSymlinkLoop = errors.New("too many levels of symlinks")
+)
+
+// redirectSymlink replies to a GET or HEAD request for the symlink b,
+// whose path in fs is name, with a redirect to the file it refers to, so
+// relative links in HTML served from there resolve from the target's
+// directory. Chains of symlinks are followed to the first path which is
+// not a symlink, or does not exist, so the client is redirected once.
+// Targets outside the served tree are refused with 403 Forbidden, and
+// symlinks which loop with 508 Loop Detected.
+func redirectSymlink(w http.ResponseWriter, r *http.Request, fs webdav.FileSystem, prefix, name string, b *blob) error {
+	target, dir, err := resolveSymlink(fs, name, b)
+	switch {
+	case err == errSymlinkEscapes:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil
+	case err == errSymlinkLoop:
+		http.Error(w, err.Error(), http.StatusLoopDetected)
+		return nil
+	case err != nil:
+		httpError(w, err)
+		return err
+	}
+	if dir && target != "" {
+		target += "/"
+	}
+	u := url.URL{Path: prefix + "/" + target, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, u.String(), http.StatusFound)
+	return nil
+}
+
+// treeRooter is implemented by file systems serving several trees, as
+// refsDir serves the tree of each ref, returning the path of the root of
+// the tree holding name, or "" if there is none.
+type treeRooter interface {
+	treeRoot(name string) string
+}
+
+// resolveSymlink returns the path in fs, relative to its root, of the
+// file the symlink b, at name, refers to, following any symlinks it
+// refers to in turn, and whether the path is a directory. Symlinks to
+// directories are followed only as the last element of a path; a target
+// reached through one is not found. Targets must be within the tree
+// holding name.
+func resolveSymlink(fs webdav.FileSystem, name string, b *blob) (string, bool, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	root := ""
+	if tr, ok := fs.(treeRooter); ok {
+		root = tr.treeRoot(p)
+	}
+	seen := map[string]bool{p: true}
+	for {
+		buf, err := ioutil.ReadAll(io.LimitReader(b, maxLinkTarget))
+		if err != nil {
+			return "", false, err
+		}
+		target := string(buf)
+		if path.IsAbs(target) {
+			return "", false, errSymlinkEscapes
+		}
+		p = path.Join(path.Dir(p), target)
+		if p == ".." || strings.HasPrefix(p, "../") || (root != "" && p != root && !strings.HasPrefix(p, root+"/")) {
+			return "", false, errSymlinkEscapes
+		}
+		if p == "." {
+			p = ""
+		}
+		if seen[p] || len(seen) > maxSymlinks {
+			return "", false, errSymlinkLoop
+		}
+		seen[p] = true
+
+		f, err := fs.OpenFile(p, os.O_RDONLY, 0)
+		if os.IsNotExist(err) {
+			// the client is sent on to find nothing there.
+			return p, false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		next, ok := f.(*blob)
+		if !ok || next.mode&os.ModeSymlink == 0 {
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil {
+				return "", false, err
+			}
+			return p, fi.IsDir(), nil
+		}
+		defer f.Close()
+		b = next
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/git"
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// TestSymlinkRedirect checks that, with -symlink-redirect, a GET of a
+// symlink whose target is in the served tree is redirected to it, one
+// whose target is outside it is refused, and symlinks which loop are
+// reported, whether the tree is served alone, or as one of refsDir's.
+func TestSymlinkRedirect(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir, err := gittest.New(t.TempDir(), gittest.Loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"in":         "d/big.txt",
+		"dir":        "d",
+		"chain":      "in",
+		"missing":    "nope",
+		"sub/up":     "../a.txt",
+		"abs":        "/etc/passwd",
+		"out":        "../x",
+		"sub/out":    "../../a.txt",
+		"other-ref":  "../master/a.txt",
+		"chain-out":  "out",
+		"self":       "self",
+		"loop1":      "loop2",
+		"loop2":      "loop1",
+		"sub/loop":   "../sub/loop",
+		"chain-loop": "loop1",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"checkout", "-q", "-b", "links"},
+		{"add", "."},
+		{"commit", "-q", "-m", "links"},
+	} {
+		if out, err := gittest.Command(dir, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	repo, err := git.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	tests := []struct {
+		path     string
+		want     int
+		location string
+	}{
+		{"/in", http.StatusFound, "/d/big.txt"},
+		{"/in?x=1", http.StatusFound, "/d/big.txt?x=1"},
+		{"/dir", http.StatusFound, "/d/"},
+		{"/chain", http.StatusFound, "/d/big.txt"},
+		{"/missing", http.StatusFound, "/nope"},
+		{"/sub/up", http.StatusFound, "/a.txt"},
+		{"/abs", http.StatusForbidden, ""},
+		{"/out", http.StatusForbidden, ""},
+		{"/sub/out", http.StatusForbidden, ""},
+		{"/chain-out", http.StatusForbidden, ""},
+		{"/self", http.StatusLoopDetected, ""},
+		{"/loop1", http.StatusLoopDetected, ""},
+		{"/sub/loop", http.StatusLoopDetected, ""},
+		{"/chain-loop", http.StatusLoopDetected, ""},
+	}
+	check := func(t *testing.T, h http.Handler, prefix string) {
+		for _, tt := range tests {
+			location := tt.location
+			if location != "" {
+				location = prefix + location
+			}
+			for _, method := range []string{"GET", "HEAD"} {
+				w := serve(h, method, prefix+tt.path)
+				if w.Code != tt.want || w.Header().Get("Location") != location {
+					t.Errorf("%s %s: got %d, Location %q, want %d, %q", method, prefix+tt.path, w.Code, w.Header().Get("Location"), tt.want, location)
+				}
+			}
+		}
+	}
+	t.Run("tree", func(t *testing.T) {
+		s := serveRev(t, repo, "links")
+		check(t, filesHandler(s, blobs{symlinkRedirect: true}), "")
+
+		// ../master/a.txt is outside the tree served at the root.
+		if w := serve(filesHandler(s, blobs{symlinkRedirect: true}), "GET", "/other-ref"); w.Code != http.StatusForbidden {
+			t.Errorf("GET /other-ref: got %d, want %d", w.Code, http.StatusForbidden)
+		}
+		// without -symlink-redirect, the target is served.
+		w := serve(filesHandler(s, blobs{}), "GET", "/out")
+		if w.Code != http.StatusOK || w.Body.String() != "../x" {
+			t.Errorf("GET /out: got %d, %q, want %d, %q", w.Code, w.Body.String(), http.StatusOK, "../x")
+		}
+	})
+	t.Run("refs", func(t *testing.T) {
+		h := fsHandler(&refsDir{repo: repo}, blobs{symlinkRedirect: true})
+		check(t, h, "/heads/links")
+
+		// the tree of another ref is not the tree holding the symlink.
+		if w := serve(h, "GET", "/heads/links/other-ref"); w.Code != http.StatusForbidden {
+			t.Errorf("GET /heads/links/other-ref: got %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+}
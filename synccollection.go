@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// maxReportSize is the largest REPORT request body read.
+const maxReportSize = 1 << 20
+
+// syncCollection wraps a handler serving the contents of the served tree,
+// answering REPORT requests for the sync-collection report of RFC 6578
+// itself. The sync token is the id of the commit served, or of the tree
+// if a tree is served directly, so the members changed since a client's
+// token are found by diffing the tree it names against the one served.
+// Other requests, including other reports, are passed to Handler.
+//
+// With sync-level infinity, the files below the collection are reported,
+// and directories are implied by the files within them. With sync-level
+// 1, the immediate members of the collection are reported, a directory
+// being changed when any file below it is. Members which no longer exist
+// are reported with 404 Not Found. The getetag, getcontentlength, and
+// resourcetype properties are supported; the ETag of a file is its blob
+// id, as for GET. Other properties are reported as not found.
+type syncCollection struct {
+	repo   *git.Repository
+	served *served
+	prefix string      // removed from the URL path to form the path in the tree
+	filter *pathFilter // if not nil, paths it does not allow are omitted
+	http.Handler
+
+	// Logger is called for each report served, as by webdav.Handler.
+	Logger func(*http.Request, error)
+}
+
+// syncCollectionRequest is the body of a sync-collection REPORT request.
+type syncCollectionRequest struct {
+	XMLName   xml.Name
+	SyncToken string `xml:"DAV: sync-token"`
+	SyncLevel string `xml:"DAV: sync-level"`
+	Limit     struct {
+		NResults int `xml:"DAV: nresults"`
+	} `xml:"DAV: limit"`
+	Prop struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+func (sc *syncCollection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "REPORT" {
+		sc.Handler.ServeHTTP(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReportSize))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req syncCollectionRequest
+	err = xml.Unmarshal(body, &req)
+	if err != nil || req.XMLName != (xml.Name{Space: "DAV:", Local: "sync-collection"}) {
+		// not a report answered here.
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sc.Handler.ServeHTTP(w, r)
+		return
+	}
+	err = sc.report(w, r, &req)
+	if sc.Logger != nil {
+		sc.Logger(r, err)
+	}
+}
+
+// report answers the sync-collection report req for the collection named
+// by r.
+func (sc *syncCollection) report(w http.ResponseWriter, r *http.Request, req *syncCollectionRequest) error {
+	if depth := r.Header.Get("Depth"); depth != "" && depth != "0" {
+		http.Error(w, "sync-collection requires Depth: 0", http.StatusBadRequest)
+		return nil
+	}
+	var infinite bool
+	switch strings.TrimSpace(req.SyncLevel) {
+	case "1":
+	case "infinity":
+		infinite = true
+	default:
+		http.Error(w, "sync-level must be 1 or infinity", http.StatusBadRequest)
+		return nil
+	}
+
+	commit, root := sc.served.get()
+	token := root.ID()
+	if commit != nil {
+		token = commit.String()
+	}
+	dir := strings.Trim(path.Clean("/"+strings.TrimPrefix(r.URL.Path, sc.prefix)), "/")
+	collection := root
+	if dir != "" {
+		e, err := root.Lookup(dir)
+		if err == nil && !sc.filter.allowed(dir, e.Mode.IsDir()) {
+			err = notExist(dir)
+		}
+		if err != nil {
+			httpError(w, err)
+			return err
+		}
+		if !e.Mode.IsDir() {
+			davError(w, http.StatusForbidden, "supported-report")
+			return nil
+		}
+		if collection, err = e.Subtree(); err != nil {
+			httpError(w, err)
+			return err
+		}
+	}
+
+	// member returns the path of the member of the collection reported
+	// for the change to the file at p, or "" if there is none.
+	member := func(p string) string {
+		if dir != "" {
+			if !strings.HasPrefix(p, dir+"/") {
+				return ""
+			}
+			p = strings.TrimPrefix(p, dir+"/")
+		}
+		if !infinite {
+			p = strings.SplitN(p, "/", 2)[0]
+		}
+		return path.Join(dir, p)
+	}
+	seen := make(map[string]bool)
+	var members []string
+	add := func(p string) {
+		if m := member(p); m != "" && !seen[m] {
+			seen[m] = true
+			members = append(members, m)
+		}
+	}
+	if old := strings.TrimSpace(req.SyncToken); old == "" {
+		// the initial sync reports every member.
+		err := collection.Walk(func(p string, e *git.Entry) error {
+			if !e.Mode.IsDir() {
+				add(path.Join(dir, p))
+			}
+			return nil
+		})
+		if err != nil {
+			httpError(w, err)
+			return err
+		}
+	} else {
+		// a token is only valid if it is an object id, rather than any
+		// revision, naming a commit or tree in the repository.
+		sha, err := sc.repo.ResolveRev(old)
+		valid := err == nil && sha == old && sc.repo.Exists(sha)
+		if valid {
+			_, err = sc.repo.ResolveRev(old + "^{tree}")
+			valid = err == nil
+		}
+		if !valid {
+			davError(w, http.StatusForbidden, "valid-sync-token")
+			return nil
+		}
+		changes, err := sc.repo.DiffRevs(old, token)
+		if err != nil {
+			httpError(w, err)
+			return err
+		}
+		for i := range changes {
+			add(changes[i].Path)
+		}
+	}
+	sort.Strings(members)
+
+	resp := syncMultistatus{NS: "DAV:", SyncToken: token}
+	for _, p := range members {
+		e, err := root.Lookup(p)
+		if git.IsNotExist(err) {
+			e, err = nil, nil
+		}
+		if err != nil {
+			httpError(w, err)
+			return err
+		}
+		if e != nil && e.Mode&os.ModeIrregular != 0 {
+			// submodules are not served.
+			continue
+		}
+		if !sc.filter.allowed(p, e != nil && e.Mode.IsDir()) {
+			continue
+		}
+		m, err := syncMember(sc.prefix, p, e, req)
+		if err != nil {
+			httpError(w, err)
+			return err
+		}
+		resp.Responses = append(resp.Responses, m)
+	}
+	if n := req.Limit.NResults; n > 0 && len(resp.Responses) > n {
+		davError(w, http.StatusInsufficientStorage, "number-of-matches-within-limits")
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	return xml.NewEncoder(w).Encode(&resp)
+}
+
+// syncMember returns the response describing the member at p, whose entry
+// is e, or nil if it has been removed, with the properties req asks for.
+func syncMember(prefix, p string, e *git.Entry, req *syncCollectionRequest) (syncResponse, error) {
+	href := prefix + "/" + p
+	if e != nil && e.Mode.IsDir() {
+		href += "/"
+	}
+	resp := syncResponse{Href: (&url.URL{Path: href}).EscapedPath()}
+	if e == nil {
+		resp.Status = statusLine(http.StatusNotFound)
+		return resp, nil
+	}
+	if len(req.Prop.Names) == 0 {
+		resp.Status = statusLine(http.StatusOK)
+		return resp, nil
+	}
+	var found, missing syncPropstat
+	for _, name := range req.Prop.Names {
+		prop := syncProp{XMLName: name.XMLName}
+		ok := name.XMLName.Space == "DAV:"
+		switch name.XMLName.Local {
+		case "resourcetype":
+			if e.Mode.IsDir() {
+				prop.InnerXML = "<D:collection xmlns:D=\"DAV:\"/>"
+			}
+		case "getetag":
+			prop.InnerXML = `"` + e.ID() + `"`
+			ok = ok && !e.Mode.IsDir()
+		case "getcontentlength":
+			ok = ok && !e.Mode.IsDir()
+			if ok {
+				size, err := e.Size()
+				if err != nil {
+					return resp, err
+				}
+				prop.InnerXML = strconv.FormatInt(size, 10)
+			}
+		default:
+			ok = false
+		}
+		if ok {
+			found.Prop.Props = append(found.Prop.Props, prop)
+		} else {
+			prop.InnerXML = ""
+			missing.Prop.Props = append(missing.Prop.Props, prop)
+		}
+	}
+	if len(found.Prop.Props) > 0 {
+		found.Status = statusLine(http.StatusOK)
+		resp.Propstat = append(resp.Propstat, found)
+	}
+	if len(missing.Prop.Props) > 0 {
+		missing.Status = statusLine(http.StatusNotFound)
+		resp.Propstat = append(resp.Propstat, missing)
+	}
+	return resp, nil
+}
+
+// statusLine returns the HTTP status line for code, as used in a
+// multistatus response.
+func statusLine(code int) string {
+	return "HTTP/1.1 " + strconv.Itoa(code) + " " + http.StatusText(code)
+}
+
+// davError replies with code, and a DAV:error body naming the
+// precondition which failed.
+func davError(w http.ResponseWriter, code int, precondition string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	io.WriteString(w, xml.Header+`<D:error xmlns:D="DAV:"><D:`+precondition+`/></D:error>`+"\n")
+}
+
+type syncMultistatus struct {
+	XMLName   xml.Name       `xml:"D:multistatus"`
+	NS        string         `xml:"xmlns:D,attr"`
+	Responses []syncResponse `xml:"D:response"`
+	SyncToken string         `xml:"D:sync-token"`
+}
+
+type syncResponse struct {
+	Href     string         `xml:"D:href"`
+	Propstat []syncPropstat `xml:"D:propstat"`
+	Status   string         `xml:"D:status,omitempty"`
+}
+
+type syncPropstat struct {
+	Prop struct {
+		Props []syncProp
+	} `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type syncProp struct {
+	XMLName  xml.Name
+	InnerXML string `xml:",innerxml"`
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BlobTransformer transforms the content of a blob before it is served,
+// as to render Markdown as HTML. Transform is called with the path of
+// the blob, relative to the root of the tree, and a reader of its
+// content, returning a reader of the transformed content and its
+// Content-Type. If the Content-Type is empty it is detected from the
+// transformed content. If the reader is an io.Closer it is closed once
+// the reply is sent, or the client has gone away.
+type BlobTransformer interface {
+	Transform(path string, r io.Reader) (io.Reader, string, error)
+}
+
+// transformers are the registered BlobTransformers, in the order they
+// were registered.
+var transformers []struct {
+	pattern []string // split into path elements
+	BlobTransformer
+}
+
+// RegisterTransformer registers t to transform the blobs whose path
+// matches pattern, matched as by -allow; **/*.md matches every Markdown
+// file. The first transformer registered for a path is used, and blobs
+// matched by none are served unchanged. RegisterTransformer is intended
+// to be called from the init function of a file added to this package.
+func RegisterTransformer(pattern string, t BlobTransformer) {
+	patterns, err := parsePatterns(pattern)
+	if err != nil || len(patterns) != 1 {
+		panic(errors.Errorf("RegisterTransformer: invalid pattern %q", pattern))
+	}
+	transformers = append(transformers, struct {
+		pattern []string
+		BlobTransformer
+	}{patterns[0], t})
+}
+
+// transformFlag is the value of -transform, which may be repeated, each
+// value being a pattern and a command, "<pattern>=<command>".
+type transformFlag []string
+
+func (f *transformFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *transformFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 || strings.TrimSpace(s[i+1:]) == "" {
+		return errors.Errorf("%q is not <pattern>=<command>", s)
+	}
+	if patterns, err := parsePatterns(s[:i]); err != nil || len(patterns) != 1 {
+		return errors.Errorf("invalid pattern %q", s[:i])
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// register registers a commandTransformer for each value of f.
+func (f transformFlag) register() {
+	for _, s := range f {
+		i := strings.Index(s, "=")
+		RegisterTransformer(s[:i], &commandTransformer{command: s[i+1:]})
+	}
+}
+
+// commandTransformer is a BlobTransformer which runs command with sh -c,
+// writing the content of the blob to its standard input, and serving its
+// standard output, whose Content-Type is detected. The path of the blob
+// is in the environment as GITDAV_PATH. The command's standard error is
+// gitdav's. If the command fails, the reply is cut short.
+type commandTransformer struct {
+	command string
+}
+
+func (t *commandTransformer) Transform(name string, r io.Reader) (io.Reader, string, error) {
+	cmd := exec.Command("/bin/sh", "-c", t.command)
+	cmd.Env = append(os.Environ(), "GITDAV_PATH="+name)
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	// stop writing the blob once the command has exited, even if a
+	// process it started still holds its standard input.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, "", errors.Wrapf(err, "could not run %q", t.command)
+	}
+	return &commandOutput{ReadCloser: out, cmd: cmd, done: make(chan struct{})}, "", nil
+}
+
+// commandOutput reads the standard output of the command run by a
+// commandTransformer, returning an error at the end of the output if the
+// command failed.
+type commandOutput struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+
+	once sync.Once
+	done chan struct{} // closed once the command has exited
+	err  error         // of the command, once done
+}
+
+func (c *commandOutput) Read(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		// the output was read to the end, or the command stopped by
+		// Close, and the pipe closed by Wait.
+		if c.err != nil {
+			return 0, c.err
+		}
+		return 0, io.EOF
+	default:
+	}
+	n, err := c.ReadCloser.Read(p)
+	switch {
+	case err == io.EOF:
+		c.once.Do(func() {
+			if err := c.cmd.Wait(); err != nil {
+				c.err = errors.Wrapf(err, "transform %q", c.cmd.Args[2])
+			}
+			close(c.done)
+		})
+		if c.err != nil {
+			err = c.err
+		}
+	case errors.Is(err, os.ErrClosed):
+		// closed by Close while reading.
+		<-c.done
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close stops the command if it is still running, as when the client
+// went away before reading all of the output. It may be called while a
+// Read is in progress.
+func (c *commandOutput) Close() error {
+	c.once.Do(func() {
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+		close(c.done)
+	})
+	return nil
+}
+
+// transformerFor returns the BlobTransformer registered for the path
+// name, or nil if there is none.
+func transformerFor(name string) BlobTransformer {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	elems := strings.Split(name, "/")
+	for _, t := range transformers {
+		if matchPath(t.pattern, elems, false) {
+			return t.BlobTransformer
+		}
+	}
+	return nil
+}
+
+// serveTransformed replies to a GET or HEAD request for the blob b, whose
+// path is name, with its content transformed by t. The length of the
+// transformed content is not known in advance, so Content-Length is
+// omitted, and Range requests are answered with the whole content. The
+// ETag is weak, as the transformed content may differ byte for byte
+// between versions of the transformer. An error before the reply is
+// started is replied to by httpError; an error copying the content, once
+// the reply has started, is returned.
+func serveTransformed(w http.ResponseWriter, r *http.Request, t BlobTransformer, name string, b *blob) error {
+	etag := `W/"` + b.ID() + `"`
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchWeakETag(inm, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	tr, ctype, err := t.Transform(strings.Trim(path.Clean("/"+name), "/"), b)
+	if err != nil {
+		httpError(w, err)
+		return nil
+	}
+	if c, ok := tr.(io.Closer); ok {
+		defer c.Close()
+		// the transformer may be waiting on a command which has not
+		// written anything yet, so is stopped if the client goes away.
+		stop := context.AfterFunc(r.Context(), func() { c.Close() })
+		defer stop()
+	}
+	if ctype == "" {
+		// read enough of the content to detect its type, as ServeContent
+		// does, and put it back in front of the rest.
+		var buf [512]byte
+		n, err := io.ReadFull(tr, buf[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			httpError(w, err)
+			return nil
+		}
+		ctype = http.DetectContentType(buf[:n])
+		tr = io.MultiReader(bytes.NewReader(buf[:n]), tr)
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("ETag", etag)
+	if !b.modTime.IsZero() {
+		w.Header().Set("Last-Modified", b.modTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return nil
+	}
+	_, err = io.Copy(w, tr)
+	return errors.WithStack(err)
+}
+
+// matchWeakETag reports whether the If-None-Match header value inm
+// matches etag, using the weak comparison If-None-Match requires.
+func matchWeakETag(inm, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
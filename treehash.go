@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// treeHash serves checksums covering the whole of the served tree.
+//
+// The tree field is the git object id of the root tree. As git trees are
+// content addressed it covers the name, mode, and content of every file
+// and directory, but verifying it requires the git object format.
+//
+// The manifest field, returned when the manifest query parameter is set,
+// is for clients that do not speak git. It is the hex SHA-256 of the
+// manifest formed by a line "<path> <id>\n" for each file, symlink, and
+// submodule in the tree, where path is relative to the root and id is
+// the git object id of the entry's content, sorted by path in byte order.
+// Paths are quoted as git ls-tree prints them, see quotePath, so a path
+// holding a newline cannot be mistaken for two lines.
+// It covers paths and content but not file modes or empty directories.
+// Paths filter does not allow are left out of the manifest, though the
+// tree id still covers them.
+// The manifest is calculated on first request for each tree, then cached.
+type treeHash struct {
+	served *served
+	filter *pathFilter // if not nil, paths it does not allow are omitted
+
+	mu       sync.Mutex
+	manifest map[string]string // tree id to manifest SHA-256
+}
+
+func (t *treeHash) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, root := t.served.get()
+	resp := struct {
+		Tree     string `json:"tree"`
+		Manifest string `json:"manifest,omitempty"`
+	}{
+		Tree: root.ID(),
+	}
+	if r.URL.Query().Get("manifest") != "" {
+		sum, err := t.sum(root)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		resp.Manifest = sum
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// sum returns the SHA-256 of the manifest of root.
+func (t *treeHash) sum(root *git.Tree) (string, error) {
+	t.mu.Lock()
+	sum, ok := t.manifest[root.ID()]
+	t.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	ids := make(map[string]string)
+	var paths []string
+	err := root.Walk(func(p string, e *git.Entry) error {
+		if !e.Mode.IsDir() && t.filter.allowed(p, false) {
+			ids[p] = e.ID()
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s %s\n", quotePath(p), ids[p])
+	}
+	sum = fmt.Sprintf("%x", h.Sum(nil))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.manifest == nil {
+		t.manifest = make(map[string]string)
+	}
+	t.manifest[root.ID()] = sum
+	return sum, nil
+}
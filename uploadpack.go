@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// capabilities are the capabilities advertised to git clients. None of
+// multi_ack, side-band, ofs-delta, shallow, or thin-pack are supported,
+// so clients fall back to the simplest form of the protocol: a single
+// common commit is acknowledged, and the pack is sent undeltified, with
+// no progress messages.
+const capabilities = "agent=gitdav"
+
+// advertisedRef is a ref advertised to git clients.
+type advertisedRef struct {
+	name string
+	id   string
+}
+
+// servedRefs returns the refs advertised to git clients fetching the
+// commit sha, named by rev: HEAD, and the ref rev names, if it is one.
+// rev may be empty if the commit was not named.
+func servedRefs(repo *git.Repository, rev, sha string) []advertisedRef {
+	refs := []advertisedRef{{name: "HEAD", id: sha}}
+	if ref, ok := repo.ResolveRef(rev); ok && rev != "" && ref != "HEAD" {
+		refs = append(refs, advertisedRef{name: ref, id: sha})
+	}
+	return refs
+}
+
+// advertiseRefs writes refs to w in the format of git upload-pack's ref
+// advertisement, the first ref followed by the capabilities, ending with
+// a flush-pkt.
+func advertiseRefs(w io.Writer, refs []advertisedRef) error {
+	for i, ref := range refs {
+		line := ref.id + " " + ref.name
+		if i == 0 {
+			line += "\x00" + capabilities
+		}
+		if err := writePktLine(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return writeFlush(w)
+}
+
+// uploadPack implements the negotiation of git upload-pack, for a client
+// that was sent refs by advertiseRefs. It reads the objects the client
+// wants, which must be advertised, and those it has, and replies with
+// ACK or NAK, then writes a pack of the wanted objects, and those they
+// refer to, less those reachable from the common objects. It returns the
+// number of objects sent, which is zero if the client wanted none, as
+// git ls-remote does. If stateless, as for smart HTTP, the request is a
+// single round of negotiation: when a batch of haves ends without done,
+// uploadPack replies and returns, the client resending its wants, and
+// the haves it has found in common, in the next request.
+func uploadPack(r io.Reader, w io.Writer, repo *git.Repository, refs []advertisedRef, stateless bool) (int, error) {
+	advertised := make(map[string]bool)
+	for _, ref := range refs {
+		advertised[ref.id] = true
+	}
+	var want []string
+	for {
+		line, flush, err := readPktLine(r)
+		if errors.Cause(err) == io.EOF && len(want) == 0 {
+			// the client hung up after the advertisement.
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if flush {
+			break
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 2 && fields[0] == "want":
+			if !advertised[fields[1]] {
+				writePktLine(w, "ERR upload-pack: not our ref "+fields[1])
+				return 0, errors.Errorf("client wants %s, which was not advertised", fields[1])
+			}
+			want = append(want, fields[1])
+		case len(fields) >= 1 && (fields[0] == "shallow" || fields[0] == "deepen"):
+			// shallow is not advertised, the client should not send these.
+			writePktLine(w, "ERR upload-pack: shallow clones are not supported")
+			return 0, errors.Errorf("unsupported request %q", strings.TrimSpace(line))
+		default:
+			return 0, errors.Errorf("unexpected request %q", strings.TrimSpace(line))
+		}
+	}
+	if len(want) == 0 {
+		return 0, nil
+	}
+
+	var have []string
+	for {
+		line, flush, err := readPktLine(r)
+		if err != nil {
+			return 0, err
+		}
+		if flush {
+			// the end of a batch of haves; without multi_ack NAK is sent
+			// only until a common object is found.
+			if len(have) == 0 {
+				if err := respond(w, "NAK\n"); err != nil {
+					return 0, err
+				}
+			}
+			if stateless {
+				return 0, nil
+			}
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "done" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "have" {
+			return 0, errors.Errorf("unexpected request %q", line)
+		}
+		if !repo.Exists(fields[1]) {
+			continue
+		}
+		if len(have) == 0 {
+			if err := respond(w, "ACK "+fields[1]+"\n"); err != nil {
+				return 0, err
+			}
+		}
+		have = append(have, fields[1])
+	}
+	if len(have) == 0 {
+		if err := respond(w, "NAK\n"); err != nil {
+			return 0, err
+		}
+	}
+
+	ids, err := repo.Reachable(want, have)
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), repo.WritePack(w, ids)
+}
+
+// respond writes the pkt-line s to w, flushing w if it is buffered, as
+// the client waits for ACK and NAK responses before it continues.
+func respond(w io.Writer, s string) error {
+	if err := writePktLine(w, s); err != nil {
+		return err
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return errors.WithStack(f.Flush())
+	}
+	return nil
+}
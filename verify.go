@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// verifyMain implements gitdav verify, which checks the object id of a
+// path in a commit is the one expected, as when confirming a deployed
+// file is the one committed. The id is that of the tree entry, so a
+// directory is checked by its tree id. It exits with status 1, printing
+// both ids, if they differ.
+func verifyMain(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	c := fs.String("c", "", "commit, or ref, holding the path")
+	strict := fs.Bool("strict", false, "require the path to be the root of the repository, rather than searching the directories above it")
+	fs.Parse(args)
+	if len(fs.Args()) != 3 || *c == "" {
+		fmt.Fprintln(os.Stderr, "usage: gitdav verify -c <commit> <repository> <path> <sha>")
+		os.Exit(2)
+	}
+	p, want := fs.Args()[1], strings.ToLower(fs.Args()[2])
+	repo, err := openRepository(fs.Args()[0], *strict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+	_, tree, err := resolve(repo, *c)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	e, err := tree.Lookup(p)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if got := e.ID(); got != want {
+		fmt.Fprintf(os.Stderr, "%s: mismatch\nexpected %s\nactual   %s\n", p, want, got)
+		repo.Close()
+		os.Exit(1)
+	}
+	fmt.Println(p, want, "ok")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version describes the running binary from the build info the go
+// command embeds: the path and version of the main module, the VCS
+// revision it was built from, with -dirty if the working tree was
+// modified, and the Go version. Builds without module or VCS information,
+// such as those from GOPATH, or with -buildvcs=false, report less.
+func version() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "gitdav, no build info, " + runtime.Version()
+	}
+	mod, ver := bi.Main.Path, bi.Main.Version
+	if mod == "" {
+		mod = "gitdav"
+	}
+	if ver == "" {
+		ver = "(unknown)"
+	}
+	var revision, modified string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	parts := []string{mod, ver}
+	if revision != "" {
+		parts = append(parts, "revision "+revision+modified)
+	}
+	return strings.Join(append(parts, runtime.Version()), " ")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/davecheney/gitdav/internal/git"
+)
+
+// worktreeDir is a webdav.FileSystem serving the files of a repository's
+// working tree, as they are on disk, rather than those of a commit. Files
+// ignored by git, as git status would report them, are hidden, as are
+// those outside the sparse checkout, if there is one.
+type worktreeDir struct {
+	root     string
+	excludes *git.Excludes
+	sparse   *git.SparseCheckout // may be nil
+
+	// noListing hides the contents of directories.
+	noListing bool
+
+	// filter, if not nil, restricts the paths served.
+	filter *pathFilter
+}
+
+func (d *worktreeDir) Mkdir(path string, mode os.FileMode) error { return os.ErrInvalid }
+func (d *worktreeDir) RemoveAll(name string) error               { return os.ErrInvalid }
+func (d *worktreeDir) Rename(oldName, newName string) error      { return os.ErrInvalid }
+
+// resolve returns the path on disk of the file name refers to, following
+// any symlinks, and its FileInfo, named as name is. An error is returned
+// if name does not exist, or it, or the file it refers to, is ignored, or
+// it refers to a file outside the working tree, which is reported as not
+// existing.
+func (d *worktreeDir) resolve(name string) (string, os.FileInfo, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	file, rel, err := d.within(filepath.Join(d.root, filepath.FromSlash(p)))
+	if err == errSymlinkEscapes {
+		return "", nil, notExist(name)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, p := range []string{p, rel} {
+		visible, err := d.visible(p, fi.IsDir())
+		if err != nil {
+			return "", nil, err
+		}
+		if !visible {
+			return "", nil, notExist(name)
+		}
+	}
+	if fi.Name() != path.Base("/"+p) {
+		// name is, or is within, a symlink.
+		fi = &fileinfo{name: path.Base("/" + p), size: fi.Size(), mode: fi.Mode(), modTime: fi.ModTime()}
+	}
+	return file, fi, nil
+}
+
+// within returns file, with the symlinks on its path resolved, and the
+// slash separated path of the result relative to the root of the working
+// tree, or errSymlinkEscapes if it resolves to a file outside the working
+// tree, as a symlink to /etc/passwd would.
+func (d *worktreeDir) within(file string) (string, string, error) {
+	root, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return "", "", err
+	}
+	target, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", "", err
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", errSymlinkEscapes
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return target, filepath.ToSlash(rel), nil
+}
+
+// visible reports whether the slash separated path p is served: it is not
+// ignored, is in the sparse checkout, if any, and is allowed by the
+// filter.
+func (d *worktreeDir) visible(p string, isDir bool) (bool, error) {
+	ignored, err := d.excludes.Ignored(p, isDir)
+	if err != nil {
+		return false, err
+	}
+	return !ignored && d.sparse.Included(p, isDir) && d.filter.allowed(p, isDir), nil
+}
+
+func (d *worktreeDir) Stat(name string) (os.FileInfo, error) {
+	_, fi, err := d.resolve(name)
+	return fi, err
+}
+
+func (d *worktreeDir) OpenFile(name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	file, fi, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &worktreeFile{
+		File: f,
+		dir:  d,
+		p:    strings.Trim(path.Clean("/"+name), "/"),
+		fi:   fi,
+	}, nil
+}
+
+// worktreeFile is a read only file, or directory, in the working tree.
+type worktreeFile struct {
+	*os.File
+	dir *worktreeDir
+	p   string      // slash separated path relative to the root
+	fi  os.FileInfo // of the file, named as p is, even if p is a symlink
+}
+
+func (f *worktreeFile) Stat() (os.FileInfo, error) { return f.fi, nil }
+
+// Readdir returns the entries of the directory which are not ignored,
+// outside the sparse checkout, or hidden by the filter, with the
+// semantics of os.File's Readdir. Symlinks are described by the files
+// they refer to, and omitted if those are hidden, or outside the working
+// tree. If n > 0 and every entry read is hidden, more are read, so an
+// empty result means io.EOF.
+func (f *worktreeFile) Readdir(n int) ([]os.FileInfo, error) {
+	if f.dir.noListing {
+		if n > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	for {
+		fis, err := f.File.Readdir(n)
+		entries := fis[:0]
+		for _, fi := range fis {
+			p := path.Join(f.p, fi.Name())
+			if fi.Mode()&os.ModeSymlink != 0 {
+				_, target, rerr := f.dir.resolve(p)
+				switch {
+				case os.IsNotExist(rerr):
+					// dangling, hidden, or outside the working tree.
+				case rerr != nil:
+					return nil, rerr
+				default:
+					entries = append(entries, target)
+				}
+				continue
+			}
+			visible, verr := f.dir.visible(p, fi.IsDir())
+			if verr != nil {
+				return nil, verr
+			}
+			if visible {
+				entries = append(entries, fi)
+			}
+		}
+		if len(entries) > 0 || err != nil || n <= 0 {
+			return entries, err
+		}
+	}
+}
+
+func (f *worktreeFile) Write(p []byte) (int, error) { return 0, os.ErrInvalid }
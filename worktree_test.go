@@ -0,0 +1,344 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/davecheney/gitdav/internal/git"
+	"github.com/davecheney/gitdav/internal/gittest"
+)
+
+// newWorktreeDir returns a worktreeDir serving the working tree of a new
+// repository, and a directory outside it, holding a file called secret.
+func newWorktreeDir(t *testing.T) (*worktreeDir, string) {
+	t.Helper()
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	work, outside := filepath.Join(dir, "work"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(work, "dir"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gittest.Git(work, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.txt":                          "a\n",
+		"dir/b.txt":                      "b\n",
+		"ignored.log":                    "log\n",
+		".gitignore":                     "*.log\n",
+		filepath.Join(outside, "secret"): "secret\n",
+	}
+	for name, content := range files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(work, name)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"good":      "a.txt",
+		"gooddir":   "dir",
+		"evil":      filepath.Join(outside, "secret"),
+		"evildir":   outside,
+		"relevil":   "../outside/secret",
+		"dangling":  "missing",
+		"tolog":     "ignored.log",
+		"togit":     ".git/config",
+		"dir/up":    "../a.txt",
+		"dir/upout": "../../outside",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(work, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := git.Open(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	excludes, err := repo.Excludes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &worktreeDir{root: repo.Root, excludes: excludes}, outside
+}
+
+func TestWorktreeSymlinks(t *testing.T) {
+	d, _ := newWorktreeDir(t)
+	tests := []struct {
+		name    string
+		content string // "" if name must not be found
+		isDir   bool
+	}{
+		{name: "a.txt", content: "a\n"},
+		{name: "good", content: "a\n"},
+		{name: "gooddir", isDir: true},
+		{name: "gooddir/b.txt", content: "b\n"},
+		{name: "dir/up", content: "a\n"},
+		{name: "evil"},
+		{name: "evildir"},
+		{name: "evildir/secret"},
+		{name: "relevil"},
+		{name: "dir/upout"},
+		{name: "dir/upout/secret"},
+		{name: "dangling"},
+		{name: "tolog"},
+		{name: "togit"},
+		{name: "ignored.log"},
+	}
+	for _, tt := range tests {
+		fi, err := d.Stat(tt.name)
+		found := tt.content != "" || tt.isDir
+		if !found {
+			if !os.IsNotExist(err) {
+				t.Errorf("Stat(%q): got %v, %v, want not found", tt.name, fi, err)
+			}
+			if f, err := d.OpenFile(tt.name, os.O_RDONLY, 0); !os.IsNotExist(err) {
+				if err == nil {
+					f.Close()
+				}
+				t.Errorf("OpenFile(%q): got %v, want not found", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Stat(%q): %v", tt.name, err)
+			continue
+		}
+		if fi.Name() != filepath.Base(tt.name) || fi.IsDir() != tt.isDir {
+			t.Errorf("Stat(%q): got %q, dir %v", tt.name, fi.Name(), fi.IsDir())
+		}
+		if tt.isDir {
+			continue
+		}
+		f, err := d.OpenFile(tt.name, os.O_RDONLY, 0)
+		if err != nil {
+			t.Errorf("OpenFile(%q): %v", tt.name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || string(got) != tt.content {
+			t.Errorf("OpenFile(%q): read %q, %v, want %q", tt.name, got, err, tt.content)
+		}
+	}
+}
+
+func TestWorktreeReaddirSymlinks(t *testing.T) {
+	d, _ := newWorktreeDir(t)
+	for _, tt := range []struct {
+		dir  string
+		want []string
+	}{
+		{"", []string{".gitignore", "a.txt", "dir/", "good", "gooddir/"}},
+		{"dir", []string{"b.txt", "up"}},
+		{"gooddir", []string{"b.txt", "up"}},
+	} {
+		f, err := d.OpenFile(tt.dir, os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fis, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.IsDir() {
+				name += "/"
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if strings.Join(names, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Readdir of %q: got %q, want %q", tt.dir, names, tt.want)
+		}
+	}
+}
+
+// TestWorktreeSymlinksHTTP checks files outside the working tree cannot
+// be read, or listed, through symlinks, by WebDAV clients or browsers.
+func TestWorktreeSymlinksHTTP(t *testing.T) {
+	d, _ := newWorktreeDir(t)
+	h := fsHandler(d, blobs{})
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/good", http.StatusOK},
+		{"GET", "/evil", http.StatusNotFound},
+		{"GET", "/evildir/secret", http.StatusNotFound},
+		{"PROPFIND", "/evildir", http.StatusNotFound},
+		{"PROPFIND", "/gooddir", http.StatusMultiStatus},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.method, tt.path, "Depth", "1")
+		if w.Code != tt.want || strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s %s: got %d %q, want %d", tt.method, tt.path, w.Code, w.Body, tt.want)
+		}
+	}
+	w := serve(h, "PROPFIND", "/", "Depth", "1")
+	if strings.Contains(w.Body.String(), "evil") {
+		t.Errorf("PROPFIND /: lists a symlink outside the working tree: %s", w.Body)
+	}
+}
+
+// TestWorktreeSparse checks the files and directories served from a
+// sparse checkout, in cone mode and not, are those git checks out, as
+// git ls-files -t reports them, even if the others are on disk, and so
+// the directories holding them are listed.
+func TestWorktreeSparse(t *testing.T) {
+	if !gittest.Installed() {
+		t.Skip("git is not installed")
+	}
+	files := []string{
+		"top.txt",
+		"a/file.txt",
+		"a/b/file.txt",
+		"a/b/c/deep.txt",
+		"a/b/c/d/x.txt",
+		"a/x/y.txt",
+		"a/x/notes.md",
+		"other/o.txt",
+		"other/readme.md",
+	}
+	// the children of each directory, "" being the root.
+	children := make(map[string]map[string]bool)
+	for _, name := range files {
+		for p := name; p != "."; p = path.Dir(p) {
+			dir := strings.TrimPrefix(path.Dir(p), ".")
+			if children[dir] == nil {
+				children[dir] = make(map[string]bool)
+			}
+			children[dir][path.Base(p)] = true
+		}
+	}
+
+	tests := []struct {
+		name string
+		args [][]string // the git sparse-checkout commands run
+	}{
+		{"cone", [][]string{{"set", "--cone", "a/b/c"}}},
+		{"non-cone", [][]string{
+			// init writes /* and !/*/, including only the files at
+			// the root, so a and a/b are only included as parents.
+			{"init", "--no-cone"},
+			{"add", "/a/b/c/", "!/a/b/c/d/", "*.md"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			work := t.TempDir()
+			write := func() {
+				for _, name := range files {
+					file := filepath.Join(work, filepath.FromSlash(name))
+					if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := ioutil.WriteFile(file, []byte(name+"\n"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			run := func(args ...string) string {
+				t.Helper()
+				out, err := gittest.Git(work, args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out
+			}
+			run("init", "-q")
+			write()
+			run("add", ".")
+			run("commit", "-q", "-m", "sparse")
+			for _, args := range tt.args {
+				run(append([]string{"sparse-checkout"}, args...)...)
+			}
+			// the files git checks out, and the directories holding
+			// them.
+			want := map[string]bool{"": true}
+			for _, line := range strings.Split(strings.TrimSuffix(run("ls-files", "-t", "-z"), "\x00"), "\x00") {
+				if strings.HasPrefix(line, "H ") {
+					for p := line[2:]; p != "."; p = path.Dir(p) {
+						want[p] = true
+					}
+				}
+			}
+			// the files outside the sparse checkout, which git removed,
+			// are put back.
+			write()
+
+			repo, err := git.Open(work)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repo.Close()
+			excludes, err := repo.Excludes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sparse, err := repo.SparseCheckout()
+			if err != nil || sparse == nil {
+				t.Fatalf("SparseCheckout: got %v, %v", sparse, err)
+			}
+			d := &worktreeDir{root: repo.Root, excludes: excludes, sparse: sparse}
+
+			for _, name := range files {
+				for p := name; p != "."; p = path.Dir(p) {
+					fi, err := d.Stat(p)
+					// a directory git did not check out may be shown if a
+					// pattern may include a path below it, but it must
+					// appear empty.
+					if (err == nil) != want[p] && !(err == nil && fi.IsDir()) {
+						t.Errorf("Stat(%q): got %v, want found %v", p, err, want[p])
+					}
+				}
+			}
+			for dir, names := range children {
+				if _, err := d.Stat(dir); err != nil {
+					continue
+				}
+				f, err := d.OpenFile(dir, os.O_RDONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fis, err := f.Readdir(0)
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got, listed []string
+				for _, fi := range fis {
+					got = append(got, fi.Name())
+				}
+				for name := range names {
+					p := path.Join(dir, name)
+					if _, err := d.Stat(p); want[p] || err == nil && children[p] != nil {
+						listed = append(listed, name)
+					}
+				}
+				sort.Strings(got)
+				sort.Strings(listed)
+				if strings.Join(got, " ") != strings.Join(listed, " ") {
+					t.Errorf("Readdir(%q): got %q, want %q", dir, got, listed)
+				}
+			}
+		})
+	}
+}